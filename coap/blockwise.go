@@ -0,0 +1,284 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// hasMoreBlocks is true when resMsg is the first block of a block-wise
+// response (RFC 7959) and the remaining blocks must be requested.
+// Requests that ask for a specific block only get that block.
+func hasMoreBlocks(req *Request, resMsg *coapmsg.Message) bool {
+	if req.Options.Get(coapmsg.Block2).IsSet() {
+		return false
+	}
+	block2 := resMsg.Options().Get(coapmsg.Block2)
+	if !block2.IsSet() {
+		return false
+	}
+	block, err := coapmsg.ParseBlock(block2.AsBytes())
+	return err == nil && block.More
+}
+
+// maxBlockPreallocSize limits the buffer allocated for the Size2 of a block-wise response
+const maxBlockPreallocSize = 64 * 1024
+
+// fetchBlocks requests the remaining blocks of the block-wise response
+// resMsg and returns the complete payload.
+//
+// After a Block1 upload the response blocks are requested with the same
+// method but without payload and Block1 option (RFC 7959, 3.3).
+func (t *TransportUart) fetchBlocks(req *Request, resMsg *coapmsg.Message) ([]byte, error) {
+	block, err := coapmsg.ParseBlock(resMsg.Options().Get(coapmsg.Block2).AsBytes())
+	if err != nil {
+		return nil, err
+	}
+	// Size2 is only a hint of the server, implausible values are ignored
+	size := int(resMsg.Size2())
+	if size > maxBlockPreallocSize || size < len(resMsg.Payload) {
+		size = len(resMsg.Payload)
+	}
+	payload := append(make([]byte, 0, size), resMsg.Payload...)
+
+	for block.More {
+		var body []byte
+		body, block, err = t.fetchBlock(req, block, len(payload))
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, body...)
+	}
+	return payload, nil
+}
+
+// fetchBlock requests the block at offset and returns its payload and Block2 option.
+// The block size is taken from the last block, so the server can choose a smaller
+// size at any block of the transfer.
+func (t *TransportUart) fetchBlock(req *Request, last coapmsg.Block, offset int) ([]byte, coapmsg.Block, error) {
+	num := uint32(offset / last.Size())
+	blockReq := blockRequest(req, coapmsg.Block2, coapmsg.Block{Num: num, SZX: last.SZX})
+	blockReq.Options.Del(coapmsg.Block1)
+	res, err := t.RoundTrip(blockReq)
+	if err != nil {
+		return nil, last, wrapError(err, fmt.Sprint("Failed to fetch block ", num))
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, last, err
+	}
+	if !coapmsg.COAPCode(res.StatusCode).IsSuccess() {
+		return nil, last, errors.New(fmt.Sprint("coap: Failed to fetch block ", num, ": ", res.Status))
+	}
+
+	block2 := res.Options.Get(coapmsg.Block2)
+	if !block2.IsSet() {
+		return nil, last, errors.New(fmt.Sprint("coap: Missing Block2 option in response to block ", num))
+	}
+	block, err := coapmsg.ParseBlock(block2.AsBytes())
+	if err != nil {
+		return nil, last, err
+	}
+	// A smaller SZX changes the number of the block at the same offset (RFC 7959, 2.4)
+	if int(block.Num)*block.Size() != offset {
+		return nil, last, errors.New(fmt.Sprint("coap: Expected block at offset ", offset, " but got block ", block.Num, " of size ", block.Size()))
+	}
+	return body, block, nil
+}
+
+// blockReader is the Body of a streamed block-wise response,
+// see TransportUart.StreamBlockWiseResponses. Each block is
+// requested when the payload of the previous one is read.
+type blockReader struct {
+	t      *TransportUart
+	req    *Request // Carries ctx, Close cancels the running block request
+	cancel context.CancelFunc
+
+	block  coapmsg.Block // Block2 of the last received block
+	offset int           // Offset of the next block
+	buf    []byte        // Unread payload of the last block
+	err    error         // Sticky error of a failed block request
+	closed int32         // accessed atomically
+}
+
+func (t *TransportUart) newBlockReader(req *Request, resMsg *coapmsg.Message) (*blockReader, error) {
+	block, err := coapmsg.ParseBlock(resMsg.Options().Get(coapmsg.Block2).AsBytes())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	return &blockReader{
+		t:      t,
+		req:    req.WithContext(ctx),
+		cancel: cancel,
+		block:  block,
+		offset: len(resMsg.Payload),
+		buf:    resMsg.Payload,
+	}, nil
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if atomic.LoadInt32(&r.closed) != 0 {
+			return 0, ERR_BODY_CLOSED
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		if !r.block.More {
+			return 0, io.EOF
+		}
+
+		body, block, err := r.t.fetchBlock(r.req, r.block, r.offset)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		r.buf = body
+		r.block = block
+		r.offset += len(body)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// blockStream is implemented by bodies that are, or wrap, a blockReader
+type blockStream interface {
+	isBlockStream() bool
+}
+
+// isBlockStream is true if reading body to EOF requests further blocks
+func isBlockStream(body io.ReadCloser) bool {
+	stream, ok := body.(blockStream)
+	return ok && stream.isBlockStream()
+}
+
+func (r *blockReader) isBlockStream() bool {
+	return true
+}
+
+// Close stops the transfer, a running block request is canceled
+func (r *blockReader) Close() error {
+	atomic.StoreInt32(&r.closed, 1)
+	r.cancel()
+	return nil
+}
+
+// needsBlock1 is true when the request payload must be sent in Block1 blocks
+func (t *TransportUart) needsBlock1(req *Request, reqMsg *coapmsg.Message) bool {
+	maxBlockSize := t.maxBlockSize(req)
+	return maxBlockSize > 0 &&
+		(req.Method == "POST" || req.Method == "PUT") &&
+		!req.Options.Get(coapmsg.Block1).IsSet() &&
+		len(reqMsg.Payload) > maxBlockSize
+}
+
+// maxBlockSize is MaxBlockSize or the smaller max payload learned for the host
+func (t *TransportUart) maxBlockSize(req *Request) int {
+	if req.URL == nil {
+		return t.MaxBlockSize
+	}
+	learned := t.MaxPayload(req.URL.Host)
+	if learned > 0 && (t.MaxBlockSize == 0 || learned < t.MaxBlockSize) {
+		return learned
+	}
+	return t.MaxBlockSize
+}
+
+// MaxPayload returns the max request payload the host announced in the
+// Size1 option of a 4.13 Request Entity Too Large response, 0 if unknown.
+// Larger POST and PUT payloads to the host are sent in Block1 blocks.
+func (t *TransportUart) MaxPayload(host string) int {
+	t.payloadMu.Lock()
+	defer t.payloadMu.Unlock()
+	return t.maxPayload[host]
+}
+
+// learnMaxPayload remembers the Size1 of a 4.13 response for the host
+func (t *TransportUart) learnMaxPayload(req *Request, resMsg *coapmsg.Message) {
+	size1 := resMsg.Options().Get(coapmsg.Size1)
+	if req.URL == nil || resMsg.Code != coapmsg.RequestEntityTooLarge || !size1.IsSet() {
+		return
+	}
+	size := int(size1.AsUInt32())
+	if size == 0 {
+		return
+	}
+
+	t.payloadMu.Lock()
+	defer t.payloadMu.Unlock()
+	if t.maxPayload == nil {
+		t.maxPayload = make(map[string]int)
+	}
+	t.maxPayload[req.URL.Host] = size
+	log.WithField("host", req.URL.Host).
+		WithField("size", size).
+		Info("Learned max payload from 4.13 response")
+}
+
+// blockSZX returns the size exponent of the largest block that fits into size
+func blockSZX(size int) uint8 {
+	szx := uint8(0)
+	for szx < 6 && 1<<(szx+5) <= size {
+		szx++
+	}
+	return szx
+}
+
+// uploadBlocks sends the payload in Block1 blocks (RFC 7959) and returns
+// the response to the last block. Each block but the last is answered with
+// 2.31 Continue, the server can ask for smaller blocks in its Block1 option.
+func (t *TransportUart) uploadBlocks(req *Request, payload []byte) (*Response, error) {
+	szx := blockSZX(t.maxBlockSize(req))
+	offset := 0
+	for {
+		size := 1 << (szx + 4)
+		end := offset + size
+		more := end < len(payload)
+		if !more {
+			end = len(payload)
+		}
+
+		block := coapmsg.Block{Num: uint32(offset / size), More: more, SZX: szx}
+		blockReq := blockRequest(req, coapmsg.Block1, block)
+		blockReq.Body = ioutil.NopCloser(bytes.NewReader(payload[offset:end]))
+		res, err := t.RoundTrip(blockReq)
+		if err != nil {
+			return nil, err
+		}
+		// Any other response ends the transfer, e.g. 4.13 Request Entity Too Large
+		if !more || res.StatusCode != coapmsg.Continue.Number() {
+			res.Request = req
+			return res, nil
+		}
+		res.Body.Close()
+
+		if block1 := res.Options.Get(coapmsg.Block1); block1.IsSet() {
+			if b, err := coapmsg.ParseBlock(block1.AsBytes()); err == nil && b.SZX < szx {
+				szx = b.SZX
+			}
+		}
+		offset = end
+	}
+}
+
+// blockRequest returns a copy of req that carries the block option id.
+// All requests of a block-wise transfer use the token of req.
+func blockRequest(req *Request, id coapmsg.OptionId, block coapmsg.Block) *Request {
+	r2 := *req
+	r2.Body = ioutil.NopCloser(&bytes.Buffer{})
+	r2.Options = make(coapmsg.CoapOptions, len(req.Options))
+	for optId, opt := range req.Options {
+		r2.Options[optId] = opt
+	}
+	r2.Options.Set(id, block.Bytes())
+	return &r2
+}
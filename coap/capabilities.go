@@ -0,0 +1,33 @@
+package coap
+
+// Capabilities reports the features supported by a transport
+type Capabilities struct {
+	Observe   bool // Observe resources (RFC 7641)
+	BlockWise bool // Block-wise transfers (RFC 7959)
+	Multicast bool // Requests to multicast addresses
+	Reliable  bool // The underlying connection guarantees delivery
+}
+
+// CapabilityReporter is optionally implemented by a RoundTripper
+// to report the features it supports
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// capabilitiesFor returns the capabilities of the transport used for the scheme.
+// ok is false if the transport does not report its capabilities.
+func capabilitiesFor(rt RoundTripper, scheme string) (caps Capabilities, ok bool) {
+	if t, isTransport := rt.(*Transport); isTransport {
+		trans, err := t.transportFor(scheme)
+		if err != nil {
+			return
+		}
+		rt = trans
+	}
+
+	reporter, ok := rt.(CapabilityReporter)
+	if !ok {
+		return
+	}
+	return reporter.Capabilities(), true
+}
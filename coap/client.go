@@ -1,15 +1,16 @@
 package coap
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/lobaro/coap-go/coapmsg"
-	"github.com/sirupsen/logrus"
 )
 
 // RoundTripper is an interface representing the ability to execute a
@@ -78,22 +79,26 @@ type Client struct {
 
 	// CoAP spcifies the constant NSTART (default = 1) to limit
 	// the amount of parallel requests. 0 = no limit.
+	// Additional requests wait for a running request to finish.
 	// The default client has a value of 1 as proposed by the RFC.
 	// For an UART connection only 1 parallel request is supported.
 	MaxParallelRequests int32
-	runningRequests     int32
-	mu                  sync.Mutex
-}
-
-const NSTART = 5                                    // Default in CoAP Spec is 1. But we do support more.
-const POSTPONED_RESPONSE_TIMEOUT = 30 * time.Second // How long to wait for a CON after we got an non-piggyback ACK
 
-var log logrus.FieldLogger = logrus.StandardLogger()
+	// RetryPolicy decides if failed idempotent requests are retried.
+	// If nil, requests are not retried.
+	RetryPolicy RetryPolicy
 
-func SetLogger(logger logrus.FieldLogger) {
-	log = logger
+	runningRequests int32
+	slotFreed       chan struct{} // Closed when a request slot gets free
+	mu              sync.Mutex
 }
 
+const NSTART = 5 // Default in CoAP Spec is 1. But we do support more.
+
+// POSTPONED_RESPONSE_TIMEOUT is how long to wait for a CON after we got an non-piggyback ACK.
+// Without response in time the request fails with ERR_POSTPONED_RESPONSE_TIMEOUT.
+var POSTPONED_RESPONSE_TIMEOUT = 30 * time.Second
+
 // DefaultClient is the default Client and is used by Get, Head, and Post.
 var DefaultClient = NewClient()
 
@@ -108,10 +113,26 @@ func Get(url string) (*Response, error) {
 	return DefaultClient.Get(url)
 }
 
+func GetNon(url string) (*Response, error) {
+	return DefaultClient.GetNon(url)
+}
+
+func GetWithAccept(url string, accept coapmsg.MediaType) (*Response, error) {
+	return DefaultClient.GetWithAccept(url, accept)
+}
+
 func Ping(host string) (*Response, error) {
 	return DefaultClient.Ping(host)
 }
 
+func GetIfNoneMatch(url string, etag []byte, cachedBody []byte) (*Response, error) {
+	return DefaultClient.GetIfNoneMatch(url, etag, cachedBody)
+}
+
+func GetMulticast(url string, window time.Duration) ([]*Response, error) {
+	return DefaultClient.GetMulticast(url, window)
+}
+
 func Observe(url string) (*Response, error) {
 	return DefaultClient.Observe(url)
 }
@@ -124,19 +145,132 @@ func Post(url string, bodyType uint16, body io.Reader) (*Response, error) {
 	return DefaultClient.Post(url, bodyType, body)
 }
 
+func Put(url string, bodyType uint16, body io.Reader) (*Response, error) {
+	return DefaultClient.Put(url, bodyType, body)
+}
+
+func Delete(url string) (*Response, error) {
+	return DefaultClient.Delete(url)
+}
+
 func (c *Client) Do(req *Request) (res *Response, err error) {
-	c.mu.Lock()
-	if c.runningRequests >= c.MaxParallelRequests && c.MaxParallelRequests != 0 {
-		c.mu.Unlock()
-		return nil, errors.New(fmt.Sprint("MaxParallelRequests exhausted: ", c.MaxParallelRequests))
+	var policy RetryPolicy
+	if isIdempotent(req.Method) {
+		policy = c.RetryPolicy
+	}
+	return c.do(req, policy)
+}
+
+// DoContext is like Do but the request is canceled when ctx is done,
+// the error is ctx.Err() then.
+// The client Timeout is applied as deadline of ctx instead of the
+// Request.Cancel timer. Observe registrations get no deadline, their
+// notifications are received until ctx is done.
+func (c *Client) DoContext(ctx context.Context, req *Request) (*Response, error) {
+	if c.Timeout > 0 && req.Options.Get(coapmsg.Observe).IsNotSet() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		res, err := c.doContext(ctx, req)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// The Timeout includes reading a streamed block-wise body
+		res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+		return res, nil
+	}
+	return c.doContext(ctx, req)
+}
+
+func (c *Client) doContext(ctx context.Context, req *Request) (*Response, error) {
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return res, err
+}
+
+// cancelOnCloseBody cancels the context of the request when the body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) isBlockStream() bool {
+	return isBlockStream(b.ReadCloser)
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// do sends the request and retries it as long as policy allows, nil never retries
+func (c *Client) do(req *Request, policy RetryPolicy) (res *Response, err error) {
+	if policy != nil {
+		return c.sendWithRetry(req, policy)
+	}
+	return c.sendInSlot(req)
+}
+
+// sendInSlot sends the request in one of the MaxParallelRequests slots.
+// The slot is released when the response is received, retries wait without slot.
+func (c *Client) sendInSlot(req *Request) (*Response, error) {
+	if err := c.acquireRequestSlot(req.Context()); err != nil {
+		req.closeBody()
+		return nil, err
 	}
-	atomic.AddInt32(&c.runningRequests, 1)
-	c.mu.Unlock()
-	res, err = c.send(req)
+	defer c.releaseRequestSlot()
+
+	return c.send(req)
+}
 
-	atomic.AddInt32(&c.runningRequests, -1)
+// ERR_REQUEST_SLOT_TIMEOUT is returned when no request slot got free within the client Timeout
+var ERR_REQUEST_SLOT_TIMEOUT error = &coapError{err: "coap: Timeout while waiting for MaxParallelRequests", timeout: true}
 
-	return
+// acquireRequestSlot waits until less than MaxParallelRequests requests
+// are running (NSTART) or ctx is done. The client Timeout includes the wait.
+func (c *Client) acquireRequestSlot(ctx context.Context) error {
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		timer := time.NewTimer(c.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		c.mu.Lock()
+		if c.MaxParallelRequests == 0 || c.runningRequests < c.MaxParallelRequests {
+			c.runningRequests++
+			c.mu.Unlock()
+			return nil
+		}
+		if c.slotFreed == nil {
+			c.slotFreed = make(chan struct{})
+		}
+		freed := c.slotFreed
+		c.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return ERR_REQUEST_SLOT_TIMEOUT
+		}
+	}
+}
+
+// releaseRequestSlot wakes up all requests waiting in acquireRequestSlot
+func (c *Client) releaseRequestSlot() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runningRequests--
+	if c.slotFreed != nil {
+		close(c.slotFreed)
+		c.slotFreed = nil
+	}
 }
 
 // Get issues a GET to the specified URL.
@@ -154,6 +288,75 @@ func (c *Client) Get(url string) (*Response, error) {
 	return c.Do(req)
 }
 
+// GetNon issues a GET as non-confirmable (NON) request, which is not retransmitted.
+// It still waits for the NON response of the server, which is not
+// retransmitted either when it gets lost. Use Request.SuppressResponse
+// when the server sends no response at all.
+func (c *Client) GetNon(url string) (*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req.SetConfirmable(false))
+}
+
+// GetWithAccept issues a GET that requests the representation in the
+// content format accept. A server that can not provide it answers
+// 4.06 Not Acceptable, which is returned as normal response with that status.
+func (c *Client) GetWithAccept(url string, accept coapmsg.MediaType) (*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Options.Set(coapmsg.Accept, accept); err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// GetIfNoneMatch issues a GET that is validated with the ETag of a cached
+// representation. When the server answers 2.03 Valid the cached body is
+// still fresh and returned as Body. The response keeps the 2.03 status and
+// the options of the server, e.g. the ETag and a new Max-Age.
+// Other responses are returned like for Get, store their ETag to validate later.
+func (c *Client) GetIfNoneMatch(url string, etag []byte, cachedBody []byte) (*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Options.Set(coapmsg.ETag, etag); err != nil {
+		return nil, err
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == coapmsg.Valid.Number() {
+		res.Body.Close()
+		res.Body = ioutil.NopCloser(bytes.NewReader(cachedBody))
+	}
+	return res, nil
+}
+
+// GetMulticast issues a NON GET, e.g. to the All CoAP Nodes address
+// coap://224.0.1.187/.well-known/core or coap://[ff02::fd]/.well-known/core,
+// and returns the responses of all servers that arrive within window.
+// The transport for the URL scheme must implement MulticastRoundTripper.
+func (c *Client) GetMulticast(url string, window time.Duration) ([]*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Confirmable = false
+
+	rt, err := multicastTransportFor(c.transport(), req.URL.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return rt.RoundTripMulticast(req, window)
+}
+
 // Ping issues a CoAP Ping to the specified URL.
 // Which is effectively and empty CON message that will be answered with RST
 //
@@ -174,21 +377,252 @@ func (c *Client) Ping(host string) (*Response, error) {
 // response will stop the observation and notifies the server.
 //
 func (c *Client) Observe(url string) (*Response, error) {
+	return c.observeContext(context.Background(), url, DefaultObserveOptions)
+}
+
+// ObserveOptions control how the observation is registered, see ObserveOpts
+type ObserveOptions struct {
+	// Confirmable sends the registration as CON request that is
+	// retransmitted until the server acknowledges it
+	Confirmable bool
+	// Token of the registration, a new token is generated when empty
+	Token Token
+	// Accept is the content format requested for all notifications,
+	// e.g. coapmsg.AppJSON.Ptr(). nil sends no Accept option.
+	Accept *coapmsg.MediaType
+	// BufferSize is the number of notifications that are buffered in
+	// Response.Next for a slow consumer, see Request.NotificationBufferSize
+	BufferSize int
+	// RegisterRetry retries a failed registration, e.g. after a timeout.
+	// It does not affect the notifications of a registered observe.
+	// nil uses the RetryPolicy of the client.
+	RegisterRetry RetryPolicy
+}
+
+// DefaultObserveOptions are used by Observe
+var DefaultObserveOptions = ObserveOptions{
+	Confirmable: true,
+}
+
+// ObserveOpts is like Observe but the registration request is built from opts
+func (c *Client) ObserveOpts(url string, opts ObserveOptions) (*Response, error) {
+	return c.observeContext(context.Background(), url, opts)
+}
+
+// observeContext is like ObserveOpts but notifications are only received until ctx is done
+func (c *Client) observeContext(ctx context.Context, url string, opts ObserveOptions) (*Response, error) {
 	req, err := NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
+	req.Confirmable = opts.Confirmable
+	req.Token = opts.Token
+	req.NotificationBufferSize = opts.BufferSize
+	if opts.Accept != nil {
+		if err := req.Options.Set(coapmsg.Accept, *opts.Accept); err != nil {
+			return nil, err
+		}
+	}
+
+	if caps, ok := c.Capabilities(req.URL.Scheme); ok && !caps.Observe {
+		return nil, errors.New("coap: observe not supported by " + req.URL.Scheme)
+	}
 
 	err = req.Options.Add(coapmsg.Observe, 0)
 	if err != nil {
 		return nil, err
 	}
+	if opts.RegisterRetry != nil {
+		return c.do(req, opts.RegisterRetry)
+	}
 	return c.Do(req)
 }
 
+// ObserveChan starts to observe the given URL and streams all
+// notifications decoded by the decode func to the returned channel.
+//
+// The initial response is the first value in the channel.
+// Calling the returned cancel func stops the observation and notifies the server.
+// The channel is closed when the observation ends, this also happens
+// when decode returns an error.
+func (c *Client) ObserveChan(url string, decode func(*Response) (interface{}, error)) (<-chan interface{}, func(), error) {
+	res, err := c.Observe(url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(chan interface{})
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+
+	go func() {
+		defer close(values)
+
+		current := res
+		for {
+			val, err := decode(current)
+			if err != nil {
+				log.WithError(err).WithField("url", url).Warn("Failed to decode notification, stop observe")
+				c.cancelObserveChan(res)
+				return
+			}
+
+			select {
+			case values <- val:
+			case <-done:
+				c.cancelObserveChan(res)
+				return
+			}
+
+			select {
+			case next, ok := <-res.Next():
+				if !ok {
+					// Observation ended, e.g. by the server
+					return
+				}
+				current = next
+			case <-done:
+				c.cancelObserveChan(res)
+				return
+			}
+		}
+	}()
+
+	return values, cancel, nil
+}
+
+// Backoff between the attempts of ObservePersistent to observe again
+var ObservePersistentMinBackoff = 1 * time.Second
+var ObservePersistentMaxBackoff = 60 * time.Second
+
+// ObservePersistent observes the given URL until ctx is done and calls fn
+// for the initial response and every notification.
+//
+// When the observation ends unexpectedly, e.g. because the serial connection
+// was lost or the request failed, the resource is observed again with an
+// exponential backoff. When no notification arrives within Max-Age, e.g.
+// after a device reset, the transport ends the observation as stale and the
+// resource is observed again as well. When ctx is done the observation is
+// canceled and ctx.Err() is returned.
+func (c *Client) ObservePersistent(ctx context.Context, url string, fn func(*Response)) error {
+	backoff := ObservePersistentMinBackoff
+	for {
+		observeCtx, stopObserve := context.WithCancel(context.Background())
+		res, err := c.observeContext(observeCtx, url, DefaultObserveOptions)
+		if err != nil {
+			log.WithError(err).WithField("url", url).Warn("Failed to observe, trying again")
+		} else {
+			backoff = ObservePersistentMinBackoff
+			fn(res)
+			if done := c.waitForNotifications(ctx, res, fn); done {
+				c.cancelObserveChan(res)
+				stopObserve()
+				return ctx.Err()
+			}
+			log.WithField("url", url).Info("Observation ended, observe again")
+		}
+		stopObserve()
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > ObservePersistentMaxBackoff {
+			backoff = ObservePersistentMaxBackoff
+		}
+	}
+}
+
+// waitForNotifications calls fn for all notifications of res
+// until the observation ends or ctx is done. Returns true if ctx is done.
+func (c *Client) waitForNotifications(ctx context.Context, res *Response, fn func(*Response)) (done bool) {
+	for {
+		select {
+		case next, ok := <-res.Next():
+			if !ok {
+				return false
+			}
+			fn(next)
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+func (c *Client) cancelObserveChan(res *Response) {
+	if _, err := c.CancelObserve(res); err != nil {
+		log.WithError(err).Warn("Failed to cancel observe")
+	}
+}
+
 // CancelObserve tells the server to stop sending Notifications
 // about the endpoint related to the given response.
+// The cancellation is confirmable if the observe request was.
+// The returned response is the final response of the server,
+// e.g. to confirm the deregistration or read a last payload.
 func (c *Client) CancelObserve(response *Response) (*Response, error) {
+	return c.CancelObserveConfirmable(response, response.Request.Confirmable)
+}
+
+// CancelObserveConfirmable is like CancelObserve but sends the cancellation
+// as CON or NON request. Some servers only process NON cancellations promptly.
+func (c *Client) CancelObserveConfirmable(response *Response, confirmable bool) (*Response, error) {
+	req, err := cancelObserveRequest(response, confirmable)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// ERR_CANCEL_OBSERVE_TIMEOUT is returned by CancelObserveWait when the server did not
+// acknowledge the cancellation in time. The server might still send notifications.
+var ERR_CANCEL_OBSERVE_TIMEOUT error = &coapError{err: "coap: Timeout while waiting for the observe cancellation", timeout: true}
+
+// CancelObserveWait cancels the observe with a CON request and blocks until
+// the server acknowledged it or timeout is over. When it returns without error
+// the interaction is closed and Next of the response is closed as well,
+// notifications that were not read yet are dropped.
+func (c *Client) CancelObserveWait(response *Response, timeout time.Duration) (*Response, error) {
+	req, err := cancelObserveRequest(response, true)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ERR_CANCEL_OBSERVE_TIMEOUT
+		}
+		return nil, err
+	}
+
+	if response.next != nil {
+		for {
+			select {
+			case _, ok := <-response.next:
+				if !ok {
+					return res, nil
+				}
+			case <-ctx.Done():
+				return nil, ERR_CANCEL_OBSERVE_TIMEOUT
+			}
+		}
+	}
+	return res, nil
+}
+
+// cancelObserveRequest creates the request to deregister the observe of response
+func cancelObserveRequest(response *Response, confirmable bool) (*Request, error) {
 	req, err := NewRequest("GET", response.Request.URL.String(), nil)
 	if err != nil {
 		return nil, err
@@ -198,8 +632,51 @@ func (c *Client) CancelObserve(response *Response) (*Response, error) {
 		return nil, err
 	}
 	req.Token = response.Request.Token
+	req.Confirmable = confirmable
+	return req, nil
+}
 
-	return c.Do(req)
+// ObservationFinder is implemented by transports that can list
+// the running observations of a resource
+type ObservationFinder interface {
+	ObservingTokens(u *url.URL) []Token
+}
+
+// CancelObservesForURL cancels all observations of the resource at url,
+// e.g. when it was observed several times with different tokens.
+// The cancellations are sent as CON requests.
+func (c *Client) CancelObservesForURL(url string) error {
+	finder, ok := c.transport().(ObservationFinder)
+	if !ok {
+		return errors.New("coap: transport can not find observations")
+	}
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, token := range finder.ObservingTokens(req.URL) {
+		cancelReq, err := NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		if err := cancelReq.Options.Add(coapmsg.Observe, 1); err != nil {
+			return err
+		}
+		cancelReq.Token = token
+
+		res, err := c.Do(cancelReq)
+		if err != nil {
+			log.WithError(err).WithField("token", token).Warn("Failed to cancel observe")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		res.Body.Close()
+	}
+	return firstErr
 }
 
 // Post issues a POST to the specified URL.
@@ -222,9 +699,45 @@ func (c *Client) Post(url string, bodyType uint16, body io.Reader) (*Response, e
 	return c.Do(req)
 }
 
+// Put issues a PUT to the specified URL.
+//
+// Caller should close resp.Body when done reading from it.
+//
+// If the provided body is an io.Closer, it is closed after the
+// request.
+//
+// To set custom headers, use NewRequest and Client.Do.
+func (c *Client) Put(url string, bodyType uint16, body io.Reader) (*Response, error) {
+	req, err := NewRequest("PUT", url, body)
+	if err != nil {
+		return nil, err
+	}
+	err = req.Options.Set(coapmsg.ContentFormat, bodyType)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Delete issues a DELETE to the specified URL.
+//
+// Caller should close resp.Body when done reading from it.
+func (c *Client) Delete(url string) (*Response, error) {
+	req, err := NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
 func (c *Client) send(req *Request) (*Response, error) {
+	deadline := c.deadline()
+	// A request context with deadline cancels the request, no timer is needed
+	if _, ok := req.Context().Deadline(); ok {
+		deadline = time.Time{}
+	}
 
-	resp, err := send(req, c.transport(), c.deadline())
+	resp, err := send(req, c.transport(), deadline)
 	if err != nil {
 		return nil, err
 	}
@@ -238,6 +751,12 @@ func (c *Client) deadline() time.Time {
 	return time.Time{}
 }
 
+// Capabilities returns the features supported by the transport used for the URL scheme.
+// ok is false if the transport does not report its capabilities.
+func (c *Client) Capabilities(scheme string) (caps Capabilities, ok bool) {
+	return capabilitiesFor(c.transport(), scheme)
+}
+
 func (c *Client) transport() RoundTripper {
 	if c.Transport != nil {
 		return c.Transport
@@ -363,6 +882,10 @@ type cancelTimerBody struct {
 	reqWasCanceled func() bool
 }
 
+func (b *cancelTimerBody) isBlockStream() bool {
+	return isBlockStream(b.rc)
+}
+
 func (b *cancelTimerBody) Read(p []byte) (n int, err error) {
 	n, err = b.rc.Read(p)
 	if err == nil {
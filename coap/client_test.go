@@ -1,8 +1,17 @@
 package coap
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
 )
 
 type recordingTransport struct {
@@ -58,3 +67,620 @@ func TestGetRequestFormat(t *testing.T) {
 		t.Errorf("expected non-nil request Options")
 	}
 }
+
+func TestPutAndDeleteRequestFormat(t *testing.T) {
+	tr := &recordingTransport{}
+	client := &Client{Transport: tr}
+	url := "coap+uart://any/foo"
+
+	_, err := client.Put(url, uint16(coapmsg.AppJSON), strings.NewReader("{}"))
+	if err != nil && err.Error() != "dummy impl" {
+		t.Error(err)
+	}
+	if tr.req.Method != "PUT" {
+		t.Errorf("expected method %q; got %q", "PUT", tr.req.Method)
+	}
+	if tr.req.Options.Get(coapmsg.ContentFormat).AsUInt16() != uint16(coapmsg.AppJSON) {
+		t.Errorf("expected content format %d; got %v", coapmsg.AppJSON, tr.req.Options.Get(coapmsg.ContentFormat))
+	}
+
+	_, err = client.Delete(url)
+	if err != nil && err.Error() != "dummy impl" {
+		t.Error(err)
+	}
+	if tr.req.Method != "DELETE" {
+		t.Errorf("expected method %q; got %q", "DELETE", tr.req.Method)
+	}
+	if tr.req.URL.String() != url {
+		t.Errorf("expected URL %q; got %q", url, tr.req.URL.String())
+	}
+}
+
+func TestClientObserveChan(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Wait some time before sending the notification
+		time.Sleep(500 * time.Millisecond)
+
+		notify := coapmsg.NewMessage()
+		notify.Type = coapmsg.Confirmable
+		notify.Code = coapmsg.Content
+		notify.MessageID = 100
+		notify.Token = msg.Token
+		notify.Payload = []byte("2")
+		notify.Options().Add(coapmsg.Observe, 2)
+		if err := testCon.ServerSend(notify); err != nil {
+			t.Error(err)
+		}
+
+		// ACK for the notification
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		if msg.Type != coapmsg.Acknowledgement {
+			t.Error("Expected ACK for notification but got", msg.Type)
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		if msg.Options().Get(coapmsg.Observe).AsUInt8() != 1 {
+			t.Error("Expected cancel observe (=1) option")
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Token = msg.Token
+		ack.Code = coapmsg.Content
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+		asyncDoneChan <- true
+	}()
+
+	values, cancel, err := client.ObserveChan("coap+uart://any/o", func(res *Response) (interface{}, error) {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.Atoi(string(body))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{1, 2} {
+		select {
+		case val := <-values:
+			if val.(int) != expected {
+				t.Errorf("Expected value %d but got %v", expected, val)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("Timeout while waiting for value", expected)
+		}
+	}
+
+	cancel()
+	<-asyncDoneChan
+
+	select {
+	case _, ok := <-values:
+		if ok {
+			t.Error("Expected values channel to be closed")
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("Timeout while waiting for values channel to be closed")
+	}
+
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestClientObservePersistentResumesAfterConnectionLoss(t *testing.T) {
+	defer func(backoff time.Duration) { ObservePersistentMinBackoff = backoff }(ObservePersistentMinBackoff)
+	ObservePersistentMinBackoff = 50 * time.Millisecond
+
+	client, testCon := NewTestClient(t)
+
+	// register answers the next observe registration with the given payload
+	register := func(payload string) coapmsg.Message {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return msg
+		}
+		if msg.Options().Get(coapmsg.Observe).AsUInt8() != 0 {
+			t.Error("Expected observe registration (=0) option")
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte(payload)
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+		return msg
+	}
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		register("1")
+
+		// Wait some time before dropping the connection
+		time.Sleep(500 * time.Millisecond)
+		testCon.conn.Close()
+
+		msg := register("2")
+
+		// Wait some time before sending the notification
+		time.Sleep(500 * time.Millisecond)
+
+		notify := coapmsg.NewMessage()
+		notify.Type = coapmsg.Confirmable
+		notify.Code = coapmsg.Content
+		notify.MessageID = 100
+		notify.Token = msg.Token
+		notify.Payload = []byte("3")
+		notify.Options().Add(coapmsg.Observe, 2)
+		if err := testCon.ServerSend(notify); err != nil {
+			t.Error(err)
+		}
+
+		// ACK for the notification
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		if msg.Type != coapmsg.Acknowledgement {
+			t.Error("Expected ACK for notification but got", msg.Type)
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Options().Get(coapmsg.Observe).AsUInt8() != 1 {
+			t.Error("Expected cancel observe (=1) option")
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Token = msg.Token
+		ack.Code = coapmsg.Content
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bodies := make(chan string, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.ObservePersistent(ctx, "coap+uart://any/o", func(res *Response) {
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies <- string(body)
+		})
+	}()
+
+	for _, expected := range []string{"1", "2", "3"} {
+		select {
+		case body := <-bodies:
+			if body != expected {
+				t.Errorf("Expected body %s but got %s", expected, body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout while waiting for body %s", expected)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("Expected %s but got: %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout while waiting for ObservePersistent to return")
+	}
+	<-asyncDoneChan
+}
+
+func TestClientCancelObservesForURL(t *testing.T) {
+	trans := NewTransportUart()
+	runCancelObservesForURL(t, trans, trans)
+}
+
+func TestClientCancelObservesForURLWithTransport(t *testing.T) {
+	trans := NewTransportUart()
+	runCancelObservesForURL(t, trans, &Transport{TransUart: trans})
+}
+
+// runCancelObservesForURL cancels two observations of trans that are sent through rt
+func runCancelObservesForURL(t *testing.T, trans *TransportUart, rt RoundTripper) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = rt
+
+	serverDone := make(chan bool)
+	go func() {
+		defer close(serverDone)
+		// Two registrations of the same path
+		for i := 0; i < 2; i++ {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			ack.Options().Add(coapmsg.Observe, 1)
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+
+		// Both must be canceled
+		for i := 0; i < 2; i++ {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if msg.Options().Get(coapmsg.Observe).AsUInt8() != 1 {
+				t.Errorf("Expected cancel observe but got %s", msg.String())
+			}
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	res1, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res1.Request.Token.Equals(res2.Request.Token) {
+		t.Fatal("Expected observations with different tokens")
+	}
+
+	if err := client.CancelObservesForURL("coap+uart://any/o"); err != nil {
+		t.Error(err)
+	}
+	<-serverDone
+
+	for _, res := range []*Response{res1, res2} {
+		select {
+		case _, ok := <-res.Next():
+			if ok {
+				t.Error("Expected no more notifications")
+			}
+		case <-time.After(3 * time.Second):
+			t.Error("Expected observation to end")
+		}
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestObserveRegistrationIsRetried(t *testing.T) {
+	oldAckTimeout := AckTimeout
+	AckTimeout = 100 * time.Millisecond
+	defer func() { AckTimeout = oldAckTimeout }()
+
+	client, testCon := NewTestClient(t)
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		// The first registration times out
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+			return
+		}
+
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Options().Get(coapmsg.Observe).IsNotSet() {
+			t.Error("Expected the retry to register the observe")
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	opts := DefaultObserveOptions
+	opts.RegisterRetry = ExponentialRetryPolicy{MaxRetries: 2, InitialDelay: 10 * time.Millisecond}
+	res, err := client.ObserveOpts("coap+uart://any/o", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Next() == nil {
+		t.Error("Expected an observe response")
+	}
+
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Error(err)
+	}
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+// validTransport answers requests with a matching ETag with 2.03 Valid
+type validTransport struct {
+	etag []byte
+	req  *Request
+}
+
+func (t *validTransport) RoundTrip(req *Request) (*Response, error) {
+	t.req = req
+	opts := coapmsg.CoapOptions{}
+	opts.Set(coapmsg.ETag, t.etag)
+	if bytes.Equal(req.Options.Get(coapmsg.ETag).AsBytes(), t.etag) {
+		return NewResponse(coapmsg.Valid, nil, opts), nil
+	}
+	return NewResponse(coapmsg.Content, []byte("fresh"), opts), nil
+}
+
+func TestGetIfNoneMatch(t *testing.T) {
+	tr := &validTransport{etag: []byte{0xab, 0xcd}}
+	client := &Client{Transport: tr}
+
+	res, err := client.GetIfNoneMatch("coap+uart://any/foo", []byte{0xab, 0xcd}, []byte("cached"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tr.req.Options.Get(coapmsg.ETag).AsBytes(), []byte{0xab, 0xcd}) {
+		t.Errorf("Expected ETag option in request but got %v", tr.req.Options.Get(coapmsg.ETag))
+	}
+	if res.StatusCode != coapmsg.Valid.Number() {
+		t.Errorf("Expected 2.03 Valid but got %s", res.Status)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "cached" {
+		t.Errorf("Expected cached body but got %s", body)
+	}
+
+	// An outdated ETag gets the new representation and its ETag
+	res, err = client.GetIfNoneMatch("coap+uart://any/foo", []byte{0x01}, []byte("cached"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	if res.StatusCode != coapmsg.Content.Number() || string(body) != "fresh" {
+		t.Errorf("Expected 2.05 Content with fresh body but got %s %s", res.Status, body)
+	}
+	if !bytes.Equal(res.ETag(), []byte{0xab, 0xcd}) {
+		t.Errorf("Expected ETag of the new representation but got %v", res.ETag())
+	}
+}
+
+func TestGetWithAccept(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if accept := msg.Options().Get(coapmsg.Accept); accept.IsNotSet() || accept.AsUInt16() != uint16(coapmsg.AppJSON) {
+			t.Errorf("Expected Accept option %d but got %v", coapmsg.AppJSON, accept)
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.NotAcceptable
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.GetWithAccept("coap+uart://any/foo", coapmsg.AppJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.NotAcceptable.Number() {
+		t.Errorf("Expected 4.06 Not Acceptable but got %s", res.Status)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestDoContextCanceled(t *testing.T) {
+	client, testCon := NewTestClient(t)
+	client.Timeout = 10 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// Cancel instead of answering the request
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+		}
+		cancel()
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	res, err := client.DoContext(ctx, req)
+	if res != nil {
+		t.Error("Expected no response")
+	}
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected to return right after cancel but took %s", d)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestDoContextTimeout(t *testing.T) {
+	client, testCon := NewTestClient(t)
+	client.Timeout = 100 * time.Millisecond
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.DoContext(context.Background(), req)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded but got %v", err)
+	}
+	if _, err := testCon.ServerReceive(time.Second); err != nil {
+		t.Error(err)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+// slowTransport answers every request after a delay and records the maximal parallel requests
+type slowTransport struct {
+	mu          sync.Mutex
+	running     int
+	maxParallel int
+}
+
+func (t *slowTransport) RoundTrip(req *Request) (*Response, error) {
+	t.mu.Lock()
+	t.running++
+	if t.running > t.maxParallel {
+		t.maxParallel = t.running
+	}
+	t.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	t.mu.Lock()
+	t.running--
+	t.mu.Unlock()
+	return NewResponse(coapmsg.Content, nil, coapmsg.CoapOptions{}), nil
+}
+
+func TestMaxParallelRequestsWaitForSlot(t *testing.T) {
+	tr := &slowTransport{}
+	client := &Client{Transport: tr, MaxParallelRequests: 2}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Get("coap+uart://any/foo"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tr.maxParallel != 2 {
+		t.Errorf("Expected 2 parallel requests but got %d", tr.maxParallel)
+	}
+}
+
+func TestMaxParallelRequestsWaitCanceled(t *testing.T) {
+	client := &Client{Transport: &slowTransport{}, MaxParallelRequests: 1}
+	// Take the only slot
+	if err := client.acquireRequestSlot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded but got %v", err)
+	}
+
+	client.releaseRequestSlot()
+	if _, err := client.Do(req); err != nil {
+		t.Errorf("Expected request to pass after release but got %v", err)
+	}
+}
+
+func TestClientGetNon(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.NonConfirmable {
+			t.Errorf("Expected NON request but got %s", msg.Type)
+		}
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 1000
+		res.Token = msg.Token
+		res.Payload = []byte("non")
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.GetNon("coap+uart://any/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != coapmsg.Content.Number() || string(body) != "non" {
+		t.Errorf("Expected 2.05 Content with body non but got %s %s", res.Status, body)
+	}
+	ValidateCleanConnection(t, testCon)
+}
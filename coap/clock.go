@@ -0,0 +1,83 @@
+package coap
+
+import (
+	"context"
+	"time"
+)
+
+// Clock provides the time to the transport and its interactions.
+// Tests can set a fake clock to trigger timeouts without waiting.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of a *time.Timer used with a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// RealClock uses the time package
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// clockProvider is implemented by transports with a Clock
+type clockProvider interface {
+	getClock() Clock
+}
+
+// clockFor returns the Clock of the transport used for the scheme, RealClock if it has none
+func clockFor(rt RoundTripper, scheme string) Clock {
+	if t, isTransport := rt.(*Transport); isTransport {
+		trans, err := t.transportFor(scheme)
+		if err != nil {
+			return RealClock{}
+		}
+		rt = trans
+	}
+
+	if provider, ok := rt.(clockProvider); ok {
+		return provider.getClock()
+	}
+	return RealClock{}
+}
+
+// withClockTimeout works like context.WithTimeout but the timeout is measured by clock
+func withClockTimeout(parent context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(RealClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	timer := clock.NewTimer(d)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
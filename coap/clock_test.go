@@ -0,0 +1,141 @@
+package coap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// fakeClock only moves on Advance
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the time forward and fires all due timers
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+// waitForTimers waits until n timers are pending
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	for start := time.Now(); time.Since(start) < 3*time.Second; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		count := len(c.timers)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+	}
+	t.Errorf("Expected %d pending timers", n)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestRetransmitWithFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	trans.MaxRetransmit = 1
+	trans.Clock = clock
+
+	go func() {
+		first, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Let the ACK timeout expire without waiting for it
+		clock.waitForTimers(t, 1)
+		clock.Advance(ackTimeout())
+
+		retransmit, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if retransmit.MessageID != first.MessageID {
+			t.Errorf("Expected retransmission with message id %d but got %d", first.MessageID, retransmit.MessageID)
+		}
+		ack := coapmsg.NewAck(retransmit.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = retransmit.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= AckTimeout {
+		t.Errorf("Expected retransmission before the real ACK timeout but took %s", elapsed)
+	}
+	if res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.Content.Number(), res.StatusCode)
+	}
+	ValidateCleanConnection(t, testCon)
+}
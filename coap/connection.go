@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"sync"
 
 	"time"
 
 	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
 )
 
 // Connection represents an interface to identify
@@ -26,6 +30,13 @@ type Connection interface {
 
 	Name() string
 	//resetDeadline()
+
+	// Ping sends a CoAP ping and returns true when the expected RST was received
+	Ping(ctx context.Context) (bool, error)
+
+	// SerialParams returns the port and parameters in use for serial connections.
+	// ok is false for other connections.
+	SerialParams() (params SerialConnectionParams, ok bool)
 }
 
 type InteractionStore interface {
@@ -33,6 +44,9 @@ type InteractionStore interface {
 	StartInteraction(conn Connection, msg *coapmsg.Message) *Interaction
 	RemoveInteraction(ia *Interaction)
 	InteractionCount() int
+
+	// ObservingInteractions returns all interactions observing the path
+	ObservingInteractions(path string) []*Interaction
 }
 
 // Implemented by connections
@@ -56,14 +70,54 @@ func deleteConnection(a []Connection, i int) []Connection {
 }
 
 func sendMessage(conn Connection, msg *coapmsg.Message) error {
+	_, err := writeMessage(conn, msg, nil)
+	return err
+}
+
+// writeMessage sends the message and returns the written bytes.
+// The fields are added to the log entry.
+func writeMessage(conn Connection, msg *coapmsg.Message, fields logrus.Fields) ([]byte, error) {
 	bin := msg.MustMarshalBinary()
 
-	logMsg(msg, "Send")
+	logMsgWithFields(msg, "Send", fields)
 	err := conn.WritePacket(bin)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	rememberReply(conn, msg, bin)
+	return bin, nil
+}
+
+var msgIdRandMu sync.Mutex // Guards msgIdRand
+var msgIdRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// randomMessageId is used for messages sent outside of a transport,
+// which keeps track of its own message ids
+func randomMessageId() uint16 {
+	msgIdRandMu.Lock()
+	defer msgIdRandMu.Unlock()
+	return uint16(msgIdRand.Intn(0x10000))
+}
+
+// pingConnection sends a CoAP ping on conn and expects a RST.
+// The connection is kept open.
+func pingConnection(ctx context.Context, conn Connection) (bool, error) {
+	ping := coapmsg.NewPing(randomMessageId())
+
+	ia := conn.StartInteraction(conn, &ping)
+	defer ia.close(false)
+
+	res, err := ia.RoundTrip(ctx, &ping)
+
+	if res != nil && res.Type == coapmsg.Reset {
+		// We expect this error
+		return true, nil
+	}
+	resTypeStr := "nil"
+	if res != nil {
+		resTypeStr = res.Type.String()
 	}
-	return nil
+	return false, errors.New(fmt.Sprintf("Expected RST but got %s. Error: %s", resTypeStr, err))
 }
 
 func receiveLoop(ctx context.Context, conn Connection) {
@@ -78,7 +132,7 @@ func receiveLoop(ctx context.Context, conn Connection) {
 		if duration > 100*time.Millisecond {
 			log.WithField("duration", duration).Warn("Read took longer than 100ms")
 		}
-		msg, err := readMessage(ctx, conn)
+		msg, packet, err := readMessage(ctx, conn)
 
 		if ctx.Err() != nil {
 			log.WithError(ctx.Err()).Debug("Context done while read message. Stopped receive loop.")
@@ -100,8 +154,19 @@ func receiveLoop(ctx context.Context, conn Connection) {
 		}
 		start = time.Now()
 
-		ia := conn.FindInteraction(Token(msg.Token), MessageId(msg.MessageID))
+		var ia *Interaction
+		// Only ACK and RST echo the message id of the request. CON and NON
+		// messages, e.g. the response to a NON request, are matched by token.
+		if len(msg.Token) > 0 || msg.Type == coapmsg.Acknowledgement || msg.Type == coapmsg.Reset {
+			ia = conn.FindInteraction(Token(msg.Token), MessageId(msg.MessageID))
+		}
+		// Responses to a multicast request come from several servers,
+		// their message ids can not be told apart without the remote address
+		if (ia == nil || !ia.IsMulticast()) && isDuplicate(conn, msg) {
+			continue
+		}
 		if ia == nil {
+			logMsg(msg, "Received")
 			log.WithError(err).
 				WithField("token", msg.Token).
 				WithField("messageId", msg.MessageID).
@@ -113,7 +178,9 @@ func receiveLoop(ctx context.Context, conn Connection) {
 				log.WithError(err).Warn("Failed to send RST")
 			}
 		} else {
+			logMsgWithFields(msg, "Received", ia.metadata)
 			handleStart := time.Now()
+			ia.setLastReceived(packet)
 			ia.HandleMessage(msg)
 			duration = time.Since(handleStart)
 			if duration > 100*time.Millisecond {
@@ -124,39 +191,57 @@ func receiveLoop(ctx context.Context, conn Connection) {
 	}
 }
 
-func readMessage(ctx context.Context, reader PacketReader) (*coapmsg.Message, error) {
+// readMessage reads the next message and returns it together with the raw packet
+func readMessage(ctx context.Context, reader PacketReader) (*coapmsg.Message, []byte, error) {
 	var packet []byte
 	var err error
 	// Skip empty packets
 	for ; len(packet) == 0; packet, err = readPacket(ctx, reader) {
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	msg, err := coapmsg.ParseMessage(packet)
 	if err != nil {
-		return nil, wrapError(err, "Failed to parse CoAP message")
+		perr := newParseError(err, packet)
+		entry := log.WithError(err).WithField("Bin", packet)
+		if perr.HeaderValid {
+			entry = entry.WithField("Version", perr.Version).
+				WithField("Type", perr.Type.String()).
+				WithField("TokenLength", perr.TokenLength).
+				WithField("Code", perr.Code.String()).
+				WithField("MessageID", perr.MessageID)
+		}
+		entry.Warn("Failed to parse CoAP message")
+		return nil, packet, perr
 	}
-	logMsg(&msg, "Received")
 
-	return &msg, nil
+	return &msg, packet, nil
 }
 
+// ERR_CONNECTION_LOST is returned when the stream ends in the middle of a packet,
+// e.g. because the device was disconnected.
+var ERR_CONNECTION_LOST = errors.New("coap: Connection lost while reading packet")
+
+// readPacket assembles a packet from one or more prefix reads
 func readPacket(ctx context.Context, reader PacketReader) ([]byte, error) {
 	buf := &bytes.Buffer{}
 
-	var isPrefix bool
-
 	for {
-		var p []byte
 		p, isPrefix, err := reader.ReadPacket()
 		buf.Write(p)
 
+		// An EOF without data just means there is nothing to read yet. Once a
+		// frame has started the stream must not end before it is complete.
+		if err == io.EOF && len(p) == 0 && buf.Len() > 0 {
+			return nil, ERR_CONNECTION_LOST
+		}
+
 		if err != nil && err != io.EOF {
 			return nil, err
 		}
@@ -165,17 +250,15 @@ func readPacket(ctx context.Context, reader PacketReader) ([]byte, error) {
 			break
 		}
 
+		time.Sleep(10 * time.Millisecond)
+
+		// Check after sleeping, a canceled reader must not take the next packet
+		// from a new connection to the same port
 		select {
 		case <-ctx.Done():
 			return nil, errors.New("coap: Timeout while readPacket")
 		default:
 		}
-
-		time.Sleep(10 * time.Millisecond)
-	}
-
-	if isPrefix {
-		return nil, errors.New("coap: Did read incomplete response")
 	}
 
 	return buf.Bytes(), nil
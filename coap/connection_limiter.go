@@ -0,0 +1,54 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ERR_CONNECTION_LIMIT_REACHED = errors.New("coap: Connection limit reached")
+
+// ConnectionLimiter bounds the number of open connections.
+// A single limiter can be shared by multiple connectors
+// to bound the number of open ports process wide.
+type ConnectionLimiter struct {
+	// Block makes connectors wait for a free slot until the connect context is done.
+	// Otherwise connecting fails with ERR_CONNECTION_LIMIT_REACHED when all slots are taken.
+	Block bool
+
+	slots chan struct{}
+}
+
+func NewConnectionLimiter(max int) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		slots: make(chan struct{}, max),
+	}
+}
+
+// Acquire takes a slot for a new connection.
+// The returned release func frees the slot again, calling it more than once is safe.
+func (l *ConnectionLimiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l.Block {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			return nil, ERR_CONNECTION_LIMIT_REACHED
+		}
+	}
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { <-l.slots })
+	}, nil
+}
+
+// Open returns the number of taken slots
+func (l *ConnectionLimiter) Open() int {
+	return len(l.slots)
+}
@@ -0,0 +1,64 @@
+package coap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConnectionLimiterSharedByConnectors(t *testing.T) {
+	limiter := NewConnectionLimiter(1)
+	connectorA := NewUartConnecter()
+	connectorA.openPort = openFakeSerialPort
+	connectorA.Limiter = limiter
+	connectorB := NewUartConnecter()
+	connectorB.openPort = openFakeSerialPort
+	connectorB.Limiter = limiter
+
+	connA, err := connectorA.Connect("ttyA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := connectorB.Connect("ttyB"); err != ERR_CONNECTION_LIMIT_REACHED {
+		t.Errorf("Expected %s but got: %v", ERR_CONNECTION_LIMIT_REACHED, err)
+	}
+
+	connA.Close()
+	if limiter.Open() != 0 {
+		t.Errorf("Expected closed connection to free its slot but %d are taken", limiter.Open())
+	}
+
+	connB, err := connectorB.Connect("ttyB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connB.Close()
+	connB.Close()
+	if limiter.Open() != 0 {
+		t.Errorf("Expected closing twice to free a single slot but %d are taken", limiter.Open())
+	}
+}
+
+func TestConnectionLimiterBlocksUntilContextDone(t *testing.T) {
+	limiter := NewConnectionLimiter(1)
+	limiter.Block = true
+	connectorA := NewUartConnecter()
+	connectorA.openPort = openFakeSerialPort
+	connectorA.Limiter = limiter
+	connectorB := NewUartConnecter()
+	connectorB.openPort = openFakeSerialPort
+	connectorB.Limiter = limiter
+
+	connA, err := connectorA.Connect("ttyA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connA.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := connectorB.ConnectContext(ctx, "ttyB"); err != context.DeadlineExceeded {
+		t.Errorf("Expected %s but got: %v", context.DeadlineExceeded, err)
+	}
+}
@@ -1,8 +1,16 @@
 package coap
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"sync"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 )
 
 type TestConnection struct {
@@ -43,6 +51,7 @@ func (c *TestConnection) Close() error {
 	c.closed = true
 
 	c.cancelReceiveLoop()
+	c.connectionClosed()
 
 	return nil
 }
@@ -51,6 +60,14 @@ func (c *TestConnection) Closed() bool {
 	return c.closed
 }
 
+func (c *TestConnection) SerialParams() (SerialConnectionParams, bool) {
+	return SerialConnectionParams{}, false
+}
+
+func (c *TestConnection) Ping(ctx context.Context) (bool, error) {
+	return pingConnection(ctx, c)
+}
+
 func (c *TestConnection) ReadPacket() (p []byte, isPrefix bool, err error) {
 	c.readMu.Lock()
 	defer c.readMu.Unlock()
@@ -62,3 +79,129 @@ func (c *TestConnection) WritePacket(p []byte) (err error) {
 	defer c.writeMu.Unlock()
 	return c.writer.WritePacket(p)
 }
+
+type packetChunk struct {
+	p        []byte
+	isPrefix bool
+	err      error
+}
+
+// chunkPacketReader returns the chunks in order and io.EOF afterwards
+type chunkPacketReader struct {
+	chunks []packetChunk
+}
+
+func (r *chunkPacketReader) ReadPacket() ([]byte, bool, error) {
+	if len(r.chunks) == 0 {
+		return nil, false, io.EOF
+	}
+	c := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	return c.p, c.isPrefix, c.err
+}
+
+func TestReadPacketAssemblesPrefixedChunks(t *testing.T) {
+	chunk := bytes.Repeat([]byte{0x42}, 1000)
+	reader := &chunkPacketReader{chunks: []packetChunk{
+		{chunk, true, nil},
+		{chunk, true, nil},
+		{chunk, false, nil},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	packet, err := readPacket(ctx, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet) != 3000 {
+		t.Errorf("Expected packet of 3000 bytes but got %d", len(packet))
+	}
+}
+
+func TestReadMessageReportsHeaderOnParseError(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	SetLogger(logger)
+	defer SetLogger(logrus.StandardLogger())
+
+	// CON GET with MID 0x1234 followed by an option using the reserved delta 15
+	packet := []byte{0x40, 0x01, 0x12, 0x34, 0xF1, 0x00}
+	reader := &chunkPacketReader{chunks: []packetChunk{{packet, false, nil}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, _, err := readMessage(ctx, reader)
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("Expected *ParseError but got: %v", err)
+	}
+	if !bytes.Equal(perr.Packet, packet) {
+		t.Errorf("Expected raw packet %v but got %v", packet, perr.Packet)
+	}
+	if !perr.HeaderValid || perr.Version != 1 || perr.Type != coapmsg.Confirmable ||
+		perr.TokenLength != 0 || perr.Code != coapmsg.GET || perr.MessageID != 0x1234 {
+		t.Errorf("Unexpected header fields %+v", perr)
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Failed to parse CoAP message" {
+		t.Fatalf("Expected parse failure to be logged but got %v", entry)
+	}
+	if entry.Data["Code"] != coapmsg.GET.String() || entry.Data["MessageID"] != uint16(0x1234) {
+		t.Errorf("Expected header fields in log entry but got %v", entry.Data)
+	}
+}
+
+func TestReadPacketTruncatedOnDisconnect(t *testing.T) {
+	chunk := bytes.Repeat([]byte{0x42}, 1000)
+	reader := &chunkPacketReader{chunks: []packetChunk{
+		{chunk, true, nil},
+		{chunk[:10], true, io.EOF},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := readPacket(ctx, reader); err != ERR_CONNECTION_LOST {
+		t.Errorf("Expected %s but got: %v", ERR_CONNECTION_LOST, err)
+	}
+
+	reader = &chunkPacketReader{chunks: []packetChunk{
+		{chunk, true, nil},
+	}}
+	if _, err := readPacket(ctx, reader); err != ERR_CONNECTION_LOST {
+		t.Errorf("Expected %s but got: %v", ERR_CONNECTION_LOST, err)
+	}
+}
+
+func TestConnectionPing(t *testing.T) {
+	testCon := NewTestConnector(t)
+	conn, err := testCon.Connect("ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		ping, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := testCon.ServerSend(coapmsg.NewRst(ping.MessageID)); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ok, err := conn.Ping(ctx)
+	if !ok || err != nil {
+		t.Errorf("Expected successful ping but got: %v", err)
+	}
+	if conn.Closed() {
+		t.Error("Expected connection to stay open after ping")
+	}
+	if conn.InteractionCount() != 0 {
+		t.Errorf("Expected no interactions left but got %d", conn.InteractionCount())
+	}
+}
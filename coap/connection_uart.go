@@ -1,6 +1,7 @@
 package coap
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -13,7 +14,8 @@ import (
 )
 
 // UartKeepAliveInterval defines how often the serial port is reopened.
-// Set to 0 to disable reopening.
+// Set to 0 to disable reopening. It is used for connections without
+// UartParams.KeepAliveInterval and read when the port is opened.
 var UartKeepAliveInterval = 30 * time.Second
 
 // UartUseSlipMux can be set to true to use SlipMux instead of SLIP
@@ -58,48 +60,78 @@ type serialConnection struct {
 	reader   PacketReader
 	writer   PacketWriter
 	open     bool
+	openPort serialOpenFunc
 
 	// Use reader and writer to interact with the port
 	port SerialPort
 
 	cancelReceiveLoop context.CancelFunc
 
+	// Frees the slot of a ConnectionLimiter, nil without limiter
+	release func()
+
+	reopenMu sync.Mutex    // Guards reopened
+	reopened chan struct{} // Closed when a running reopen is done, nil if not reopening
+
 	readMu  sync.Mutex // Guards the reader
 	writeMu sync.Mutex // Guards the writer
+
+	stateMu sync.Mutex // Guards open, portName, port, reader, writer and cancelReceiveLoop, they change on reopen and close
 }
 
 var ERR_CONNECTION_CLOSED = errors.New("Connection is closed")
 
-func newSerialConnection(portName string, mode UartParams) *serialConnection {
+func newSerialConnection(portName string, mode UartParams, openPort serialOpenFunc) *serialConnection {
+	if openPort == nil {
+		openPort = serialOpen
+	}
 	return &serialConnection{
 		portName: portName,
 		mode:     mode,
+		openPort: openPort,
 	}
 }
 
 func (c *serialConnection) setPort(port SerialPort) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
 	c.port = port
+
+	// The SLIP readers use the *bufio.Reader directly when it is not smaller than their own
+	var r io.Reader = port
+	if c.mode.ReadBufferSize > 0 {
+		r = bufio.NewReaderSize(port, c.mode.ReadBufferSize)
+	}
+
 	if UartUseSlipMux {
-		c.reader = NewSlipMuxReader(port)
+		c.reader = NewSlipMuxReader(r)
 		c.writer = NewSlipMuxWriter(port)
 	} else {
-		c.reader = slip.NewReader(port)
+		c.reader = slip.NewReader(r)
 		c.writer = slip.NewWriter(port)
 	}
 
 }
 
 func (c *serialConnection) Name() string {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
 	return c.portName
 }
 
 func (c *serialConnection) Open() error {
+	return c.openContext(context.Background())
+}
+
+func (c *serialConnection) openContext(ctx context.Context) error {
 	// TODO: not sure what happens when we reopen a closed connection
-	oldName := c.portName
-	port, newPortName, err := openComPort(c.portName, c.mode)
+	oldName := c.Name()
+	port, newPortName, err := openComPort(ctx, c.openPort, oldName, c.mode)
+	c.stateMu.Lock()
 	c.portName = newPortName
+	c.stateMu.Unlock()
 	log.WithField("originalPort", oldName).
-		WithField("port", c.portName).
+		WithField("port", newPortName).
 		WithField("baud", c.mode.Baud).
 		Info("Opening serial port ...")
 
@@ -112,27 +144,49 @@ func (c *serialConnection) Open() error {
 	}
 
 	c.setPort(port)
-	c.open = true // Now we can actually send and receive data
+	c.setOpen(true) // Now we can actually send and receive data
 
 	c.startReceiveLoop()
-	go c.keepAlive()
+	if interval := c.keepAliveInterval(); interval > 0 {
+		go c.keepAlive(interval)
+	}
 	return nil
 }
 
+// startReceiveLoop starts a new receive loop and stops the running one
 func (c *serialConnection) startReceiveLoop() {
 	receiveLoopCtx, cancelReceiveLoop := context.WithCancel(context.Background())
+	c.stateMu.Lock()
+	running := c.cancelReceiveLoop
 	c.cancelReceiveLoop = cancelReceiveLoop
+	c.stateMu.Unlock()
+
+	if running != nil {
+		running()
+	}
 	go receiveLoop(receiveLoopCtx, c)
 }
 
-func (c *serialConnection) keepAlive() {
-	for {
-		if UartKeepAliveInterval == 0 {
-			time.Sleep(10 * time.Second)
-			continue
-		}
+func (c *serialConnection) setOpen(open bool) {
+	c.stateMu.Lock()
+	c.open = open
+	c.stateMu.Unlock()
+}
 
-		time.Sleep(UartKeepAliveInterval)
+// keepAliveInterval returns how often the port is reopened, 0 never reopens it
+func (c *serialConnection) keepAliveInterval() time.Duration {
+	if c.mode.KeepAliveInterval < 0 {
+		return 0
+	}
+	if c.mode.KeepAliveInterval > 0 {
+		return c.mode.KeepAliveInterval
+	}
+	return UartKeepAliveInterval
+}
+
+func (c *serialConnection) keepAlive(interval time.Duration) {
+	for {
+		time.Sleep(interval)
 		if c.Closed() {
 			log.Info("Serial port closed. Stop keep alive.")
 			return
@@ -153,34 +207,73 @@ func (c *serialConnection) keepAlive() {
 	}
 }
 
-func (c *serialConnection) reopenSerialPort() error {
+// waitReady blocks while the serial port is reopened.
+// Returns ERR_CONNECTION_CLOSED when the connection got closed.
+func (c *serialConnection) waitReady(ctx context.Context) error {
+	c.reopenMu.Lock()
+	reopened := c.reopened
+	c.reopenMu.Unlock()
+
+	if reopened != nil {
+		select {
+		case <-reopened:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if c.Closed() {
+		return ERR_CONNECTION_CLOSED
+	}
+	return nil
+}
+
+func (c *serialConnection) reopenSerialPort() (err error) {
+	c.reopenMu.Lock()
+	c.reopened = make(chan struct{})
+	c.reopenMu.Unlock()
+	defer func() {
+		if err != nil {
+			// Waiting requests must not use the connection, it gets closed
+			c.setOpen(false)
+		}
+		c.reopenMu.Lock()
+		close(c.reopened)
+		c.reopened = nil
+		c.reopenMu.Unlock()
+	}()
+
 	//c.readMu.Lock()
 	c.writeMu.Lock()
 	//defer c.readMu.Unlock()
 	defer c.writeMu.Unlock()
 
-	log.WithField("port", c.portName).Info("Reopen serial port")
+	portName := c.Name()
+	log.WithField("port", portName).Info("Reopen serial port")
 	// Close and reopen serial port
-	err := c.port.Close()
-	if err != nil {
-		return wrapError(err, "Failed to close serial port")
-	}
+	c.stateMu.Lock()
+	port := c.port
 	c.port = nil
+	c.stateMu.Unlock()
+	if port != nil {
+		if err = port.Close(); err != nil {
+			return wrapError(err, "Failed to close serial port")
+		}
+	}
 	// Need to wait a short period before reopening the port. Else it fails.
 	time.Sleep(50 * time.Millisecond)
-	log.WithField("port", c.portName).Debug("Port closed.")
+	log.WithField("port", portName).Debug("Port closed.")
 
-	port, _, err := openComPort(c.portName, c.mode)
+	port, _, err = openComPort(context.Background(), c.openPort, portName, c.mode)
 	if err != nil {
 		return err
 	}
 
-	log.WithField("port", c.portName).Debug("Port opened.")
+	log.WithField("port", portName).Debug("Port opened.")
 
 	c.setPort(port)
 
 	// Restart receive loop
-	c.cancelReceiveLoop()
 	c.startReceiveLoop()
 
 	return nil
@@ -190,20 +283,23 @@ func (c *serialConnection) ReadPacket() (p []byte, isPrefix bool, err error) {
 	c.readMu.Lock()
 	defer c.readMu.Unlock()
 
-	if c.Closed() {
+	c.stateMu.Lock()
+	open, reader, port := c.open, c.reader, c.port
+	c.stateMu.Unlock()
+	if !open {
 		err = ERR_CONNECTION_CLOSED
 		return
 	}
 
-	p, isPrefix, err = c.reader.ReadPacket()
+	p, isPrefix, err = reader.ReadPacket()
 
-	if !isPrefix && UartFlushOnRead {
+	if !isPrefix && UartFlushOnRead && port != nil {
 		log.Debug("Flush on ReadPacket")
-		err = c.port.ResetInputBuffer()
+		err = port.ResetInputBuffer()
 		if err != nil {
 			return
 		}
-		err = c.port.ResetOutputBuffer()
+		err = port.ResetOutputBuffer()
 		if err != nil {
 			return
 		}
@@ -217,7 +313,10 @@ func (c *serialConnection) WritePacket(p []byte) (err error) {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
-	if c.Closed() {
+	c.stateMu.Lock()
+	open, writer := c.open, c.writer
+	c.stateMu.Unlock()
+	if !open {
 		err = ERR_CONNECTION_CLOSED
 		return
 	}
@@ -226,30 +325,53 @@ func (c *serialConnection) WritePacket(p []byte) (err error) {
 	if err != nil {
 		return
 	}
-	err = c.writer.WritePacket(p)
+	err = writer.WritePacket(p)
 
 	return
 }
 
 func (c *serialConnection) Close() (err error) {
+	c.stateMu.Lock()
 	c.open = false
+	cancelReceiveLoop, port := c.cancelReceiveLoop, c.port
+	c.stateMu.Unlock()
 
-	c.cancelReceiveLoop()
-	if c.port != nil {
-		err = c.port.Close()
+	if cancelReceiveLoop != nil {
+		cancelReceiveLoop()
 	}
+	c.connectionClosed()
+	if port != nil {
+		err = port.Close()
+	}
+	c.releaseLimit()
 	return
 }
 
+func (c *serialConnection) releaseLimit() {
+	if c.release != nil {
+		c.release()
+	}
+}
+
+func (c *serialConnection) SerialParams() (SerialConnectionParams, bool) {
+	return SerialConnectionParams{UartParams: c.mode, Port: c.Name()}, true
+}
+
+func (c *serialConnection) Ping(ctx context.Context) (bool, error) {
+	return pingConnection(ctx, c)
+}
+
 func (c *serialConnection) Closed() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
 	return !c.open
 }
 
 // Last successful "any" port. Will be tried first before iterating
 var lastAny = ""
 
-func checkComPort(portName string, mode UartParams) bool {
-	port, err := serialOpen(portName, mode)
+func checkComPort(open serialOpenFunc, portName string, mode UartParams) bool {
+	port, err := open(portName, mode)
 	if err != nil {
 		return false
 	} else {
@@ -280,9 +402,36 @@ func portlist() []string {
 	return ports
 }
 
+// serialOpenContext opens the serial port but returns as soon as the context is done.
+// A port that is opened after the context is done gets closed again.
+func serialOpenContext(ctx context.Context, open serialOpenFunc, portName string, mode UartParams) (SerialPort, error) {
+	type openResult struct {
+		port SerialPort
+		err  error
+	}
+	resCh := make(chan openResult, 1)
+	go func() {
+		port, err := open(portName, mode)
+		resCh <- openResult{port, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.port, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-resCh; res.err == nil {
+				res.port.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 // When portName is "any" the first available port is opened
 // the new port name is returned as newPortName
-func openComPort(portName string, mode UartParams) (port SerialPort, newPortName string, err error) {
+// Opening is aborted when ctx is done
+func openComPort(ctx context.Context, open serialOpenFunc, portName string, mode UartParams) (port SerialPort, newPortName string, err error) {
 	if portName == "any" {
 		portNames, err := getPortsList()
 		if err != nil {
@@ -290,7 +439,7 @@ func openComPort(portName string, mode UartParams) (port SerialPort, newPortName
 		}
 
 		for _, p := range portNames {
-			if checkComPort(p, mode) {
+			if checkComPort(open, p, mode) {
 				newPortName = p
 				break
 			} else {
@@ -307,12 +456,17 @@ func openComPort(portName string, mode UartParams) (port SerialPort, newPortName
 
 	start := time.Now()
 	for {
-		port, err = serialOpen(newPortName, mode)
+		port, err = serialOpenContext(ctx, open, newPortName, mode)
 		if err == nil {
 
 			break
 		}
 
+		if ctx.Err() != nil {
+			log.WithError(err).Debug("Canceled to open serial port")
+			return
+		}
+
 		if time.Since(start) > time.Second {
 			log.WithError(err).Debug("Failed to open serial port after 1 second")
 			return
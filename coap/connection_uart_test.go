@@ -0,0 +1,395 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/lobaro/slip"
+)
+
+// fakeSerialPort is an in memory SerialPort.
+// Data written by the client can be read from the server side and vice versa.
+type fakeSerialPort struct {
+	clientReader *io.PipeReader
+	serverWriter *io.PipeWriter
+	serverReader *io.PipeReader
+	clientWriter *io.PipeWriter
+}
+
+func newFakeSerialPort() *fakeSerialPort {
+	p := &fakeSerialPort{}
+	p.clientReader, p.serverWriter = io.Pipe()
+	p.serverReader, p.clientWriter = io.Pipe()
+	return p
+}
+
+func (p *fakeSerialPort) Read(b []byte) (int, error) {
+	return p.clientReader.Read(b)
+}
+
+func (p *fakeSerialPort) Write(b []byte) (int, error) {
+	return p.clientWriter.Write(b)
+}
+
+func (p *fakeSerialPort) Close() error {
+	p.clientReader.Close()
+	p.clientWriter.Close()
+	return nil
+}
+
+func (p *fakeSerialPort) ResetInputBuffer() error  { return nil }
+func (p *fakeSerialPort) ResetOutputBuffer() error { return nil }
+func (p *fakeSerialPort) SetDTR(dtr bool) error    { return nil }
+func (p *fakeSerialPort) SetRTS(rts bool) error    { return nil }
+
+// openFakeSerialPort opens a fakeSerialPort for any port name
+func openFakeSerialPort(portName string, params UartParams) (SerialPort, error) {
+	return newFakeSerialPort(), nil
+}
+
+func TestConnectContextCanceledDuringSlowOpen(t *testing.T) {
+	openPort := func(portName string, params UartParams) (SerialPort, error) {
+		time.Sleep(2 * time.Second)
+		return newFakeSerialPort(), nil
+	}
+
+	connector := NewUartConnecter()
+	connector.openPort = openPort
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := connector.ConnectContext(ctx, "ttySlow")
+	duration := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Expected connect to fail when context is canceled")
+	}
+	if duration > 500*time.Millisecond {
+		t.Errorf("Expected connect to abort promptly but took %s", duration)
+	}
+}
+
+// serveAck answers the next request on the server side of port with a piggybacked response
+func serveAck(t *testing.T, port *fakeSerialPort, payload string) {
+	packet, _, err := slip.NewReader(port.serverReader).ReadPacket()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	msg, err := coapmsg.ParseMessage(packet)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ack := coapmsg.NewAck(msg.MessageID)
+	ack.Code = coapmsg.Content
+	ack.Token = msg.Token
+	ack.Payload = []byte(payload)
+	if err := slip.NewWriter(port.serverWriter).WritePacket(ack.MustMarshalBinary()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRoundTripWaitsForReopen(t *testing.T) {
+	opened := make(chan *fakeSerialPort, 2)
+	openCount := 0
+	mu := sync.Mutex{}
+	openPort := func(portName string, params UartParams) (SerialPort, error) {
+		mu.Lock()
+		openCount++
+		slow := openCount > 1
+		mu.Unlock()
+		if slow {
+			time.Sleep(300 * time.Millisecond)
+		}
+		port := newFakeSerialPort()
+		opened <- port
+		return port, nil
+	}
+
+	connector := NewUartConnecter()
+	connector.openPort = openPort
+	trans := NewTransportUart()
+	trans.Connecter = connector
+
+	conn, err := connector.Connect("ttyReopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-opened
+
+	serialCon := conn.(*serialConnection)
+	go serialCon.reopenSerialPort()
+
+	// Wait until the reopen is running
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		serialCon.reopenMu.Lock()
+		reopening := serialCon.reopened != nil
+		serialCon.reopenMu.Unlock()
+		if reopening {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Reopen did not start")
+		}
+	}
+
+	go func() {
+		serveAck(t, <-opened, "reopened")
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://ttyReopen/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := trans.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Expected request to succeed after reopen but got: %s", err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "reopened" {
+		t.Errorf("Expected body reopened but got %s", string(body))
+	}
+}
+
+func TestSerialParamsOfOpenConnection(t *testing.T) {
+	connector := NewUartConnecter()
+	connector.openPort = openFakeSerialPort
+	connector.Baud = 9600
+	connector.Parity = ParityEven
+	trans := NewTransportUart()
+	trans.Connecter = connector
+
+	if _, ok := trans.SerialParams("ttyParams"); ok {
+		t.Error("Expected no serial params without open connection")
+	}
+
+	conn, err := connector.Connect("ttyParams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	params, ok := trans.SerialParams("ttyParams")
+	if !ok {
+		t.Fatal("Expected serial params of open connection")
+	}
+	if params.Baud != 9600 || params.Parity != ParityEven || params.DataBits != DefaultUartParams.DataBits {
+		t.Errorf("Expected configured params but got %+v", params)
+	}
+	if params.Port != connector.portName("ttyParams") {
+		t.Errorf("Expected port %s but got %q", connector.portName("ttyParams"), params.Port)
+	}
+}
+
+func TestSerialParamsAfterConnectToAny(t *testing.T) {
+	connector := NewUartConnecter()
+	connector.openPort = openFakeSerialPort
+	trans := NewTransportUart()
+	trans.Connecter = connector
+
+	conn, err := connector.Connect("ttyAny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	params, ok := trans.SerialParams("any")
+	if !ok {
+		t.Fatal("Expected serial params of the connection used for any")
+	}
+	if params.Port != connector.portName("ttyAny") {
+		t.Errorf("Expected port %s but got %q", connector.portName("ttyAny"), params.Port)
+	}
+}
+
+func TestAnyRoundRobin(t *testing.T) {
+	connector := NewUartConnecter()
+	connector.openPort = openFakeSerialPort
+	connector.AnyStrategy = RoundRobin
+
+	connA, err := connector.Connect("ttyA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connA.Close()
+	connB, err := connector.Connect("ttyB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connB.Close()
+
+	first, err := connector.Connect("any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := connector.Connect("any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("Expected requests to be distributed but both used %s", first.Name())
+	}
+	third, err := connector.Connect("any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third != first {
+		t.Errorf("Expected round robin to start over with %s but got %s", first.Name(), third.Name())
+	}
+}
+
+// frameSerialPort serves a fixed byte stream and counts the reads
+type frameSerialPort struct {
+	*fakeSerialPort
+	data  *bytes.Reader
+	reads int
+}
+
+func (p *frameSerialPort) Read(b []byte) (int, error) {
+	p.reads++
+	return p.data.Read(b)
+}
+
+func slipFrame(t testing.TB, msg coapmsg.Message) []byte {
+	buf := &bytes.Buffer{}
+	if err := slip.NewWriter(buf).WritePacket(msg.MustMarshalBinary()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func largeMessage(payloadSize int) coapmsg.Message {
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.Acknowledgement
+	msg.Code = coapmsg.Content
+	msg.MessageID = 1
+	msg.Token = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	msg.Options().Add(coapmsg.ETag, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	msg.Options().Add(coapmsg.ContentFormat, coapmsg.AppOctets)
+	msg.Payload = make([]byte, payloadSize)
+	for i := range msg.Payload {
+		msg.Payload[i] = byte(i) // Includes SLIP END and ESC bytes
+	}
+	return msg
+}
+
+func TestReadMaxSizeFrame(t *testing.T) {
+	msg := largeMessage(1024)
+	port := &frameSerialPort{data: bytes.NewReader(slipFrame(t, msg))}
+
+	params := DefaultUartParams
+	params.ReadBufferSize = 2048
+	conn := newSerialConnection("test", params, nil)
+	conn.setPort(port)
+
+	packet, err := readPacket(context.Background(), conn.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := coapmsg.ParseMessage(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Payload, msg.Payload) {
+		t.Errorf("Expected payload of %d bytes but got %d bytes", len(msg.Payload), len(res.Payload))
+	}
+	if port.reads != 1 {
+		t.Errorf("Expected frame to be read at once but needed %d reads", port.reads)
+	}
+}
+
+func BenchmarkReadLargeFrame(b *testing.B) {
+	frame := slipFrame(b, largeMessage(16*1024))
+
+	for _, size := range []int{0, 32 * 1024} {
+		b.Run(fmt.Sprintf("ReadBufferSize=%d", size), func(b *testing.B) {
+			params := DefaultUartParams
+			params.ReadBufferSize = size
+			reads := 0
+			for i := 0; i < b.N; i++ {
+				port := &frameSerialPort{data: bytes.NewReader(frame)}
+				conn := newSerialConnection("bench", params, nil)
+				conn.setPort(port)
+				if _, err := readPacket(context.Background(), conn.reader); err != nil {
+					b.Fatal(err)
+				}
+				reads += port.reads
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestKeepAliveIntervalPerConnection(t *testing.T) {
+	opens := make(map[string]int)
+	mu := sync.Mutex{}
+	openPort := func(portName string, params UartParams) (SerialPort, error) {
+		mu.Lock()
+		opens[strings.TrimPrefix(portName, "/dev/")]++
+		mu.Unlock()
+		return newFakeSerialPort(), nil
+	}
+
+	origInterval := UartKeepAliveInterval
+	UartKeepAliveInterval = 0
+	defer func() { UartKeepAliveInterval = origInterval }()
+
+	connect := func(portName string, interval time.Duration) Connection {
+		connector := NewUartConnecter()
+		connector.openPort = openPort
+		connector.KeepAliveInterval = interval
+		conn, err := connector.Connect(portName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	stable := connect("ttyStable", 0)
+	defer stable.Close()
+	flaky := connect("ttyFlaky", 20*time.Millisecond)
+	defer flaky.Close()
+
+	UartKeepAliveInterval = 20 * time.Millisecond
+	disabled := connect("ttyDisabled", -1)
+	defer disabled.Close()
+
+	reopened := func(portName string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return opens[portName] > 1
+	}
+	for start := time.Now(); !reopened("ttyFlaky") && time.Since(start) < 3*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give the other ports several intervals of the global keep alive
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if opens["ttyStable"] != 1 {
+		t.Errorf("Expected ttyStable with interval 0 to never be reopened but was opened %d times", opens["ttyStable"])
+	}
+	if opens["ttyDisabled"] != 1 {
+		t.Errorf("Expected ttyDisabled to never be reopened but was opened %d times", opens["ttyDisabled"])
+	}
+	if opens["ttyFlaky"] < 2 {
+		t.Errorf("Expected ttyFlaky to be reopened but was opened %d times", opens["ttyFlaky"])
+	}
+}
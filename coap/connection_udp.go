@@ -0,0 +1,195 @@
+package coap
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// maxDatagramSize is the largest datagram that is read
+const maxDatagramSize = 64 * 1024
+
+// udpConnection reads and writes one CoAP message per datagram.
+// conn is a connected *net.UDPConn or a DTLS session on top of it.
+type udpConnection struct {
+	Interactions
+	network string // "udp", "udp4" or "udp6"
+	addr    string
+	conn    net.Conn
+	buf     []byte
+
+	mu   sync.Mutex // Guards open
+	open bool
+
+	cancelReceiveLoop context.CancelFunc
+
+	readMu  sync.Mutex // Guards buf
+	writeMu sync.Mutex
+}
+
+func newUdpConnection(network, addr string, conn net.Conn) *udpConnection {
+	return &udpConnection{
+		network: network,
+		addr:    addr,
+		conn:    conn,
+		buf:     make([]byte, maxDatagramSize),
+	}
+}
+
+func (c *udpConnection) Name() string {
+	return c.addr
+}
+
+func (c *udpConnection) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open {
+		return nil
+	}
+	c.open = true
+
+	receiveLoopCtx, cancelReceiveLoop := context.WithCancel(context.Background())
+	c.cancelReceiveLoop = cancelReceiveLoop
+	go receiveLoop(receiveLoopCtx, c)
+	return nil
+}
+
+// ReadPacket blocks until the next datagram is received
+func (c *udpConnection) ReadPacket() (p []byte, isPrefix bool, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.Closed() {
+		err = ERR_CONNECTION_CLOSED
+		return
+	}
+
+	n, err := c.conn.Read(c.buf)
+	if err != nil {
+		return nil, false, err
+	}
+	p = make([]byte, n)
+	copy(p, c.buf[:n])
+	return p, false, nil
+}
+
+func (c *udpConnection) WritePacket(p []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.Closed() {
+		return ERR_CONNECTION_CLOSED
+	}
+	_, err := c.conn.Write(p)
+	return err
+}
+
+func (c *udpConnection) Close() error {
+	c.mu.Lock()
+	if !c.open {
+		c.mu.Unlock()
+		return nil
+	}
+	c.open = false
+	c.cancelReceiveLoop()
+	c.mu.Unlock()
+
+	c.connectionClosed()
+	return c.conn.Close()
+}
+
+func (c *udpConnection) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.open
+}
+
+func (c *udpConnection) Ping(ctx context.Context) (bool, error) {
+	return pingConnection(ctx, c)
+}
+
+func (c *udpConnection) SerialParams() (SerialConnectionParams, bool) {
+	return SerialConnectionParams{}, false
+}
+
+// packetConn sends to a fixed remote address but receives from any address,
+// e.g. the responses of several servers to a multicast request
+type packetConn struct {
+	net.PacketConn
+	remote net.Addr
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.remote)
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// hostAddr adds the default port to hosts without port
+func hostAddr(host string, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+}
+
+// udpConnections keeps one open connection per network and address for the connecters
+type udpConnections struct {
+	connectMutex sync.Mutex
+	connections  []*udpConnection
+}
+
+// find returns the open connection to addr, of any network
+func (c *udpConnections) find(addr string) Connection {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+
+	for _, con := range c.connections {
+		if !con.Closed() && con.addr == addr {
+			return con
+		}
+	}
+	return nil
+}
+
+// connect reuses the open connection to addr over network or opens a new one with dial
+func (c *udpConnections) connect(network, addr string, dial func() (net.Conn, error)) (Connection, error) {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+
+	// Remove closed connections
+	open := c.connections[:0]
+	for _, con := range c.connections {
+		if !con.Closed() {
+			open = append(open, con)
+		}
+	}
+	c.connections = open
+
+	for _, con := range c.connections {
+		if con.network == network && con.addr == addr {
+			log.WithField("addr", addr).Debug("Using already open connection")
+			return con, nil
+		}
+	}
+
+	netConn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newUdpConnection(network, addr, netConn)
+	if err := conn.Open(); err != nil {
+		return nil, err
+	}
+	c.connections = append(c.connections, conn)
+	return conn, nil
+}
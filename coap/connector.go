@@ -1,5 +1,24 @@
 package coap
 
+import "context"
+
+// SerialConnecter provides the Connections used by the TransportUart.
+//
+// Implement this interface to plug custom transport backends
+// (e.g. a TCP bridge to a serial device) into the TransportUart.
+// Implementations must be safe for concurrent use and should
+// return already open connections for the same host.
 type SerialConnecter interface {
+	// Connect returns an open Connection to the given host,
+	// the host is the host part of the request URL (e.g. COM3 or "any")
 	Connect(host string) (Connection, error)
+	// ConnectContext works like Connect but must abort
+	// opening the connection when the context is done
+	ConnectContext(ctx context.Context, host string) (Connection, error)
+}
+
+// connectionFinder is implemented by connecters that can look up
+// already open connections without opening a new one
+type connectionFinder interface {
+	findConnection(host string) Connection
 }
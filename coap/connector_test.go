@@ -13,6 +13,8 @@ import (
 	"github.com/pkg/errors"
 )
 
+var _ SerialConnecter = (*TestConnector)(nil)
+
 type TestConnector struct {
 	//ReceiveBuf *SafeBuffer // Data that is received by the client (connection reader)
 	//SendBuf    *SafeBuffer // Data that is send by the client (connection writer)
@@ -60,8 +62,9 @@ func (c *TestConnector) ServerReceive(timeout time.Duration) (coapmsg.Message, e
 
 		select {
 		case <-ctx.Done():
-			return coapmsg.NewMessage(), errors.New(fmt.Sprintf("Server: Receive Timeout after %d seconds. (%f)", timeout.Seconds(), c.Out.Len()))
-		default:
+			return coapmsg.NewMessage(), errors.New(fmt.Sprintf("Server: Receive Timeout after %s. (%d)", timeout, c.Out.Len()))
+		case <-time.After(time.Millisecond):
+			// Do not starve other goroutines while polling
 		}
 	}
 	msg, err := coapmsg.ParseMessage(buf.Bytes())
@@ -69,6 +72,21 @@ func (c *TestConnector) ServerReceive(timeout time.Duration) (coapmsg.Message, e
 	return msg, err
 }
 
+// ServerReceiveNonBlocking returns the next message sent by the client.
+// ok is false when no message was sent.
+func (c *TestConnector) ServerReceiveNonBlocking() (msg coapmsg.Message, ok bool, err error) {
+	p, _, err := c.Out.ReadPacket()
+	if err == io.EOF {
+		return coapmsg.NewMessage(), false, nil
+	}
+	if err != nil {
+		return coapmsg.NewMessage(), false, err
+	}
+	msg, err = coapmsg.ParseMessage(p)
+	c.t.Logf("Server: received %s", msg.String())
+	return msg, true, err
+}
+
 func (c *TestConnector) GetSendMessage() (coapmsg.Message, error) {
 
 	p, _, err := c.Out.ReadPacket()
@@ -119,9 +137,21 @@ func (rw *PacketBuffer) Len() int {
 	return len(rw.packets)
 }
 
+func (c *TestConnector) findConnection(host string) Connection {
+	if c.conn == nil || c.conn.Closed() {
+		return nil
+	}
+	return c.conn
+}
+
 func (c *TestConnector) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+func (c *TestConnector) ConnectContext(ctx context.Context, host string) (Connection, error) {
 
-	if c.conn != nil {
+	// A closed connection is replaced like the UartConnector does
+	if c.conn != nil && !c.conn.Closed() {
 		return c.conn, nil
 	}
 
@@ -139,3 +169,100 @@ func (c *TestConnector) Connect(host string) (Connection, error) {
 
 	return conn, nil
 }
+
+// countingConnecter is a custom SerialConnecter that counts the connects
+type countingConnecter struct {
+	connector *TestConnector
+	mu        sync.Mutex
+	connects  int
+}
+
+func (c *countingConnecter) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+func (c *countingConnecter) ConnectContext(ctx context.Context, host string) (Connection, error) {
+	c.mu.Lock()
+	c.connects++
+	c.mu.Unlock()
+	return c.connector.ConnectContext(ctx, host)
+}
+
+func TestCustomConnecter(t *testing.T) {
+	testCon := NewTestConnector(t)
+	connecter := &countingConnecter{connector: testCon}
+	trans := NewTransportUart()
+	trans.Connecter = connecter
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.Content.Number(), res.StatusCode)
+	}
+	if connecter.connects != 1 {
+		t.Errorf("Expected 1 connect on custom connecter but got %d", connecter.connects)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestNonResponseIsNotAcknowledged(t *testing.T) {
+	testCon := NewTestConnector(t)
+	trans := NewTransportUart()
+	trans.Connecter = testCon
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 1000
+		res.Token = msg.Token
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Confirmable = false
+	if _, err := trans.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the client time to send something unexpected
+	time.Sleep(50 * time.Millisecond)
+	msg, ok, err := testCon.ServerReceiveNonBlocking()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("Expected no message for NON response but got %s", msg.String())
+	}
+	ValidateCleanConnection(t, testCon)
+}
@@ -1,7 +1,9 @@
 package coap
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
 var DefaultUartParams = UartParams{
@@ -21,12 +23,59 @@ type UartParams struct {
 	DataBits   int
 	InitialDTR bool
 	InitialRTS bool
+
+	// ReadBufferSize is the buffer size of the SLIP reader in bytes.
+	// Larger buffers need less reads for large frames. 0 uses the default size.
+	ReadBufferSize int
+
+	// KeepAliveInterval defines how often the serial port is reopened.
+	// 0 uses UartKeepAliveInterval, a negative interval disables reopening.
+	KeepAliveInterval time.Duration
+}
+
+// SerialConnectionParams are the parameters of an open serial connection
+type SerialConnectionParams struct {
+	UartParams
+	Port string // Name of the opened port, e.g. the port chosen for "any"
 }
 
+// AnyStrategy defines which connection is used for the host "any"
+//
+// Responses are only matched against interactions of the connection the
+// request was sent on. Requests that belong together, like cancelling an
+// observe, must therefore use the port name instead of "any" when
+// NewEach or RoundRobin is used.
+type AnyStrategy int
+
+const (
+	// FirstOpen reuses the first open connection (default)
+	FirstOpen AnyStrategy = iota
+	// NewEach opens a new connection on the next free port
+	NewEach
+	// RoundRobin distributes requests over all open connections
+	RoundRobin
+)
+
+var _ SerialConnecter = (*UartConnector)(nil)
+
+// serialOpenFunc opens a serial port, tests use it to open ports without hardware
+type serialOpenFunc func(portName string, params UartParams) (SerialPort, error)
+
 type UartConnector struct {
 	UartParams
+
+	// Limiter optionally bounds the number of open connections.
+	// It can be shared between connectors.
+	Limiter *ConnectionLimiter
+
+	// AnyStrategy is used to choose a connection for the host "any"
+	AnyStrategy AnyStrategy
+
+	openPort serialOpenFunc // Opens the serial ports, serialOpen if nil
+
 	connectMutex sync.Mutex
 	connections  []Connection
+	anyNext      int // Next connection index for RoundRobin
 }
 
 func NewUartConnecter() *UartConnector {
@@ -37,39 +86,105 @@ func NewUartConnecter() *UartConnector {
 	}
 }
 
-func (c *UartConnector) Connect(host string) (Connection, error) {
+// findConnection returns the open connection for the host or nil.
+// Other than Connect it never opens a new connection.
+func (c *UartConnector) findConnection(host string) Connection {
 	c.connectMutex.Lock()
 	defer c.connectMutex.Unlock()
 
-	portName := ""
+	portName := c.portName(host)
+	for _, con := range c.connections {
+		if con.Closed() {
+			continue
+		}
+		if sc, ok := con.(*serialConnection); (ok && sc.Name() == portName) || portName == "any" {
+			return con
+		}
+	}
+	return nil
+}
+
+func (c *UartConnector) portName(host string) string {
 	if host == "any" {
-		portName = host
+		return host
 	} else if !isWindows() {
-		portName = "/dev/" + host
-	} else {
-		portName = host
+		return "/dev/" + host
 	}
+	return host
+}
 
-	// can recycle connection?
-	for i, con := range c.connections {
-		if con.Closed() {
-			c.connections = deleteConnection(c.connections, i)
-			continue
+func (c *UartConnector) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+// ConnectContext opens or reuses a connection like Connect.
+// Opening the serial port is aborted when the context is done.
+func (c *UartConnector) ConnectContext(ctx context.Context, host string) (Connection, error) {
+	c.connectMutex.Lock()
+	defer c.connectMutex.Unlock()
+
+	portName := c.portName(host)
+
+	// Remove closed connections
+	open := c.connections[:0]
+	for _, con := range c.connections {
+		if !con.Closed() {
+			open = append(open, con)
 		}
+	}
+	c.connections = open
 
-		if c, ok := con.(*serialConnection); (ok && c.portName == portName) || portName == "any" {
-			log.WithField("Port", c.portName).Debug("Using already open serial port")
-			return c, nil
+	// can recycle connection?
+	if portName == "any" {
+		if con := c.anyConnection(); con != nil {
+			log.WithField("Port", con.Name()).Debug("Using already open serial port")
+			return con, nil
+		}
+	} else {
+		for _, con := range c.connections {
+			if sc, ok := con.(*serialConnection); ok && sc.Name() == portName {
+				log.WithField("Port", sc.Name()).Debug("Using already open serial port")
+				return sc, nil
+			}
 		}
 	}
 
 	// Else open a new connection
-	conn := newSerialConnection(portName, c.UartParams)
+	var release func()
+	if c.Limiter != nil {
+		var err error
+		release, err = c.Limiter.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn := newSerialConnection(portName, c.UartParams, c.openPort)
+	conn.release = release
 	c.connections = append(c.connections, conn)
-	err := conn.Open()
+	err := conn.openContext(ctx)
 	if err != nil {
+		conn.releaseLimit()
 		return conn, err
 	}
 
 	return conn, nil
 }
+
+// anyConnection returns the open connection to use for the host "any"
+// or nil if a new connection must be opened
+func (c *UartConnector) anyConnection() Connection {
+	if len(c.connections) == 0 {
+		return nil
+	}
+	switch c.AnyStrategy {
+	case NewEach:
+		return nil
+	case RoundRobin:
+		con := c.connections[c.anyNext%len(c.connections)]
+		c.anyNext++
+		return con
+	default:
+		return c.connections[0]
+	}
+}
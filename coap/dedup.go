@@ -0,0 +1,138 @@
+package coap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// exchangeLifetime is EXCHANGE_LIFETIME of RFC 7252, 4.5
+const exchangeLifetime = 247 * time.Second
+
+type receivedMessage struct {
+	messageId uint16
+	received  time.Time
+	reply     []byte // ACK or RST sent for the message
+}
+
+// messageCache detects retransmitted CON and NON messages of the peer by their message id.
+// The remote is implied by the connection that owns the cache.
+type messageCache struct {
+	mu       sync.Mutex
+	messages []receivedMessage // Oldest first
+
+	lifetime   time.Duration
+	windowSize int   // 0 disables the deduplication
+	clock      Clock // nil uses the RealClock
+}
+
+// configure sets the deduplication settings of the transport using the connection
+func (c *messageCache) configure(lifetime time.Duration, windowSize int, clock Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lifetime = lifetime
+	c.windowSize = windowSize
+	c.clock = clock
+}
+
+func (c *messageCache) now() time.Time {
+	c.mu.Lock()
+	clock := c.clock
+	c.mu.Unlock()
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
+
+// received remembers msgId and returns true with the reply to resend when msgId is a duplicate.
+// The reply is nil when the first message is not answered yet.
+func (c *messageCache) received(msgId uint16, now time.Time) (duplicate bool, reply []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.windowSize <= 0 {
+		c.messages = nil
+		return false, nil
+	}
+	c.expire(now)
+	for _, m := range c.messages {
+		if m.messageId == msgId {
+			return true, m.reply
+		}
+	}
+	if len(c.messages) >= c.windowSize {
+		c.messages = c.messages[len(c.messages)-c.windowSize+1:]
+	}
+	c.messages = append(c.messages, receivedMessage{messageId: msgId, received: now})
+	return false, nil
+}
+
+// replied remembers the ACK or RST sent for the received message msgId
+func (c *messageCache) replied(msgId uint16, reply []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.messages {
+		if c.messages[i].messageId == msgId {
+			c.messages[i].reply = reply
+			return
+		}
+	}
+}
+
+func (c *messageCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
+
+func (c *messageCache) expire(now time.Time) {
+	i := 0
+	for i < len(c.messages) && now.Sub(c.messages[i].received) > c.lifetime {
+		i++
+	}
+	c.messages = c.messages[i:]
+}
+
+// deduplicatingConnection is implemented by all connections embedding Interactions
+type deduplicatingConnection interface {
+	receivedMessages() *messageCache
+}
+
+// isDuplicate is true when msg was already received on conn.
+// The reply to the first message is sent again for duplicate CONs.
+func isDuplicate(conn Connection, msg *coapmsg.Message) bool {
+	if msg.Type != coapmsg.Confirmable && msg.Type != coapmsg.NonConfirmable {
+		return false
+	}
+	dc, ok := conn.(deduplicatingConnection)
+	if !ok {
+		return false
+	}
+	cache := dc.receivedMessages()
+	duplicate, reply := cache.received(msg.MessageID, cache.now())
+	if !duplicate {
+		return false
+	}
+
+	log.WithField("messageId", msg.MessageID).
+		WithField("replied", reply != nil).
+		Info("Dropped duplicate message")
+	if reply != nil && msg.Type == coapmsg.Confirmable {
+		if err := conn.WritePacket(reply); err != nil {
+			log.WithError(err).Warn("Failed to resend reply to duplicate message")
+		}
+	}
+	return true
+}
+
+// rememberReply stores ACKs and RSTs sent on conn to resend them for duplicates
+func rememberReply(conn Connection, msg *coapmsg.Message, bin []byte) {
+	if msg.Type != coapmsg.Acknowledgement && msg.Type != coapmsg.Reset {
+		return
+	}
+	if dc, ok := conn.(deduplicatingConnection); ok {
+		dc.receivedMessages().replied(msg.MessageID, bin)
+	}
+}
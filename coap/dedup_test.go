@@ -0,0 +1,141 @@
+package coap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+func TestMessageCacheDetectsDuplicates(t *testing.T) {
+	c := &messageCache{lifetime: exchangeLifetime, windowSize: 64}
+	now := time.Now()
+
+	if dup, _ := c.received(1, now); dup {
+		t.Error("Expected first message not to be a duplicate")
+	}
+	if dup, reply := c.received(1, now); !dup || reply != nil {
+		t.Errorf("Expected unanswered duplicate but got %v %v", dup, reply)
+	}
+
+	c.replied(1, []byte{0x60})
+	if dup, reply := c.received(1, now); !dup || !bytes.Equal(reply, []byte{0x60}) {
+		t.Errorf("Expected duplicate with reply but got %v %v", dup, reply)
+	}
+
+	// Messages are forgotten after the lifetime
+	if dup, _ := c.received(1, now.Add(exchangeLifetime+time.Second)); dup {
+		t.Error("Expected expired message not to be a duplicate")
+	}
+}
+
+func TestMessageCacheWindowSize(t *testing.T) {
+	c := &messageCache{lifetime: exchangeLifetime, windowSize: 2}
+	now := time.Now()
+	for _, id := range []uint16{1, 2, 3} {
+		c.received(id, now)
+	}
+	// The oldest message was dropped from the window
+	if dup, _ := c.received(1, now); dup {
+		t.Error("Expected message 1 to be dropped from the window")
+	}
+	if dup, _ := c.received(3, now); !dup {
+		t.Error("Expected message 3 to be a duplicate")
+	}
+}
+
+func TestMessageCacheExpiresWithClock(t *testing.T) {
+	clock := newFakeClock()
+	c := &messageCache{}
+	c.configure(time.Minute, 64, clock)
+
+	c.received(1, c.now())
+	clock.Advance(30 * time.Second)
+	if dup, _ := c.received(1, c.now()); !dup {
+		t.Error("Expected message within the lifetime to be a duplicate")
+	}
+	clock.Advance(2 * time.Minute)
+	if dup, _ := c.received(1, c.now()); dup {
+		t.Error("Expected message to expire by the clock")
+	}
+}
+
+func TestDuplicateNotificationIsHandledOnce(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Only the message id identifies the duplicate, its content is not looked at
+		for i, id := range []uint16{1000, 1000, 1001} {
+			notify := coapmsg.NewMessage()
+			notify.Type = coapmsg.Confirmable
+			notify.Code = coapmsg.Content
+			notify.MessageID = id
+			notify.Token = msg.Token
+			notify.Payload = []byte(strconv.Itoa(i + 2))
+			notify.Options().Add(coapmsg.Observe, i+2)
+			if err := testCon.ServerSend(notify); err != nil {
+				t.Error(err)
+			}
+			ack, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ack.Type != coapmsg.Acknowledgement || ack.MessageID != id {
+				t.Errorf("Expected ACK for message %d but got %v", id, ack)
+			}
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"2", "4"} {
+		next, err := res.NextWithTimeout(3 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := ioutil.ReadAll(next.Body); string(body) != expected {
+			t.Errorf("Expected notification %s but got %s", expected, body)
+		}
+	}
+
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Error(err)
+	}
+	<-done
+	ValidateCleanConnection(t, testCon)
+}
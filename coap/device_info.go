@@ -0,0 +1,118 @@
+package coap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// DeviceInfoPath is the resource requested by Client.DeviceInfo
+var DeviceInfoPath = "/device"
+
+// DeviceInfoParser parses the payload of the device info resource.
+// Replace it to support devices with a different payload format.
+var DeviceInfoParser func(payload []byte) (DeviceInfo, error) = ParseDeviceInfo
+
+// DeviceInfo describes the firmware of a device
+type DeviceInfo struct {
+	Firmware string
+	Serial   string
+	Uptime   time.Duration
+
+	// Fields contains all parsed values by name, including the ones above
+	Fields map[string]interface{}
+}
+
+// DeviceInfo requests DeviceInfoPath from the host of url
+// and parses the payload with DeviceInfoParser.
+func (c *Client) DeviceInfo(url string) (DeviceInfo, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	req.URL.Path = DeviceInfoPath
+	req.URL.RawPath = ""
+
+	res, err := c.Do(req)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != coapmsg.Content.Number() {
+		return DeviceInfo{}, errors.New("coap: Failed to get device info: " + res.Status)
+	}
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	return DeviceInfoParser(payload)
+}
+
+// ParseDeviceInfo parses a JSON object or a SenML JSON pack.
+// The known fields are "firmware" (or "fw"), "serial" (or "sn")
+// and "uptime" in seconds.
+func ParseDeviceInfo(payload []byte) (DeviceInfo, error) {
+	info := DeviceInfo{Fields: make(map[string]interface{})}
+
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var records []struct {
+			BaseName    string   `json:"bn"`
+			Name        string   `json:"n"`
+			Value       *float64 `json:"v"`
+			StringValue *string  `json:"vs"`
+			BoolValue   *bool    `json:"vb"`
+		}
+		if err := json.Unmarshal(payload, &records); err != nil {
+			return info, wrapError(err, "Invalid SenML device info")
+		}
+		baseName := ""
+		for _, r := range records {
+			if r.BaseName != "" {
+				baseName = r.BaseName
+			}
+			name := baseName + r.Name
+			switch {
+			case r.Value != nil:
+				info.Fields[name] = *r.Value
+			case r.StringValue != nil:
+				info.Fields[name] = *r.StringValue
+			case r.BoolValue != nil:
+				info.Fields[name] = *r.BoolValue
+			}
+		}
+	} else if err := json.Unmarshal(payload, &info.Fields); err != nil {
+		return info, wrapError(err, "Invalid JSON device info")
+	}
+
+	for name, value := range info.Fields {
+		switch strings.ToLower(name) {
+		case "firmware", "fw":
+			info.Firmware = infoString(value)
+		case "serial", "sn":
+			info.Serial = infoString(value)
+		case "uptime":
+			if seconds, ok := value.(float64); ok {
+				info.Uptime = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return info, nil
+}
+
+func infoString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package coap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// staticTransport answers every request with the same payload
+type staticTransport struct {
+	Payload []byte
+	path    string
+}
+
+func (t *staticTransport) RoundTrip(req *Request) (*Response, error) {
+	t.path = req.URL.Path
+	return &Response{
+		StatusCode: coapmsg.Content.Number(),
+		Body:       ioutil.NopCloser(bytes.NewReader(t.Payload)),
+		Request:    req,
+	}, nil
+}
+
+func TestClientDeviceInfoSenML(t *testing.T) {
+	tr := &staticTransport{Payload: []byte(`[
+		{"bn":"", "n":"firmware", "vs":"app-wmbus-1.4.2"},
+		{"n":"serial", "vs":"0012345"},
+		{"n":"uptime", "v":3600},
+		{"n":"battery", "v":3.2}
+	]`)}
+	client := &Client{Transport: tr}
+
+	info, err := client.DeviceInfo("coap+uart://any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.path != DeviceInfoPath {
+		t.Errorf("Expected request to %s but got %s", DeviceInfoPath, tr.path)
+	}
+	if info.Firmware != "app-wmbus-1.4.2" {
+		t.Errorf("Expected firmware app-wmbus-1.4.2 but got %s", info.Firmware)
+	}
+	if info.Serial != "0012345" {
+		t.Errorf("Expected serial 0012345 but got %s", info.Serial)
+	}
+	if info.Uptime != time.Hour {
+		t.Errorf("Expected uptime %s but got %s", time.Hour, info.Uptime)
+	}
+	if info.Fields["battery"] != 3.2 {
+		t.Errorf("Expected battery field 3.2 but got %v", info.Fields["battery"])
+	}
+}
+
+func TestClientDeviceInfoJSON(t *testing.T) {
+	tr := &staticTransport{Payload: []byte(`{"fw":"1.0.0","sn":42,"uptime":1.5}`)}
+	client := &Client{Transport: tr}
+
+	info, err := client.DeviceInfo("coap+uart://any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Firmware != "1.0.0" {
+		t.Errorf("Expected firmware 1.0.0 but got %s", info.Firmware)
+	}
+	if info.Serial != "42" {
+		t.Errorf("Expected serial 42 but got %s", info.Serial)
+	}
+	if info.Uptime != 1500*time.Millisecond {
+		t.Errorf("Expected uptime 1.5s but got %s", info.Uptime)
+	}
+}
@@ -0,0 +1,67 @@
+package coap
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// WellKnownCorePath is the resource requested by Client.Discover (RFC 6690)
+const WellKnownCorePath = "/.well-known/core"
+
+// Resource is a resource of a device listed by Client.Discover
+type Resource struct {
+	Path          string // URI reference of the link, as sent by the device
+	ResourceType  string // rt attribute
+	Interface     string // if attribute
+	ContentFormat string // ct attribute, or cf for older devices
+
+	// Attributes contains all link params, including the ones above
+	Attributes map[string]string
+}
+
+// Discover requests WellKnownCorePath from the host of url
+// and returns the resources listed in the link format payload.
+func (c *Client) Discover(host string) ([]Resource, error) {
+	req, err := NewRequest("GET", host, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path = WellKnownCorePath
+	req.URL.RawPath = ""
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != coapmsg.Content.Number() {
+		return nil, errors.New("coap: Failed to discover resources: " + res.Status)
+	}
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := coapmsg.ParseLinkFormat(payload)
+	if err != nil {
+		return nil, wrapError(err, "Invalid link format")
+	}
+	resources := make([]Resource, 0, len(links))
+	for _, link := range links {
+		r := Resource{
+			Path:          link.URI,
+			ResourceType:  link.Params["rt"],
+			Interface:     link.Params["if"],
+			ContentFormat: link.Params["ct"],
+			Attributes:    link.Params,
+		}
+		if cf, ok := link.Params["cf"]; ok && r.ContentFormat == "" {
+			r.ContentFormat = cf
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
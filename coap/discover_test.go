@@ -0,0 +1,47 @@
+package coap
+
+import "testing"
+
+func TestClientDiscover(t *testing.T) {
+	// Payload of the liblobarocoap server
+	tr := &staticTransport{Payload: []byte(`<.well-known/core/>,<existing/>;title="Some existing endpoint";cf=0,`)}
+	client := &Client{Transport: tr}
+
+	resources, err := client.Discover("coap+uart://any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.path != WellKnownCorePath {
+		t.Errorf("Expected request to %s but got %s", WellKnownCorePath, tr.path)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources but got %+v", resources)
+	}
+	if resources[0].Path != ".well-known/core/" {
+		t.Errorf("Unexpected resource %+v", resources[0])
+	}
+	existing := resources[1]
+	if existing.Path != "existing/" || existing.ContentFormat != "0" {
+		t.Errorf("Unexpected resource %+v", existing)
+	}
+	if existing.Attributes["title"] != "Some existing endpoint" {
+		t.Errorf("Expected title attribute but got %+v", existing.Attributes)
+	}
+}
+
+func TestClientDiscoverAttributes(t *testing.T) {
+	tr := &staticTransport{Payload: []byte(`</sensors/temp>;rt="temperature-c";if="sensor";ct=0`)}
+	client := &Client{Transport: tr}
+
+	resources, err := client.Discover("coap+uart://any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 {
+		t.Fatalf("Expected 1 resource but got %+v", resources)
+	}
+	r := resources[0]
+	if r.Path != "/sensors/temp" || r.ResourceType != "temperature-c" || r.Interface != "sensor" || r.ContentFormat != "0" {
+		t.Errorf("Unexpected resource %+v", r)
+	}
+}
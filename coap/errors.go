@@ -1,6 +1,12 @@
 package coap
 
-import "errors"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
 
 type coapError struct {
 	err     string
@@ -17,6 +23,80 @@ func (e *coapError) Temporary() bool {
 	return true
 }
 
+// wrapError adds msg to err, errors.Is and errors.As still find err
 func wrapError(err error, msg string) error {
-	return errors.New(msg + ": " + err.Error())
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// ERR_ACK_TIMEOUT is returned when a CON request is not acknowledged after all retransmissions
+var ERR_ACK_TIMEOUT error = &coapError{err: "coap: Timeout while waiting for ACK", timeout: true}
+
+// ERR_UNEXPECTED_TYPE is returned when the message type of the response does not fit the request
+var ERR_UNEXPECTED_TYPE = errors.New("coap: Unexpected message type")
+
+// ERR_MESSAGE_ID_MISMATCH is returned when the message id of an ACK does not match the request
+var ERR_MESSAGE_ID_MISMATCH = errors.New("coap: MessageId of response does not match")
+
+// ERR_BODY_CLOSED is returned when a response body is read after Close
+var ERR_BODY_CLOSED = errors.New("coap: Read on closed response body")
+
+// ERR_INVALID_OPTION_LENGTH is returned for option values that are too short or too long
+var ERR_INVALID_OPTION_LENGTH = errors.New("coap: Invalid option value length")
+
+// unexpectedType wraps ERR_UNEXPECTED_TYPE with the expected and the received type
+func unexpectedType(expected string, got coapmsg.COAPType) error {
+	return fmt.Errorf("%w: expected %s but got %s", ERR_UNEXPECTED_TYPE, expected, got)
+}
+
+// ResponseError is returned instead of a 4.xx or 5.xx response
+// when TransportUart.ErrorResponsesAsError is set
+type ResponseError struct {
+	Response *Response
+}
+
+func (e *ResponseError) Error() string {
+	return "coap: Error response " + e.Response.Status
+}
+
+// ResetError is returned when the peer rejects a request with a Reset (RST),
+// e.g. because it can not process the request at all
+type ResetError struct {
+	MessageID uint16 // Message ID of the RST
+	Token     []byte // Token of the rejected request, the RST carries none
+}
+
+func (e *ResetError) Error() string {
+	return fmt.Sprintf("coap: Request with Token %x rejected with Reset", e.Token)
+}
+
+// ParseError is returned when a received packet is not a valid CoAP message.
+// The header fields are set when the packet has at least the 4 header bytes,
+// which helps to tell framing errors from broken CoAP messages.
+type ParseError struct {
+	Err    error
+	Packet []byte // The raw packet
+
+	HeaderValid bool
+	Version     uint8
+	Type        coapmsg.COAPType
+	TokenLength uint8
+	Code        coapmsg.COAPCode
+	MessageID   uint16
+}
+
+func newParseError(err error, packet []byte) *ParseError {
+	e := &ParseError{Err: err, Packet: packet}
+	if len(packet) >= 4 {
+		e.HeaderValid = true
+		e.Version = packet[0] >> 6
+		e.Type = coapmsg.COAPType((packet[0] >> 4) & 0x3)
+		e.TokenLength = packet[0] & 0xf
+		e.Code = coapmsg.COAPCode(packet[1])
+		e.MessageID = binary.BigEndian.Uint16(packet[2:4])
+	}
+	return e
+}
+
+func (e *ParseError) Error() string {
+	return "Failed to parse CoAP message: " + e.Err.Error()
 }
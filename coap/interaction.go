@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
 	"sync"
 	"time"
 )
@@ -29,6 +30,18 @@ type Interaction struct {
 
 	// isObserve is set to true during a RoundTrip when it was a observe request
 	isObserve bool
+	observeMu sync.Mutex // Guards isObserve, multicast, canceling and observeCanceled, they are read by other goroutines
+
+	// multicast keeps collecting responses after the first one, see RoundTripMulticast
+	multicast bool
+
+	// canceling is set while the cancel request of an observe is running,
+	// the round trip closes the interaction when it is done
+	canceling bool
+
+	// observeCanceled is set by the cancel request. The final response
+	// may still carry the Observe option but answers the cancel request.
+	observeCanceled bool
 
 	// CancelObserve will stop the interaction to listen for Notifications
 	StopListenForNotifications context.CancelFunc
@@ -39,11 +52,44 @@ type Interaction struct {
 
 	closed      bool
 	roundTripMu sync.Mutex
+
+	// acceptNonResponseToCon allows a NON response to a CON request, see TransportUart
+	acceptNonResponseToCon bool
+
+	// Retransmission of CON requests, see TransportUart
+	maxRetransmit    int
+	retransmitBudget *RetransmitBudget
+	validateResponse func(req, res *coapmsg.Message) error
+
+	// ackNotifyImmediately acknowledges CON notifications on receipt, see TransportUart
+	ackNotifyImmediately bool
+
+	// clock measures the timeouts, nil uses the RealClock, see TransportUart
+	clock Clock
+
+	// observeSeq drops reordered notifications, see LastObserveSeq
+	observeSeq *observeSequence
+
+	// metadata is added to the message logs, see WithMetadata
+	metadata logrus.Fields
+
+	rawMu        sync.Mutex // Guards lastSent and lastReceived
+	lastSent     []byte
+	lastReceived []byte
+
+	// connClosed is closed when the connection is closed to unblock waiting reads
+	connClosed     chan struct{}
+	connClosedOnce sync.Once
 }
 
 type Interactions struct {
 	mu           sync.Mutex
 	interactions []*Interaction
+	received     messageCache // Detects duplicates of received messages
+}
+
+func (ias *Interactions) receivedMessages() *messageCache {
+	return &ias.received
 }
 
 func (ias *Interactions) InteractionCount() int {
@@ -73,6 +119,7 @@ func (ias *Interactions) StartInteraction(conn Connection, reqMsg *coapmsg.Messa
 		conn:             conn,
 		receiveCh:        make(chan *coapmsg.Message, 10),
 		receiveObserveCh: make(chan *coapmsg.Message, 10),
+		connClosed:       make(chan struct{}),
 	}
 
 	log.WithField("Token", ia.Token()).Debug("Start interaction")
@@ -82,6 +129,19 @@ func (ias *Interactions) StartInteraction(conn Connection, reqMsg *coapmsg.Messa
 	return ia
 }
 
+// connectionClosed must be called when the connection is closed.
+// Running round trips of all interactions return ERR_CONNECTION_CLOSED.
+func (ias *Interactions) connectionClosed() {
+	// A reconnected device might start over with the same message ids
+	ias.received.reset()
+
+	ias.mu.Lock()
+	defer ias.mu.Unlock()
+	for _, ia := range ias.interactions {
+		ia.connClosedOnce.Do(func() { close(ia.connClosed) })
+	}
+}
+
 func (ias *Interactions) FindInteraction(token Token, msgId MessageId) *Interaction {
 	ias.mu.Lock()
 	defer ias.mu.Unlock()
@@ -91,7 +151,6 @@ func (ias *Interactions) FindInteraction(token Token, msgId MessageId) *Interact
 		}
 		// For empty tokens the message Id must match
 		// An ACK/RST is sent by the server as response for a CON but carries no token
-		// TODO: Check also message type to only match ACK/RST here?
 		if len(token) == 0 && ia.lastMessageId == msgId {
 			return ia
 		}
@@ -99,15 +158,65 @@ func (ias *Interactions) FindInteraction(token Token, msgId MessageId) *Interact
 	return nil
 }
 
+func (ias *Interactions) ObservingInteractions(path string) []*Interaction {
+	ias.mu.Lock()
+	defer ias.mu.Unlock()
+	observing := make([]*Interaction, 0)
+	for _, ia := range ias.interactions {
+		if ia.IsObserving() && ia.req.PathString() == path {
+			observing = append(observing, ia)
+		}
+	}
+	return observing
+}
+
 func (ia *Interaction) Token() Token {
 	return ia.req.Token
 }
 
+// LastSent returns the raw bytes of the last message sent by the interaction
+func (ia *Interaction) LastSent() []byte {
+	ia.rawMu.Lock()
+	defer ia.rawMu.Unlock()
+	return ia.lastSent
+}
+
+// LastReceived returns the raw bytes of the last message received by the interaction
+func (ia *Interaction) LastReceived() []byte {
+	ia.rawMu.Lock()
+	defer ia.rawMu.Unlock()
+	return ia.lastReceived
+}
+
+func (ia *Interaction) setLastReceived(packet []byte) {
+	ia.rawMu.Lock()
+	defer ia.rawMu.Unlock()
+	ia.lastReceived = packet
+}
+
+// sendMessage sends the message and keeps the sent bytes
+func (ia *Interaction) sendMessage(msg *coapmsg.Message) error {
+	bin, err := writeMessage(ia.conn, msg, ia.metadata)
+	if err != nil {
+		return err
+	}
+	ia.rawMu.Lock()
+	ia.lastSent = bin
+	ia.rawMu.Unlock()
+	return nil
+}
+
 func (ia *Interaction) Closed() bool {
 	return ia.closed
 }
 
 func (ia *Interaction) Close() {
+	ia.close(true)
+}
+
+// close releases the interaction. When closeIdleConn is set the
+// connection is closed as well if no other interactions are left.
+func (ia *Interaction) close(closeIdleConn bool) {
 	if ia.closed {
 		log.WithField("token", ia.Token()).Warn("Interaction already closed.")
 		return
@@ -124,7 +233,7 @@ func (ia *Interaction) Close() {
 	close(ia.receiveObserveCh)
 
 	ia.conn.RemoveInteraction(ia)
-	if ia.conn.InteractionCount() == 0 {
+	if closeIdleConn && ia.conn.InteractionCount() == 0 && !ia.conn.Closed() {
 		log.WithField("port", ia.conn.Name()).Debug("No interactions left, closing connection.")
 		ia.conn.Close()
 	}
@@ -142,7 +251,7 @@ func isObserveResponse(msg *coapmsg.Message) bool {
 
 func (ia *Interaction) HandleMessage(msg *coapmsg.Message) {
 	start := time.Now()
-	if isObserveResponse(msg) {
+	if isObserveResponse(msg) && !ia.isObserveCanceled() {
 		log.WithField("observing", ia.IsObserving()).Debug("Interaction handle observe message...")
 
 		select {
@@ -158,6 +267,11 @@ func (ia *Interaction) HandleMessage(msg *coapmsg.Message) {
 		select {
 		case ia.receiveCh <- msg:
 		default:
+			if ia.IsMulticast() {
+				// Other servers might still respond, only drop this response
+				log.WithField("token", ia.Token()).Warn("Too many multicast responses. Discarding message.")
+				break
+			}
 			//case <-time.After(1 * time.Second):
 			// TODO: We should avoid this. find the reason why it happens and maybe buffer the channel
 			log.Error("Interaction did not handled incoming message. Discarding & Close interaction.")
@@ -168,8 +282,34 @@ func (ia *Interaction) HandleMessage(msg *coapmsg.Message) {
 	log.WithField("observing", ia.IsObserving()).WithField("duration", duration).Debug("Interaction handle message. DONE.")
 }
 
+func (ia *Interaction) getClock() Clock {
+	if ia.clock == nil {
+		return RealClock{}
+	}
+	return ia.clock
+}
+
+// ERR_POSTPONED_RESPONSE_TIMEOUT is returned when the server acknowledged the
+// request but did not send the separate response within POSTPONED_RESPONSE_TIMEOUT
+var ERR_POSTPONED_RESPONSE_TIMEOUT error = &coapError{err: "coap: Timeout while waiting for postponed response", timeout: true}
+
 var READ_MESSAGE_CTX_DONE = errors.New("Read timeout")
 var READ_MESSAGE_CHAN_CLOSED = errors.New("Receive channel closed")
+var READ_MESSAGE_CONN_CLOSED = errors.New("Connection closed")
+
+// ERR_INTERACTION_CLOSED is returned when the interaction is closed during a round trip
+var ERR_INTERACTION_CLOSED = errors.New("Interaction closed")
+
+// wrapReadError keeps a closed interaction distinguishable from protocol errors
+func wrapReadError(err error, msg string) error {
+	if err == READ_MESSAGE_CHAN_CLOSED {
+		return ERR_INTERACTION_CLOSED
+	}
+	if err == READ_MESSAGE_CONN_CLOSED {
+		return ERR_CONNECTION_CLOSED
+	}
+	return wrapError(err, msg)
+}
 
 func (ia *Interaction) readMessage(ctx context.Context) (*coapmsg.Message, error) {
 	select {
@@ -178,11 +318,50 @@ func (ia *Interaction) readMessage(ctx context.Context) (*coapmsg.Message, error
 			return msg, READ_MESSAGE_CHAN_CLOSED
 		}
 		return msg, nil
+	case <-ia.connClosed:
+		return nil, READ_MESSAGE_CONN_CLOSED
 	case <-ctx.Done():
 		return nil, READ_MESSAGE_CTX_DONE
 	}
 }
 
+// readResponseMessage reads the separate or NON response to reqMsg.
+// The response to an observe registration carries the observe option
+// and is therefore received on the observe channel as well.
+func (ia *Interaction) readResponseMessage(ctx context.Context, reqMsg *coapmsg.Message) (*coapmsg.Message, error) {
+	if !isObserveRegistration(reqMsg) {
+		return ia.readMessage(ctx)
+	}
+	select {
+	case msg, ok := <-ia.receiveCh:
+		if !ok {
+			return msg, READ_MESSAGE_CHAN_CLOSED
+		}
+		return msg, nil
+	case msg, ok := <-ia.receiveObserveCh:
+		if !ok {
+			return msg, READ_MESSAGE_CHAN_CLOSED
+		}
+		return msg, nil
+	case <-ia.connClosed:
+		return nil, READ_MESSAGE_CONN_CLOSED
+	case <-ctx.Done():
+		return nil, READ_MESSAGE_CTX_DONE
+	}
+}
+
+// suppressesAllResponses is true when the No-Response option of msg suppresses all response classes
+func suppressesAllResponses(msg *coapmsg.Message) bool {
+	opt := msg.Options().Get(coapmsg.NoResponse)
+	return opt.IsSet() && opt.AsUInt8()&coapmsg.NoResponseAll == coapmsg.NoResponseAll
+}
+
+// isObserveRegistration is true for requests with the observe option set to 0
+func isObserveRegistration(msg *coapmsg.Message) bool {
+	return msg.Options().Get(coapmsg.Observe).IsSet() &&
+		msg.Options().Get(coapmsg.Observe).AsUInt8() == 0
+}
+
 // readObserveMessage can receive message with the observe option set
 func (ia *Interaction) readObserveMessage(ctx context.Context) (*coapmsg.Message, error) {
 	select {
@@ -191,17 +370,119 @@ func (ia *Interaction) readObserveMessage(ctx context.Context) (*coapmsg.Message
 			return msg, READ_MESSAGE_CHAN_CLOSED
 		}
 		return msg, nil
+	case <-ia.connClosed:
+		return nil, READ_MESSAGE_CONN_CLOSED
 	case <-ctx.Done():
 		return nil, READ_MESSAGE_CTX_DONE
 	}
 }
 
 func (ia *Interaction) IsObserving() bool {
+	ia.observeMu.Lock()
+	defer ia.observeMu.Unlock()
 	return ia.isObserve
 }
 
+func (ia *Interaction) setObserving(observing bool) {
+	ia.observeMu.Lock()
+	ia.isObserve = observing
+	ia.observeMu.Unlock()
+}
+
+// isCanceling is true while the cancel request of an observe is running
+func (ia *Interaction) isCanceling() bool {
+	ia.observeMu.Lock()
+	defer ia.observeMu.Unlock()
+	return ia.canceling
+}
+
+func (ia *Interaction) setCanceling(canceling bool) {
+	ia.observeMu.Lock()
+	ia.canceling = canceling
+	ia.observeMu.Unlock()
+}
+
+// isObserveCanceled is true once a cancel request was sent for the observe
+func (ia *Interaction) isObserveCanceled() bool {
+	ia.observeMu.Lock()
+	defer ia.observeMu.Unlock()
+	return ia.observeCanceled
+}
+
+func (ia *Interaction) setObserveCanceled() {
+	ia.observeMu.Lock()
+	ia.observeCanceled = true
+	ia.observeMu.Unlock()
+}
+
+// IsMulticast is true for interactions that collect the responses of several servers
+func (ia *Interaction) IsMulticast() bool {
+	ia.observeMu.Lock()
+	defer ia.observeMu.Unlock()
+	return ia.multicast
+}
+
+func (ia *Interaction) setMulticast(multicast bool) {
+	ia.observeMu.Lock()
+	ia.multicast = multicast
+	ia.observeMu.Unlock()
+}
+
 var ERROR_READ_ACK = "Failed to read ACK"
 
+// ERR_MULTICAST_CONFIRMABLE is returned for CON multicast requests, which are not allowed (RFC 7252, 8.1)
+var ERR_MULTICAST_CONFIRMABLE = errors.New("coap: Multicast requests must be non-confirmable")
+
+// RoundTripMulticast sends the NON request reqMsg and collects all responses
+// with its token until window is over. Unlike RoundTrip it does not stop at
+// the first response, e.g. to discover all servers listening on a multicast address.
+func (ia *Interaction) RoundTripMulticast(ctx context.Context, reqMsg *coapmsg.Message, window time.Duration) ([]*coapmsg.Message, error) {
+	ia.roundTripMu.Lock()
+	defer ia.roundTripMu.Unlock()
+
+	if reqMsg.Type != coapmsg.NonConfirmable {
+		return nil, ERR_MULTICAST_CONFIRMABLE
+	}
+
+	ia.setMulticast(true)
+	ia.lastMessageId = MessageId(reqMsg.MessageID)
+
+	err := ia.sendMessage(reqMsg)
+	if err != nil {
+		return nil, wrapError(err, "Failed to send message")
+	}
+
+	withWindow, cancel := withClockTimeout(ctx, ia.getClock(), window)
+	defer cancel()
+
+	responses := make([]*coapmsg.Message, 0)
+	for {
+		resMsg, err := ia.readMessage(withWindow)
+		if err == READ_MESSAGE_CTX_DONE && ctx.Err() == nil {
+			return responses, nil
+		}
+		if err != nil {
+			return nil, wrapReadError(err, "Failed to read multicast response")
+		}
+
+		if resMsg.Type == coapmsg.Confirmable {
+			ack := coapmsg.NewAck(resMsg.MessageID)
+			if err := ia.sendMessage(&ack); err != nil {
+				return nil, err
+			}
+		} else if resMsg.Type != coapmsg.NonConfirmable {
+			log.WithField("token", ia.Token()).WithField("type", resMsg.Type.String()).Warn("Ignoring unexpected multicast response")
+			continue
+		}
+		if err := validateToken(reqMsg, resMsg); err != nil {
+			continue
+		}
+		responses = append(responses, resMsg)
+	}
+}
+
+// RoundTrip sends reqMsg and reads the response.
+// A CON request rejected by the peer returns a *ResetError.
 func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (resMsg *coapmsg.Message, err error) {
 	ia.roundTripMu.Lock()
 	defer ia.roundTripMu.Unlock()
@@ -214,7 +495,9 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 
 	// This is a cancel observe request.
 	if reqMsg.Options().Get(coapmsg.Observe).AsUInt8() > 0 {
-		ia.isObserve = false
+		ia.setObserving(false)
+		ia.setCanceling(true)
+		ia.setObserveCanceled()
 
 		// A new round trip on an existing interaction can only work when we are not listening
 		// for notifications. Else the notifications eats up all responses from the server.
@@ -231,7 +514,7 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 	ia.lastMessageId = MessageId(reqMsg.MessageID)
 
 	// send the request
-	err = sendMessage(ia.conn, reqMsg)
+	err = ia.sendMessage(reqMsg)
 	if err != nil {
 		return nil, wrapError(err, "Failed to send message")
 	}
@@ -239,12 +522,26 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 	if reqMsg.Type == coapmsg.Confirmable {
 		// Handle CON request
 
-		// TODO: Implement retries for CON messages until first ACK is received or some timeout
-		withAckTimeout, _ := context.WithTimeout(ctx, ackTimeout())
-		resMsg, err = ia.readMessage(withAckTimeout)
+		resMsg, err = ia.readResponseWithRetransmit(ctx, reqMsg)
+		if err == READ_MESSAGE_CTX_DONE && ctx.Err() == nil {
+			return resMsg, wrapError(ERR_ACK_TIMEOUT, ERROR_READ_ACK)
+		}
 		if err != nil {
-			return resMsg, wrapError(err, ERROR_READ_ACK)
+			return resMsg, wrapReadError(err, ERROR_READ_ACK)
 		}
+
+		if resMsg.Type == coapmsg.NonConfirmable && ia.acceptNonResponseToCon {
+			// Some non conformant servers answer a CON request with a NON response
+			// instead of an ACK. There is no ACK to wait for and the NON needs no ACK.
+			log.WithField("token", ia.Token()).Debug("Accept NON response to CON request")
+			return ia.finishRoundTrip(ctx, reqMsg, resMsg)
+		}
+
+		if resMsg.Type != coapmsg.Acknowledgement && resMsg.Type != coapmsg.Reset {
+			return resMsg, unexpectedType("ACK response", resMsg.Type)
+		}
+
+		// Only ACK and RST echo the message id of the request
 		if err = validateMessageId(reqMsg, resMsg); err != nil {
 			return resMsg, wrapError(err, ERROR_READ_ACK)
 		}
@@ -254,11 +551,10 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 			return resMsg, nil
 		}
 
-		if resMsg.Type != coapmsg.Acknowledgement {
-			return resMsg, errors.New("Expected ACK response but got " + resMsg.Type.String())
+		if resMsg.Type == coapmsg.Reset {
+			return resMsg, &ResetError{MessageID: resMsg.MessageID, Token: reqMsg.Token}
 		}
 
-		// TODO: Handle Types: RST correctly - now we just don't care
 		if resMsg.Type == coapmsg.Acknowledgement && resMsg.Code == coapmsg.Empty {
 			// Handle postponed (non-piggyback) response
 
@@ -285,20 +581,24 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 			//    |                  |
 			//
 			// Figure 5: A GET Request with a Separate Response
-			withTimeout, _ := context.WithTimeout(ctx, POSTPONED_RESPONSE_TIMEOUT)
-			resMsg, err = ia.readMessage(withTimeout)
+			withTimeout, cancel := withClockTimeout(ctx, ia.getClock(), POSTPONED_RESPONSE_TIMEOUT)
+			resMsg, err = ia.readResponseMessage(withTimeout, reqMsg)
+			cancel()
+			if err == READ_MESSAGE_CTX_DONE && ctx.Err() == nil {
+				return nil, ERR_POSTPONED_RESPONSE_TIMEOUT
+			}
 			if err != nil {
-				return nil, wrapError(err, "Failed to read postponed response")
+				return nil, wrapReadError(err, "Failed to read postponed response")
 			}
 			// The messageId from resMsg needs to be confirmed
 			if resMsg.Type != coapmsg.Confirmable && resMsg.Type != coapmsg.NonConfirmable {
-				return nil, errors.New("Expected postponed response [CON or NON] but got " + resMsg.Type.String())
+				return nil, unexpectedType("postponed response [CON or NON]", resMsg.Type)
 			}
 			// TODO: Handle resMsg.Type != coapmsg.Reset - but how? Just okay to return an error?
 
 			if resMsg.Type == coapmsg.Confirmable {
 				ack := coapmsg.NewAck(resMsg.MessageID)
-				if err := sendMessage(ia.conn, &ack); err != nil {
+				if err := ia.sendMessage(&ack); err != nil {
 					return nil, err
 				}
 			}
@@ -313,42 +613,69 @@ func (ia *Interaction) RoundTrip(ctx context.Context, reqMsg *coapmsg.Message) (
 		} else {
 			return nil, errors.New("Received invalid reponse from server")
 		}
+	} else if reqMsg.Type == coapmsg.NonConfirmable && suppressesAllResponses(reqMsg) {
+		// The server sends no response at all, return an empty response right away
+		empty := coapmsg.NewMessage()
+		empty.Type = coapmsg.NonConfirmable
+		empty.Code = coapmsg.Empty
+		empty.MessageID = reqMsg.MessageID
+		empty.Token = reqMsg.Token
+		return &empty, nil
 	} else if reqMsg.Type == coapmsg.NonConfirmable {
 		// Handle NON request
-		withAckTimeout, _ := context.WithTimeout(ctx, ackTimeout())
-		resMsg, err := ia.readMessage(withAckTimeout)
+		withAckTimeout, cancel := withClockTimeout(ctx, ia.getClock(), ackTimeout())
+		defer cancel()
+		// There is no ACK for NON requests, the response is matched by token only
+		resMsg, err = ia.readResponseMessage(withAckTimeout, reqMsg)
 		if err != nil {
-			return nil, wrapError(err, "Failed to read NON response")
-		}
-		if err = validateMessageId(reqMsg, resMsg); err != nil {
-			return nil, wrapError(err, "Failed to read NON response")
+			return nil, wrapReadError(err, "Failed to read NON response")
 		}
 		if resMsg.Type != coapmsg.NonConfirmable {
-			return nil, errors.New("Expected NON response but got " + reqMsg.Type.String())
+			return nil, unexpectedType("NON response", resMsg.Type)
 		}
 
 	} else {
 		msgLogEntry(reqMsg).Panic("Invalid request message type from client. Expected CON or NON")
 	}
 
+	return ia.finishRoundTrip(ctx, reqMsg, resMsg)
+}
+
+// finishRoundTrip validates the response and starts to listen for notifications
+// when the response confirms an observe request
+func (ia *Interaction) finishRoundTrip(ctx context.Context, reqMsg *coapmsg.Message, resMsg *coapmsg.Message) (*coapmsg.Message, error) {
 	// Handle observe
 
 	// An observe request must set the observe option to 0
 	// the server has to response with the observe option set
-	if reqMsg.Options().Get(coapmsg.Observe).IsSet() &&
-		reqMsg.Options().Get(coapmsg.Observe).AsUInt8() == 0 &&
+	if isObserveRegistration(reqMsg) &&
 		resMsg.Options().Get(coapmsg.Observe).IsSet() {
-		ia.isObserve = true
+		ia.setObserving(true)
 		// Must create chan before returning
 		ia.NotificationCh = make(chan *coapmsg.Message, 0)
-		go ia.waitForNotify(ctx)
+		ia.observeSeq = &observeSequence{clock: ia.getClock()}
+		if seq, ok := observeSeq(resMsg); ok {
+			ia.observeSeq.accept(seq)
+		}
+
+		// Set before starting to listen, the interaction might be closed right away
+		withCancel, cancelCtx := context.WithCancel(ctx)
+		cancelDone := make(chan struct{})
+		ia.StopListenForNotifications = func() {
+			ia.setObserving(false)
+			cancelCtx()
+			// We must actively wait for the cancel to be done,
+			// else readMessage could eat up bytes that it should not
+			<-cancelDone
+			log.WithField("token", ia.Token()).Info("Stopped to listen for notifications")
+		}
+		go ia.waitForNotify(ctx, withCancel, cancelDone)
 	}
 
-	if err = validateToken(reqMsg, resMsg); err != nil {
+	if err := validateToken(reqMsg, resMsg); err != nil {
 		return nil, err
 	}
 	return resMsg, nil
-
 }
 
 //  Gracefully shut down observe by sending GET with observe=1
@@ -370,24 +697,14 @@ func (ia *Interaction) sendCancelObserve() {
 func (ia *Interaction) handleNotification(resMsg *coapmsg.Message) {
 }
 
-// waitForNotify will actively handle notification messages
-func (ia *Interaction) waitForNotify(ctx context.Context) {
+// waitForNotify will actively handle notification messages until withCancel
+// is done, see StopListenForNotifications. cancelDone is closed on return.
+func (ia *Interaction) waitForNotify(ctx context.Context, withCancel context.Context, cancelDone chan struct{}) {
 	defer close(ia.NotificationCh)
 
-	withCancel, cancelCtx := context.WithCancel(ctx)
-
 	logWithToken := log.WithField("token", ia.Token())
 
-	cancelDone := make(chan struct{})
 	defer close(cancelDone)
-	ia.StopListenForNotifications = func() {
-		ia.isObserve = false
-		cancelCtx()
-		// We must actively wait for the cancel to be done,
-		// else readMessage could eat up bytes that it should not
-		<-cancelDone
-		logWithToken.Info("Stopped to listen for notifications")
-	}
 
 	for {
 		resMsg, err := ia.readObserveMessage(withCancel)
@@ -400,44 +717,71 @@ func (ia *Interaction) waitForNotify(ctx context.Context) {
 			return
 		}
 
-		if resMsg.Options().Get(coapmsg.Observe).IsNotSet() {
+		if seq, ok := observeSeq(resMsg); !ok {
 			log.WithField("msg", resMsg.String()).Error("Got non observe response in observe handler")
+		} else if !ia.observeSeq.accept(seq) {
+			logWithToken.WithField("seq", seq).Info("Dropped reordered notification")
+			if resMsg.Type == coapmsg.Confirmable {
+				ack := coapmsg.NewAck(resMsg.MessageID)
+				if err := ia.sendMessage(&ack); err != nil {
+					logWithToken.WithError(err).Error("Failed to send ACK for notify")
+					return
+				}
+			}
+			continue
 		}
 
-		select {
-		case ia.NotificationCh <- resMsg:
-			// TODO: Should we really only send the ACK when the notification is handled?
-			// As it is now, the user might miss a few notifications but can
-			// than still attach to the Next channel in the response
-			//log.Info("ia.NotificationCh <- resMsg: send ACK")
+		if ia.ackNotifyImmediately {
+			// ACK on receipt, so a slow consumer does not cause retransmissions by the server
 			if resMsg.Type == coapmsg.Confirmable {
 				ack := coapmsg.NewAck(resMsg.MessageID)
-				if err := sendMessage(ia.conn, &ack); err != nil {
+				if err := ia.sendMessage(&ack); err != nil {
 					logWithToken.WithError(err).Error("Failed to send ACK for notify")
 					return
 				}
 			}
-		case <-ctx.Done():
-			log.Info("Stopped observer, request context timed out or canceled! Send RST.")
-			// Even non-confirmable messages can be answered with a RST
-			rst := coapmsg.NewRst(resMsg.MessageID)
-			if err := sendMessage(ia.conn, &rst); err != nil {
-				logWithToken.WithError(err).Error("Failed to send RST for notify (1)")
+			select {
+			case ia.NotificationCh <- resMsg:
+			case <-withCancel.Done():
+				logWithToken.Info("Stopped observer before notification was handled")
 				return
 			}
-			return
-		default:
-			// Happens when the NotificationCh is closed aka no client is listening
-			// This is a bit indirect since the transport has another layer to convert
-			// the messages into responses for the client
-			logWithToken.Error("No handler for notification messages registered. Send RST.")
-			// Even non-confirmable messages can be answered with a RST
-			rst := coapmsg.NewRst(resMsg.MessageID)
-			if err := sendMessage(ia.conn, &rst); err != nil {
-				logWithToken.WithError(err).Error("Failed to send RST for notify (2)")
+		} else {
+			select {
+			case ia.NotificationCh <- resMsg:
+				// Only ACK when the notification is handled, see TransportUart.AckNotifyImmediately
+				// As it is now, the user might miss a few notifications but can
+				// than still attach to the Next channel in the response
+				//log.Info("ia.NotificationCh <- resMsg: send ACK")
+				if resMsg.Type == coapmsg.Confirmable {
+					ack := coapmsg.NewAck(resMsg.MessageID)
+					if err := ia.sendMessage(&ack); err != nil {
+						logWithToken.WithError(err).Error("Failed to send ACK for notify")
+						return
+					}
+				}
+			case <-ctx.Done():
+				log.Info("Stopped observer, request context timed out or canceled! Send RST.")
+				// Even non-confirmable messages can be answered with a RST
+				rst := coapmsg.NewRst(resMsg.MessageID)
+				if err := ia.sendMessage(&rst); err != nil {
+					logWithToken.WithError(err).Error("Failed to send RST for notify (1)")
+					return
+				}
 				return
-			}
+			default:
+				// Happens when the NotificationCh is closed aka no client is listening
+				// This is a bit indirect since the transport has another layer to convert
+				// the messages into responses for the client
+				logWithToken.Error("No handler for notification messages registered. Send RST.")
+				// Even non-confirmable messages can be answered with a RST
+				rst := coapmsg.NewRst(resMsg.MessageID)
+				if err := ia.sendMessage(&rst); err != nil {
+					logWithToken.WithError(err).Error("Failed to send RST for notify (2)")
+					return
+				}
 
+			}
 		}
 
 		// An error response MUST lead to a removal of the observer on server side.
@@ -453,7 +797,7 @@ func (ia *Interaction) waitForNotify(ctx context.Context) {
 			log.WithField("code", resMsg.Code.String()).Info("Stopped observer due to error response from server")
 			// No need to send RST anymore but can't harm
 			rst := coapmsg.NewRst(resMsg.MessageID)
-			if err := sendMessage(ia.conn, &rst); err != nil {
+			if err := ia.sendMessage(&rst); err != nil {
 				log.WithError(err).Error("Failed to send RST for notify (3)")
 				return
 			}
@@ -467,29 +811,42 @@ func (ia *Interaction) waitForNotify(ctx context.Context) {
 func validateMessageId(req, res *coapmsg.Message) error {
 	if req.MessageID != res.MessageID {
 		// This should never happen
-		err := errors.New("coap: MessageId of response does not match")
-		log.WithError(err).
+		log.WithError(ERR_MESSAGE_ID_MISMATCH).
 			WithField("ReqMessageId", req.MessageID).
 			WithField("ResMessageId", res.MessageID).
 			WithField("ReqToken", req.Token).
 			WithField("ResToken", res.Token).
 			Error("An interaction must never be called with the wrong message id")
-		return err
+		return ERR_MESSAGE_ID_MISMATCH
 	}
 	return nil
 }
 
+// A token length mismatch often indicates a framing bug
+var ERR_TOKEN_LENGTH_MISMATCH = errors.New("coap: Token length of response does not match")
+
+// A token value mismatch indicates cross-talk between interactions
+var ERR_TOKEN_MISMATCH = errors.New("coap: CRITICAL - Token of response does not match")
+
 func validateToken(req, res *coapmsg.Message) error {
+	if len(req.Token) != len(res.Token) {
+		log.WithError(ERR_TOKEN_LENGTH_MISMATCH).
+			WithField("ReqMessageId", req.MessageID).
+			WithField("ResMessageId", res.MessageID).
+			WithField("ReqToken", req.Token).
+			WithField("ResToken", res.Token).
+			Error("Token length of response does not match, the message might be corrupted")
+		return ERR_TOKEN_LENGTH_MISMATCH
+	}
 	if !bytes.Equal(req.Token, res.Token) {
 		// This should never happen
-		err := errors.New("coap: CRITICAL - Token of response does not match")
-		log.WithError(err).
+		log.WithError(ERR_TOKEN_MISMATCH).
 			WithField("ReqMessageId", req.MessageID).
 			WithField("ResMessageId", res.MessageID).
 			WithField("ReqToken", req.Token).
 			WithField("ResToken", res.Token).
 			Error("An interaction must never be called with the wrong token")
-		return err
+		return ERR_TOKEN_MISMATCH
 	}
 	return nil
 }
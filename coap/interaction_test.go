@@ -0,0 +1,145 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// newTestInteraction opens a connection on the test connector and starts an interaction for reqMsg
+func newTestInteraction(t *testing.T, testCon *TestConnector, reqMsg *coapmsg.Message) *Interaction {
+	conn, err := testCon.Connect("ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn.StartInteraction(conn, reqMsg)
+}
+
+func TestInteractionLastSentAndReceived(t *testing.T) {
+	testCon := NewTestConnector(t)
+
+	reqMsg := coapmsg.NewMessage()
+	reqMsg.Type = coapmsg.Confirmable
+	reqMsg.Code = coapmsg.GET
+	reqMsg.MessageID = 42
+	reqMsg.Token = []byte{1, 2}
+	reqMsg.SetPathString("foo")
+
+	ack := coapmsg.NewAck(reqMsg.MessageID)
+	ack.Code = coapmsg.Content
+	ack.Token = reqMsg.Token
+	ack.Payload = []byte("test")
+
+	ia := newTestInteraction(t, testCon, &reqMsg)
+	defer ia.Close()
+
+	go func() {
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := ia.RoundTrip(ctx, &reqMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ia.LastSent(), reqMsg.MustMarshalBinary()) {
+		t.Errorf("Expected last sent %v but got %v", reqMsg.MustMarshalBinary(), ia.LastSent())
+	}
+	if !bytes.Equal(ia.LastReceived(), ack.MustMarshalBinary()) {
+		t.Errorf("Expected last received %v but got %v", ack.MustMarshalBinary(), ia.LastReceived())
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	req := coapmsg.NewMessage()
+	req.Token = []byte{1, 2, 3, 4}
+
+	res := coapmsg.NewMessage()
+	res.Token = []byte{1, 2, 3, 4}
+	if err := validateToken(&req, &res); err != nil {
+		t.Errorf("Expected matching token to be valid but got: %s", err)
+	}
+
+	res.Token = []byte{1, 2}
+	if err := validateToken(&req, &res); err != ERR_TOKEN_LENGTH_MISMATCH {
+		t.Errorf("Expected %s but got: %v", ERR_TOKEN_LENGTH_MISMATCH, err)
+	}
+
+	res.Token = []byte{1, 2, 3, 5}
+	if err := validateToken(&req, &res); err != ERR_TOKEN_MISMATCH {
+		t.Errorf("Expected %s but got: %v", ERR_TOKEN_MISMATCH, err)
+	}
+}
+
+func TestInteractionClosedDuringRoundTrip(t *testing.T) {
+	testCon := NewTestConnector(t)
+
+	reqMsg := coapmsg.NewMessage()
+	reqMsg.Type = coapmsg.Confirmable
+	reqMsg.Code = coapmsg.GET
+	reqMsg.MessageID = 42
+	reqMsg.Token = []byte{1, 2}
+	reqMsg.SetPathString("foo")
+
+	ia := newTestInteraction(t, testCon, &reqMsg)
+
+	go func() {
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+			return
+		}
+		// Close the interaction instead of sending an ACK
+		ia.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := ia.RoundTrip(ctx, &reqMsg)
+	if err != ERR_INTERACTION_CLOSED {
+		t.Errorf("Expected %s but got: %v", ERR_INTERACTION_CLOSED, err)
+	}
+}
+
+func TestConnectionClosedDuringRoundTrip(t *testing.T) {
+	testCon := NewTestConnector(t)
+
+	reqMsg := coapmsg.NewMessage()
+	reqMsg.Type = coapmsg.Confirmable
+	reqMsg.Code = coapmsg.GET
+	reqMsg.MessageID = 42
+	reqMsg.Token = []byte{1, 2}
+	reqMsg.SetPathString("foo")
+
+	ia := newTestInteraction(t, testCon, &reqMsg)
+	defer ia.Close()
+
+	go func() {
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+			return
+		}
+		// Close the connection instead of sending an ACK
+		testCon.conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := ia.RoundTrip(ctx, &reqMsg)
+	if err != ERR_CONNECTION_CLOSED {
+		t.Errorf("Expected %s but got: %v", ERR_CONNECTION_CLOSED, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected round trip to return promptly on close but took %s", time.Since(start))
+	}
+}
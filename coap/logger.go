@@ -0,0 +1,70 @@
+package coap
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = &syncLogger{logger: logrus.StandardLogger()}
+
+var _ logrus.FieldLogger = (*syncLogger)(nil)
+
+// SetLogger replaces the logger of the package.
+// It is safe to call while requests and receive loops are running.
+func SetLogger(logger logrus.FieldLogger) {
+	log.set(logger)
+}
+
+// syncLogger forwards to a logrus.FieldLogger that can be replaced concurrently
+type syncLogger struct {
+	mu     sync.RWMutex
+	logger logrus.FieldLogger
+}
+
+func (l *syncLogger) set(logger logrus.FieldLogger) {
+	l.mu.Lock()
+	l.logger = logger
+	l.mu.Unlock()
+}
+
+func (l *syncLogger) get() logrus.FieldLogger {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.logger
+}
+
+func (l *syncLogger) WithField(key string, value interface{}) *logrus.Entry {
+	return l.get().WithField(key, value)
+}
+func (l *syncLogger) WithFields(fields logrus.Fields) *logrus.Entry {
+	return l.get().WithFields(fields)
+}
+func (l *syncLogger) WithError(err error) *logrus.Entry { return l.get().WithError(err) }
+
+func (l *syncLogger) Debugf(format string, args ...interface{})   { l.get().Debugf(format, args...) }
+func (l *syncLogger) Infof(format string, args ...interface{})    { l.get().Infof(format, args...) }
+func (l *syncLogger) Printf(format string, args ...interface{})   { l.get().Printf(format, args...) }
+func (l *syncLogger) Warnf(format string, args ...interface{})    { l.get().Warnf(format, args...) }
+func (l *syncLogger) Warningf(format string, args ...interface{}) { l.get().Warningf(format, args...) }
+func (l *syncLogger) Errorf(format string, args ...interface{})   { l.get().Errorf(format, args...) }
+func (l *syncLogger) Fatalf(format string, args ...interface{})   { l.get().Fatalf(format, args...) }
+func (l *syncLogger) Panicf(format string, args ...interface{})   { l.get().Panicf(format, args...) }
+
+func (l *syncLogger) Debug(args ...interface{})   { l.get().Debug(args...) }
+func (l *syncLogger) Info(args ...interface{})    { l.get().Info(args...) }
+func (l *syncLogger) Print(args ...interface{})   { l.get().Print(args...) }
+func (l *syncLogger) Warn(args ...interface{})    { l.get().Warn(args...) }
+func (l *syncLogger) Warning(args ...interface{}) { l.get().Warning(args...) }
+func (l *syncLogger) Error(args ...interface{})   { l.get().Error(args...) }
+func (l *syncLogger) Fatal(args ...interface{})   { l.get().Fatal(args...) }
+func (l *syncLogger) Panic(args ...interface{})   { l.get().Panic(args...) }
+
+func (l *syncLogger) Debugln(args ...interface{})   { l.get().Debugln(args...) }
+func (l *syncLogger) Infoln(args ...interface{})    { l.get().Infoln(args...) }
+func (l *syncLogger) Println(args ...interface{})   { l.get().Println(args...) }
+func (l *syncLogger) Warnln(args ...interface{})    { l.get().Warnln(args...) }
+func (l *syncLogger) Warningln(args ...interface{}) { l.get().Warningln(args...) }
+func (l *syncLogger) Errorln(args ...interface{})   { l.get().Errorln(args...) }
+func (l *syncLogger) Fatalln(args ...interface{})   { l.get().Fatalln(args...) }
+func (l *syncLogger) Panicln(args ...interface{})   { l.get().Panicln(args...) }
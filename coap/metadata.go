@@ -0,0 +1,29 @@
+package coap
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx with fields that are added to all log
+// entries of the request round trip, e.g. a request or tenant id to
+// correlate logs. Fields of a parent context are kept unless overwritten.
+func WithMetadata(ctx context.Context, fields map[string]interface{}) context.Context {
+	merged := logrus.Fields{}
+	for k, v := range metadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// metadataFromContext returns the fields set by WithMetadata or nil
+func metadataFromContext(ctx context.Context) logrus.Fields {
+	fields, _ := ctx.Value(metadataKey{}).(logrus.Fields)
+	return fields
+}
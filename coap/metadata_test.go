@@ -0,0 +1,68 @@
+package coap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestWithMetadataMergesParentFields(t *testing.T) {
+	ctx := WithMetadata(context.Background(), map[string]interface{}{"tenant": "a", "requestId": 1})
+	ctx = WithMetadata(ctx, map[string]interface{}{"requestId": 2})
+
+	fields := metadataFromContext(ctx)
+	if fields["tenant"] != "a" || fields["requestId"] != 2 {
+		t.Errorf("Expected merged metadata but got %v", fields)
+	}
+}
+
+func TestMetadataInMessageLog(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	SetLogger(logger)
+	defer SetLogger(logrus.StandardLogger())
+
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithMetadata(context.Background(), map[string]interface{}{"requestId": "abc"})
+	if _, err := trans.RoundTrip(req.WithContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := map[string]bool{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["requestId"] == "abc" {
+			logged[entry.Message] = true
+		}
+	}
+	for _, message := range []string{"CoAP message: Send", "CoAP message: Received"} {
+		if !logged[message] {
+			t.Errorf("Expected metadata in log entry %q", message)
+		}
+	}
+	ValidateCleanConnection(t, testCon)
+}
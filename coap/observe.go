@@ -0,0 +1,71 @@
+package coap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// Observe sequence numbers are 24 bit and wrap around (RFC 7641, 3.4)
+const observeSeqWindow = 1 << 23
+
+// A notification received more than observeSeqTimeout after the last
+// one is always newer, even if the sequence number says otherwise
+const observeSeqTimeout = 128 * time.Second
+
+// observeSequence detects reordered notifications by their Observe option
+type observeSequence struct {
+	mu       sync.Mutex
+	clock    Clock
+	set      bool
+	last     uint32
+	lastTime time.Time
+}
+
+// accept returns true and remembers seq when it is newer than the last accepted sequence
+func (s *observeSequence) accept(seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if s.set && !isNewerObserve(s.last, s.lastTime, seq, now) {
+		return false
+	}
+	s.set = true
+	s.last = seq
+	s.lastTime = now
+	return true
+}
+
+func (s *observeSequence) lastSeq() (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.set
+}
+
+// isNewerObserve is true when the notification v2 received at t2
+// is newer than v1 received at t1 (RFC 7641, 3.4)
+func isNewerObserve(v1 uint32, t1 time.Time, v2 uint32, t2 time.Time) bool {
+	return (v1 < v2 && v2-v1 < observeSeqWindow) ||
+		(v1 > v2 && v1-v2 > observeSeqWindow) ||
+		t2.After(t1.Add(observeSeqTimeout))
+}
+
+// observeSeq returns the value of the Observe option
+func observeSeq(msg *coapmsg.Message) (uint32, bool) {
+	opt := msg.Options().Get(coapmsg.Observe)
+	if opt.IsNotSet() {
+		return 0, false
+	}
+	return opt.AsUInt32(), true
+}
+
+// LastObserveSeq returns the Observe sequence number of the last accepted
+// notification. ok is false if the interaction is not observing.
+func (ia *Interaction) LastObserveSeq() (seq uint32, ok bool) {
+	if ia.observeSeq == nil {
+		return 0, false
+	}
+	return ia.observeSeq.lastSeq()
+}
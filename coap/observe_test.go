@@ -0,0 +1,140 @@
+package coap
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+func TestIsNewerObserve(t *testing.T) {
+	t1 := time.Unix(0, 0)
+	tests := []struct {
+		name   string
+		v1, v2 uint32
+		t2     time.Time
+		newer  bool
+	}{
+		{"in order", 5, 6, t1, true},
+		{"same", 5, 5, t1, false},
+		{"reordered", 6, 5, t1, false},
+		{"wrapped", 1<<24 - 1, 0, t1, true},
+		{"reordered over wrap", 0, 1<<24 - 1, t1, false},
+		{"too far ahead", 0, 1 << 23, t1, false},
+		{"reordered after 128s", 6, 5, t1.Add(129 * time.Second), true},
+	}
+	for _, test := range tests {
+		if newer := isNewerObserve(test.v1, t1, test.v2, test.t2); newer != test.newer {
+			t.Errorf("%s: Expected isNewerObserve(%d, %d) = %v", test.name, test.v1, test.v2, test.newer)
+		}
+	}
+}
+
+func TestObserveSequenceAccept(t *testing.T) {
+	clock := newFakeClock()
+	seq := &observeSequence{clock: clock}
+
+	for _, step := range []struct {
+		seq    uint32
+		accept bool
+	}{{1<<24 - 2, true}, {1<<24 - 1, true}, {1<<24 - 3, false}, {0, true}, {1<<24 - 1, false}, {2, true}} {
+		if accepted := seq.accept(step.seq); accepted != step.accept {
+			t.Errorf("Expected accept(%d) = %v", step.seq, step.accept)
+		}
+	}
+	if last, ok := seq.lastSeq(); !ok || last != 2 {
+		t.Errorf("Expected last sequence 2 but got %d", last)
+	}
+
+	// After 128 seconds any sequence number is newer
+	clock.Advance(129 * time.Second)
+	if !seq.accept(1) {
+		t.Error("Expected old sequence number to be accepted after 128 seconds")
+	}
+}
+
+func TestReorderedNotificationIsDropped(t *testing.T) {
+	client, testCon := NewTestClient(t)
+	trans := client.Transport.(*TransportUart)
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("5")
+		ack.Options().Add(coapmsg.Observe, 5)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// 4 arrives after 5 and is acknowledged but dropped
+		for i, seq := range []int{4, 6} {
+			notify := coapmsg.NewMessage()
+			notify.Type = coapmsg.Confirmable
+			notify.Code = coapmsg.Content
+			notify.MessageID = uint16(1000 + i)
+			notify.Token = msg.Token
+			notify.Payload = []byte{byte('0' + seq)}
+			notify.Options().Add(coapmsg.Observe, seq)
+			if err := testCon.ServerSend(notify); err != nil {
+				t.Error(err)
+			}
+			ack, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ack.Type != coapmsg.Acknowledgement || ack.MessageID != notify.MessageID {
+				t.Errorf("Expected ACK for notification %d but got %s", seq, ack.String())
+			}
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification, err := res.NextWithTimeout(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(notification.Body)
+	if string(body) != "6" {
+		t.Errorf("Expected notification 6 but got %s", body)
+	}
+
+	ia := trans.FindInteraction("any", res.Request.Token)
+	if ia == nil {
+		t.Fatal("Expected observing interaction")
+	}
+	if seq, ok := ia.LastObserveSeq(); !ok || seq != 6 {
+		t.Errorf("Expected last observe sequence 6 but got %d", seq)
+	}
+
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Error(err)
+	}
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
@@ -0,0 +1,176 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// pipeQueueSize is the number of packets buffered in each direction of a PipeConnection
+const pipeQueueSize = 128
+
+// ERR_PIPE_FULL is returned when a message does not fit into the queue of a PipeConnection
+var ERR_PIPE_FULL = errors.New("coap: Pipe queue is full")
+
+// ERR_PIPE_TIMEOUT is returned by PipeConnection.Receive when the client sent no message in time
+var ERR_PIPE_TIMEOUT error = &coapError{err: "coap: Timeout while waiting for a message on the pipe", timeout: true}
+
+var _ SerialConnecter = (*PipeConnection)(nil)
+var _ Connection = (*PipeConnection)(nil)
+
+// PipeConnection is an in-memory Connection to test code that uses the
+// TransportUart without a serial port. It is the supported way to test
+// against the real transport: the test acts as server, Receive returns
+// the messages sent by the client and Send delivers messages to the client.
+//
+// The PipeConnection is its own SerialConnecter, every host connects to it:
+//
+//	pipe := coap.NewPipeConnection()
+//	trans := coap.NewTransportUart()
+//	trans.Connecter = pipe
+type PipeConnection struct {
+	Interactions
+
+	toClient chan []byte
+	toServer chan []byte
+
+	mu                sync.Mutex // Guards open, done and cancelReceiveLoop
+	open              bool
+	done              chan struct{} // Closed when the connection is closed
+	cancelReceiveLoop context.CancelFunc
+}
+
+func NewPipeConnection() *PipeConnection {
+	return &PipeConnection{
+		toClient: make(chan []byte, pipeQueueSize),
+		toServer: make(chan []byte, pipeQueueSize),
+	}
+}
+
+// Send delivers msg to the client like a message sent by the server
+func (c *PipeConnection) Send(msg coapmsg.Message) error {
+	p, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	select {
+	case c.toClient <- p:
+		return nil
+	default:
+		return ERR_PIPE_FULL
+	}
+}
+
+// Receive returns the next message sent by the client.
+// It fails with ERR_PIPE_TIMEOUT when there is no message within timeout.
+func (c *PipeConnection) Receive(timeout time.Duration) (coapmsg.Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case p := <-c.toServer:
+		return coapmsg.ParseMessage(p)
+	case <-timer.C:
+		return coapmsg.NewMessage(), ERR_PIPE_TIMEOUT
+	}
+}
+
+// Connect implements SerialConnecter and opens the pipe if it is closed
+func (c *PipeConnection) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+// ConnectContext implements SerialConnecter and opens the pipe if it is closed
+func (c *PipeConnection) ConnectContext(ctx context.Context, host string) (Connection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c, c.Open()
+}
+
+func (c *PipeConnection) findConnection(host string) Connection {
+	if c.Closed() {
+		return nil
+	}
+	return c
+}
+
+func (c *PipeConnection) Name() string {
+	return "pipe"
+}
+
+func (c *PipeConnection) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open {
+		return nil
+	}
+	c.open = true
+	c.done = make(chan struct{})
+
+	receiveLoopCtx, cancelReceiveLoop := context.WithCancel(context.Background())
+	c.cancelReceiveLoop = cancelReceiveLoop
+	go receiveLoop(receiveLoopCtx, c)
+	return nil
+}
+
+func (c *PipeConnection) Close() error {
+	c.mu.Lock()
+	if !c.open {
+		c.mu.Unlock()
+		return nil
+	}
+	c.open = false
+	c.cancelReceiveLoop()
+	close(c.done)
+	c.mu.Unlock()
+
+	c.connectionClosed()
+	return nil
+}
+
+func (c *PipeConnection) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.open
+}
+
+// ReadPacket blocks until the server sends a message or the pipe is closed
+func (c *PipeConnection) ReadPacket() (p []byte, isPrefix bool, err error) {
+	c.mu.Lock()
+	done := c.done
+	open := c.open
+	c.mu.Unlock()
+	if !open {
+		return nil, false, ERR_CONNECTION_CLOSED
+	}
+
+	select {
+	case p := <-c.toClient:
+		return p, false, nil
+	case <-done:
+		return nil, false, ERR_CONNECTION_CLOSED
+	}
+}
+
+func (c *PipeConnection) WritePacket(p []byte) error {
+	if c.Closed() {
+		return ERR_CONNECTION_CLOSED
+	}
+	select {
+	case c.toServer <- append([]byte(nil), p...):
+		return nil
+	default:
+		return ERR_PIPE_FULL
+	}
+}
+
+func (c *PipeConnection) Ping(ctx context.Context) (bool, error) {
+	return pingConnection(ctx, c)
+}
+
+func (c *PipeConnection) SerialParams() (SerialConnectionParams, bool) {
+	return SerialConnectionParams{}, false
+}
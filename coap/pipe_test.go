@@ -0,0 +1,51 @@
+package coap
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+func TestPipeConnectionPiggyback(t *testing.T) {
+	pipe := NewPipeConnection()
+	trans := NewTransportUart()
+	trans.Connecter = pipe
+	client := NewClient()
+	client.Transport = trans
+
+	go func() {
+		msg, err := pipe.Receive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.Confirmable || msg.PathString() != "foo" {
+			t.Errorf("Expected CON request to foo but got %v", msg)
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("bar")
+		if err := pipe.Send(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Get("coap+uart://any/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != coapmsg.Content.Number() || string(body) != "bar" {
+		t.Errorf("Expected 2.05 Content with body bar but got %s %s", res.Status, body)
+	}
+
+	if n := pipe.InteractionCount(); n != 0 {
+		t.Errorf("Expected no interactions left but got %d", n)
+	}
+	if _, err := pipe.Receive(50 * time.Millisecond); err != ERR_PIPE_TIMEOUT {
+		t.Errorf("Expected no more messages but got %v", err)
+	}
+}
@@ -3,10 +3,13 @@ package coap
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
+	"sort"
+	"strings"
 
 	"github.com/lobaro/coap-go/coapmsg"
 )
@@ -47,12 +50,29 @@ type Request struct {
 	// CoAP Options are like HTTP Headers and used in a similar way
 	Options coapmsg.CoapOptions
 
+	// ContentFormat is sent as Content-Format option to declare the body format,
+	// e.g. coapmsg.AppJSON.Ptr(). nil sends no option.
+	// When set it overrides a Content-Format in Options.
+	ContentFormat *coapmsg.MediaType
+
+	// IfMatchAny sends an empty If-Match option, which makes the request
+	// conditional on any existing representation of the target resource.
+	// ETags to match are set as If-Match values in Options.
+	IfMatchAny bool
+
 	// Token specifies the Reuquest token that is used to identify the response
 	//
 	// If the Token is empty it will be issued by the Transport
 	// The client can set a specific Token, e.g. to cancel a specific observe request
 	Token Token
 
+	// NotificationBufferSize is the capacity of Response.Next for observe
+	// requests. Notifications are only dropped when the buffer is full and
+	// the consumer does not take one in time. 0 means unbuffered.
+	// Without AckNotifyImmediately CON notifications are acknowledged
+	// when they are buffered.
+	NotificationBufferSize int
+
 	// Body is the request's body.
 	//
 	// For client requests a nil body means the request has no
@@ -134,6 +154,13 @@ func NewRequest(method, urlStr string, body io.Reader) (*Request, error) {
 	return req, nil
 }
 
+// SetConfirmable sets Confirmable and returns r to chain calls,
+// e.g. client.Do(req.SetConfirmable(false))
+func (r *Request) SetConfirmable(confirmable bool) *Request {
+	r.Confirmable = confirmable
+	return r
+}
+
 // Context returns the request's context. To change the context, use
 // WithContext.
 //
@@ -173,6 +200,146 @@ func (r *Request) closeBody() {
 	}
 }
 
+// requestJSON is the portable form of a Request used for logging and replay
+type requestJSON struct {
+	Method        string             `json:"method"`
+	URL           string             `json:"url"`
+	Confirmable   bool               `json:"confirmable"`
+	Token         []byte             `json:"token,omitempty"`
+	Options       []optionJSON       `json:"options,omitempty"`
+	IfMatchAny    bool               `json:"ifMatchAny,omitempty"`
+	ContentFormat *coapmsg.MediaType `json:"contentFormat,omitempty"`
+	Body          []byte             `json:"body,omitempty"`
+}
+
+type optionJSON struct {
+	Id    coapmsg.OptionId `json:"id"`
+	Name  string           `json:"name,omitempty"` // Informational only
+	Value []byte           `json:"value"`
+}
+
+// MarshalJSON captures the CoAP relevant fields of the request:
+// Method, URL, Confirmable, Token, Options, IfMatchAny, ContentFormat and Body.
+// The context is not captured.
+//
+// The body is read and replaced by an in memory copy,
+// so the request can still be sent afterwards.
+func (r *Request) MarshalJSON() ([]byte, error) {
+	rj := requestJSON{
+		Method:        r.Method,
+		Confirmable:   r.Confirmable,
+		Token:         r.Token,
+		IfMatchAny:    r.IfMatchAny,
+		ContentFormat: r.ContentFormat,
+	}
+	if r.URL != nil {
+		rj.URL = r.URL.String()
+	}
+
+	ids := make([]int, 0, len(r.Options))
+	for id := range r.Options {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		optId := coapmsg.OptionId(id)
+		for _, v := range r.Options[optId].Values() {
+			rj.Options = append(rj.Options, optionJSON{Id: optId, Name: optId.String(), Value: v.AsBytes()})
+		}
+	}
+
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.closeBody()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		rj.Body = body
+	}
+
+	return json.Marshal(rj)
+}
+
+// UnmarshalJSON restores a request captured with MarshalJSON.
+// The restored request uses the background context.
+func (r *Request) UnmarshalJSON(data []byte) error {
+	rj := requestJSON{}
+	if err := json.Unmarshal(data, &rj); err != nil {
+		return err
+	}
+
+	req, err := NewRequest(rj.Method, rj.URL, bytes.NewReader(rj.Body))
+	if err != nil {
+		return err
+	}
+	req.Confirmable = rj.Confirmable
+	req.Token = rj.Token
+	req.IfMatchAny = rj.IfMatchAny
+	req.ContentFormat = rj.ContentFormat
+	for _, o := range rj.Options {
+		if err := req.Options.Add(o.Id, o.Value); err != nil {
+			return err
+		}
+	}
+
+	*r = *req
+	return nil
+}
+
+// ValidateOptions checks that the server understands all critical options of the request.
+// known contains the options supported by the server.
+// Unknown critical options would be rejected by the server with 4.02 (Bad Option),
+// unknown elective options are silently ignored by the server and therefore allowed.
+func (r *Request) ValidateOptions(known map[coapmsg.OptionId]bool) error {
+	unknown := make([]int, 0)
+	for id := range r.Options {
+		if id.Critical() && !known[id] {
+			unknown = append(unknown, int(id))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Ints(unknown)
+	names := make([]string, 0, len(unknown))
+	for _, id := range unknown {
+		names = append(names, fmt.Sprintf("%s (%d)", coapmsg.OptionId(id), id))
+	}
+	return fmt.Errorf("coap: critical options not supported by server: %s", strings.Join(names, ", "))
+}
+
+// SetProxyURI sets the Proxy-Uri option to send the request through a forward-proxy,
+// e.g. to http://example.com/sensors. The Uri-Host, Uri-Port, Uri-Path and Uri-Query
+// options are removed and not built from the URL, they must not be used together
+// with Proxy-Uri (RFC 7252, 5.10.2).
+func (r *Request) SetProxyURI(proxyURI string) error {
+	if !coapmsg.ProxyURI.ValidLength(len(proxyURI)) {
+		return ERR_INVALID_OPTION_LENGTH
+	}
+	for _, id := range []coapmsg.OptionId{coapmsg.URIHost, coapmsg.URIPort, coapmsg.URIPath, coapmsg.URIQuery} {
+		r.Options.Del(id)
+	}
+	return r.Options.Set(coapmsg.ProxyURI, proxyURI)
+}
+
+// SetProxyScheme sets the Proxy-Scheme option. The forward-proxy requests
+// the URI built from the scheme and the Uri-* options, e.g. "http".
+func (r *Request) SetProxyScheme(scheme string) error {
+	if !coapmsg.ProxyScheme.ValidLength(len(scheme)) {
+		return ERR_INVALID_OPTION_LENGTH
+	}
+	return r.Options.Set(coapmsg.ProxyScheme, scheme)
+}
+
+// SuppressResponse sets the No-Response option (RFC 7967) to tell the server
+// which response classes it must not send, e.g. coapmsg.NoResponse2xx.
+// A NON request that suppresses all classes does not wait for a response.
+func (r *Request) SuppressResponse(classes uint8) {
+	r.Options.Set(coapmsg.NoResponse, classes)
+}
+
 var methodToCodeTable = map[string]coapmsg.COAPCode{
 	"PING":   coapmsg.Empty,
 	"GET":    coapmsg.GET,
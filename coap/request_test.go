@@ -0,0 +1,81 @@
+package coap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+func TestRequestJSONRoundTrip(t *testing.T) {
+	req, err := NewRequest("PUT", "coap+uart://any/sensors/temp?unit=c", bytes.NewReader([]byte("22.5")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Token = Token{1, 2, 3}
+	req.Confirmable = false
+	req.Options.Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+	req.Options.Add(coapmsg.ETag, []byte{0xAA})
+	req.Options.Add(coapmsg.ETag, []byte{0xBB})
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := &Request{}
+	if err := json.Unmarshal(data, replay); err != nil {
+		t.Fatal(err)
+	}
+
+	if replay.Method != "PUT" {
+		t.Errorf("Expected method PUT but got %s", replay.Method)
+	}
+	if replay.URL.String() != req.URL.String() {
+		t.Errorf("Expected URL %s but got %s", req.URL, replay.URL)
+	}
+	if replay.Confirmable {
+		t.Error("Expected request to be non confirmable")
+	}
+	if !replay.Token.Equals(req.Token) {
+		t.Errorf("Expected token %v but got %v", req.Token, replay.Token)
+	}
+	if replay.Options.Get(coapmsg.ContentFormat).AsUInt8() != uint8(coapmsg.TextPlain) {
+		t.Errorf("Expected content format %d but got %s", coapmsg.TextPlain, replay.Options.Get(coapmsg.ContentFormat))
+	}
+	etags := replay.Options.Get(coapmsg.ETag).Values()
+	if len(etags) != 2 || etags[0].AsBytes()[0] != 0xAA || etags[1].AsBytes()[0] != 0xBB {
+		t.Errorf("Expected ETags [0xAA, 0xBB] but got %s", replay.Options.Get(coapmsg.ETag))
+	}
+
+	for name, r := range map[string]*Request{"original": req, "replay": replay} {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "22.5" {
+			t.Errorf("Expected %s body '22.5' but got '%s'", name, body)
+		}
+	}
+}
+
+func TestRequestValidateOptions(t *testing.T) {
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := map[coapmsg.OptionId]bool{coapmsg.Accept: true}
+
+	req.Options.Set(coapmsg.Accept, coapmsg.AppJSON)
+	req.Options.Set(coapmsg.OptionId(3002), 1) // Unknown elective option
+	if err := req.ValidateOptions(known); err != nil {
+		t.Errorf("Expected known and elective options to be valid but got: %s", err)
+	}
+
+	req.Options.Set(coapmsg.OptionId(3001), 1) // Unknown critical option
+	if err := req.ValidateOptions(known); err == nil {
+		t.Error("Expected unknown critical option to fail validation")
+	}
+}
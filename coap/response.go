@@ -1,7 +1,15 @@
 package coap
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/lobaro/coap-go/coapmsg"
@@ -21,10 +29,14 @@ type Response struct {
 	// a zero-length body. It is the caller's responsibility to
 	// close Body. The default CoAP client's Transport does not
 	// attempt to reuse connections ("keep-alive") unless the Body
-	// is read to completion and is closed.
+	// is read to completion and is closed. Use DrainAndClose when
+	// discarding a response, it does not fetch the rest of a
+	// streamed block-wise body.
 	//
-	// The Body is automatically dechunked if the server replied
-	// with a "chunked" Transfer-Encoding.
+	// The Body is automatically reassembled if the server replied
+	// with a block-wise response (Block2 option). With
+	// TransportUart.StreamBlockWiseResponses the blocks are requested
+	// while the Body is read, closing it early stops the transfer.
 	// See: RFC 7959 (Block-wise transfers in CoAP)
 	Body io.ReadCloser
 
@@ -39,14 +51,193 @@ type Response struct {
 	// response from the server when the resource does change.
 	// OBSERVE_TIMEOUT is the longest possible observe duration.
 	// TODO: OBSERVE_TIMEOUT is not implemented yet
+	// The observe ends with ERR_OBSERVE_STALE, see ObserveErr, when no
+	// notification arrives within the Max-Age of the last one.
 	//
 	// To stop the observation just send a new get request with
 	// observe option set to 1 (one).
 	//
-	// Must be unbuffered, to be able to detect if the application is listening
+	// Unbuffered by default, to be able to detect if the application is listening,
+	// see Request.NotificationBufferSize
 	next chan *Response
+
+	// observe is shared by all notifications of an observe
+	observe *observeState
+}
+
+// observeState records why the notifications of an observe ended
+type observeState struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (s *observeState) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// NewResponse returns a Response with the given code, body and options,
+// e.g. to test code that handles responses without a transport.
+// A nil opts results in empty options.
+func NewResponse(code coapmsg.COAPCode, body []byte, opts coapmsg.CoapOptions) *Response {
+	if opts == nil {
+		opts = coapmsg.CoapOptions{}
+	}
+	return &Response{
+		StatusCode: code.Number(),
+		Status:     fmt.Sprintf("%d.%02d %s", code.Class(), code.Detail(), code.String()),
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Options:    opts,
+	}
+}
+
+// DrainAndClose reads the Body to EOF and closes it, so the
+// connection can be reused. Call it when the response is discarded.
+// A streamed block-wise Body is only closed, which stops the transfer.
+func (r *Response) DrainAndClose() error {
+	if r.Body == nil {
+		return nil
+	}
+	if isBlockStream(r.Body) {
+		return r.Body.Close()
+	}
+	_, err := io.Copy(ioutil.Discard, r.Body)
+	if cerr := r.Body.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 func (r Response) Next() <-chan *Response {
 	return r.next
 }
+
+// ObserveErr returns why the Next channel was closed, e.g. ERR_OBSERVE_STALE.
+// It is nil while the observe is running or when it ended regularly.
+func (r Response) ObserveErr() error {
+	if r.observe == nil {
+		return nil
+	}
+	r.observe.mu.Lock()
+	defer r.observe.mu.Unlock()
+	return r.observe.err
+}
+
+// ERR_NOTIFICATION_TIMEOUT is returned by NextWithTimeout when no notification arrived in time
+var ERR_NOTIFICATION_TIMEOUT error = &coapError{err: "coap: Timeout while waiting for notification", timeout: true}
+
+// ERR_NO_MORE_NOTIFICATIONS is returned by NextWithTimeout when the observe has ended
+var ERR_NO_MORE_NOTIFICATIONS = errors.New("coap: No more notifications")
+
+// NextWithTimeout waits up to d for the next notification of an observe.
+func (r Response) NextWithTimeout(d time.Duration) (*Response, error) {
+	if r.next == nil {
+		return nil, ERR_NO_MORE_NOTIFICATIONS
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case res, ok := <-r.next:
+		if !ok {
+			if err := r.ObserveErr(); err != nil {
+				return nil, err
+			}
+			return nil, ERR_NO_MORE_NOTIFICATIONS
+		}
+		return res, nil
+	case <-timer.C:
+		return nil, ERR_NOTIFICATION_TIMEOUT
+	}
+}
+
+// RetryAfter returns how long the client should wait before retrying
+// a request that was answered with 5.03 Service Unavailable.
+// The server indicates the duration in the Max-Age option.
+// For all other responses RetryAfter returns 0.
+func (r *Response) RetryAfter() time.Duration {
+	if r.StatusCode != coapmsg.ServiceUnavailable.Number() {
+		return 0
+	}
+	maxAge := r.Options.Get(coapmsg.MaxAge)
+	if maxAge.IsNotSet() {
+		return 0
+	}
+	return time.Duration(maxAge.AsUInt32()) * time.Second
+}
+
+// ETag returns the first ETag of the response or nil
+func (r *Response) ETag() []byte {
+	etags := r.ETags()
+	if len(etags) == 0 {
+		return nil
+	}
+	return etags[0]
+}
+
+// ETags returns all ETags of the response in order.
+// Servers rarely send more than one, e.g. for resources with several representations.
+func (r *Response) ETags() [][]byte {
+	etags := [][]byte{}
+	for _, v := range r.Options.Get(coapmsg.ETag).Values() {
+		etags = append(etags, v.AsBytes())
+	}
+	return etags
+}
+
+// UriHost returns the Uri-Host option of the response or an empty string.
+// Responses rarely carry it, but it helps to debug proxies and virtual hosts.
+func (r *Response) UriHost() string {
+	return r.Options.Get(coapmsg.URIHost).AsString()
+}
+
+// Location returns the URL of the resource created by a POST, built from
+// the Location-Path and Location-Query options. Scheme and host are taken
+// from the request. Returns nil if the response carries no location.
+func (r *Response) Location() *url.URL {
+	path := r.Options.Get(coapmsg.LocationPath).Values()
+	query := r.Options.Get(coapmsg.LocationQuery).Values()
+	if len(path) == 0 && len(query) == 0 {
+		return nil
+	}
+
+	segments := make([]string, 0, len(path))
+	for _, v := range path {
+		segments = append(segments, v.AsString())
+	}
+	params := make([]string, 0, len(query))
+	for _, v := range query {
+		params = append(params, v.AsString())
+	}
+
+	loc := &url.URL{
+		Path:     "/" + strings.Join(segments, "/"),
+		RawQuery: strings.Join(params, "&"),
+	}
+	if r.Request != nil && r.Request.URL != nil {
+		loc.Scheme = r.Request.URL.Scheme
+		loc.Host = r.Request.URL.Host
+	}
+	return loc
+}
+
+// UnknownOptions returns the ids of all response options that are not
+// defined in the option registry, sorted by id. This helps to discover
+// undocumented options of vendor devices.
+func (r *Response) UnknownOptions() []coapmsg.OptionId {
+	ids := make([]int, 0)
+	for id := range r.Options {
+		if !id.Known() {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+
+	unknown := make([]coapmsg.OptionId, 0, len(ids))
+	for _, id := range ids {
+		unknown = append(unknown, coapmsg.OptionId(id))
+	}
+	return unknown
+}
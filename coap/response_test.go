@@ -0,0 +1,253 @@
+package coap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+func TestNewResponse(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	opts.Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+
+	res := NewResponse(coapmsg.Content, []byte("22.5 C"), opts)
+
+	if res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected status code %d but got %d", coapmsg.Content.Number(), res.StatusCode)
+	}
+	if res.Status != "2.05 Content" {
+		t.Errorf("Expected status 2.05 Content but got %s", res.Status)
+	}
+	if res.Options.Get(coapmsg.ContentFormat).AsUInt8() != uint8(coapmsg.TextPlain) {
+		t.Error("Expected content format option to be set")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "22.5 C" {
+		t.Errorf("Expected body 22.5 C but got %s", string(body))
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewResponseWithoutOptions(t *testing.T) {
+	res := NewResponse(coapmsg.NotFound, nil, nil)
+	if res.Options == nil {
+		t.Error("Expected non nil options")
+	}
+	if res.Status != "4.04 NotFound" {
+		t.Errorf("Expected status 4.04 NotFound but got %s", res.Status)
+	}
+}
+
+func TestResponseUnknownOptions(t *testing.T) {
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.Acknowledgement
+	msg.Code = coapmsg.Content
+	msg.Options().Set(coapmsg.ContentFormat, coapmsg.TextPlain)
+	msg.Options().Set(3004, []byte{1})
+	msg.Options().Set(3000, []byte{2})
+
+	parsed, err := coapmsg.ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := buildResponse(nil, &parsed)
+
+	unknown := res.UnknownOptions()
+	if len(unknown) != 2 || unknown[0] != 3000 || unknown[1] != 3004 {
+		t.Errorf("Expected unknown options [3000 3004] but got %v", unknown)
+	}
+	if res.Options.Get(3000).AsUInt8() != 2 {
+		t.Error("Expected unknown option to be kept in options")
+	}
+}
+
+func TestResponseNextWithTimeout(t *testing.T) {
+	res := NewResponse(coapmsg.Content, nil, nil)
+	res.next = make(chan *Response)
+
+	start := time.Now()
+	next, err := res.NextWithTimeout(100 * time.Millisecond)
+	if err != ERR_NOTIFICATION_TIMEOUT {
+		t.Errorf("Expected %s but got: %v", ERR_NOTIFICATION_TIMEOUT, err)
+	}
+	if next != nil {
+		t.Error("Expected no notification")
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("Expected NextWithTimeout to wait for the timeout")
+	}
+
+	notification := NewResponse(coapmsg.Content, []byte("2"), nil)
+	go func() {
+		res.next <- notification
+	}()
+	next, err = res.NextWithTimeout(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != notification {
+		t.Error("Expected the sent notification")
+	}
+
+	close(res.next)
+	if _, err := res.NextWithTimeout(time.Second); err != ERR_NO_MORE_NOTIFICATIONS {
+		t.Errorf("Expected %s but got: %v", ERR_NO_MORE_NOTIFICATIONS, err)
+	}
+}
+
+func TestResponseDrainAndCloseReusesConnection(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			ack.Payload = []byte("discarded")
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := trans.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := res.DrainAndClose(); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := res.Body.Read(make([]byte, 1)); n != 0 {
+			t.Error("Expected body to be drained")
+		}
+	}
+
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestResponseDrainAndCloseStopsBlockStream(t *testing.T) {
+	trans := NewTransportUart()
+	trans.StreamBlockWiseResponses = true
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = bytes.Repeat([]byte("a"), 16)
+		block := coapmsg.Block{Num: 0, More: true, SZX: 0}
+		ack.Options().Set(coapmsg.Block2, block.Bytes())
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		if msg, err := testCon.ServerReceive(200 * time.Millisecond); err == nil {
+			t.Errorf("Expected no Block2 request after DrainAndClose but got %s", msg.String())
+		}
+	}()
+
+	// The client Timeout wraps the body
+	client := NewClient()
+	client.Timeout = 3 * time.Second
+	client.Transport = trans
+
+	res, err := client.Get("coap+uart://any/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.DrainAndClose(); err != nil {
+		t.Fatal(err)
+	}
+	<-serverDone
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestResponseUriHost(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	opts.Set(coapmsg.URIHost, "sensor.example.com")
+	res := NewResponse(coapmsg.Content, nil, opts)
+
+	if res.UriHost() != "sensor.example.com" {
+		t.Errorf("Expected Uri-Host sensor.example.com but got %q", res.UriHost())
+	}
+
+	res = NewResponse(coapmsg.Content, nil, nil)
+	if res.UriHost() != "" {
+		t.Errorf("Expected empty Uri-Host but got %q", res.UriHost())
+	}
+}
+
+func TestResponseLocation(t *testing.T) {
+	req, err := NewRequest("POST", "coap+uart://any/sensors", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := coapmsg.CoapOptions{}
+	opts.Add(coapmsg.LocationPath, "sensors")
+	opts.Add(coapmsg.LocationPath, "42")
+	opts.Add(coapmsg.LocationQuery, "unit=c")
+	opts.Add(coapmsg.LocationQuery, "fast")
+	res := NewResponse(coapmsg.Created, nil, opts)
+	res.Request = req
+
+	if loc := res.Location(); loc == nil || loc.String() != "coap+uart://any/sensors/42?unit=c&fast" {
+		t.Errorf("Expected location coap+uart://any/sensors/42?unit=c&fast but got %v", loc)
+	}
+
+	res = NewResponse(coapmsg.Created, nil, nil)
+	if loc := res.Location(); loc != nil {
+		t.Errorf("Expected no location but got %v", loc)
+	}
+}
+
+func TestResponseETags(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	opts.Add(coapmsg.ETag, []byte{0x01, 0x02})
+	opts.Add(coapmsg.ETag, []byte{0x03})
+	res := NewResponse(coapmsg.Content, nil, opts)
+
+	etags := res.ETags()
+	if len(etags) != 2 {
+		t.Fatalf("Expected 2 ETags but got %d", len(etags))
+	}
+	if !bytes.Equal(etags[0], []byte{0x01, 0x02}) || !bytes.Equal(etags[1], []byte{0x03}) {
+		t.Errorf("Expected ETags in order but got %v", etags)
+	}
+	if !bytes.Equal(res.ETag(), []byte{0x01, 0x02}) {
+		t.Errorf("Expected first ETag but got %v", res.ETag())
+	}
+
+	res = NewResponse(coapmsg.Content, nil, nil)
+	if len(res.ETags()) != 0 || res.ETag() != nil {
+		t.Error("Expected no ETags")
+	}
+}
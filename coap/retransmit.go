@@ -0,0 +1,156 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// RetransmitBudget paces retransmissions of CON requests globally.
+// When many exchanges time out at once, e.g. on a congested shared link,
+// the budget prevents a retransmission storm. A budget can be shared
+// between transports.
+//
+// The budget is a token bucket: perSecond tokens are added each second
+// up to burst tokens. Each retransmission takes one token and waits
+// for it when the bucket is empty. A retransmission that can not get
+// a token before its request context is done is dropped.
+type RetransmitBudget struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64 // Negative when retransmissions are waiting for tokens
+	last      time.Time
+
+	dropped uint64 // accessed atomically
+}
+
+func NewRetransmitBudget(perSecond float64, burst int) *RetransmitBudget {
+	return &RetransmitBudget{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// Dropped returns the number of retransmissions dropped due to the budget
+func (b *RetransmitBudget) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// reserve takes a token and returns how long to wait until it is available
+func (b *RetransmitBudget) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.perSecond * float64(time.Second))
+}
+
+// cancel returns a reserved token that was not used
+func (b *RetransmitBudget) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// wait blocks until a retransmission is allowed.
+// Returns false and counts the retransmission as dropped when ctx is done before.
+func (b *RetransmitBudget) wait(ctx context.Context) bool {
+	delay := b.reserve()
+	if delay == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		b.cancel()
+		atomic.AddUint64(&b.dropped, 1)
+		return false
+	}
+}
+
+// readResponseWithRetransmit waits for the response to a CON request. Without
+// response the request is retransmitted up to ia.maxRetransmit times and the
+// timeout is doubled each time (RFC 7252, 4.2).
+func (ia *Interaction) readResponseWithRetransmit(ctx context.Context, reqMsg *coapmsg.Message) (*coapmsg.Message, error) {
+	timeout := ackTimeout()
+	for attempt := 0; ; attempt++ {
+		withAckTimeout, cancel := withClockTimeout(ctx, ia.getClock(), timeout)
+		resMsg, err := ia.readValidResponse(withAckTimeout, reqMsg)
+		cancel()
+
+		if err != READ_MESSAGE_CTX_DONE || ctx.Err() != nil || attempt >= ia.maxRetransmit {
+			return resMsg, err
+		}
+		if ia.retransmitBudget != nil && !ia.retransmitBudget.wait(ctx) {
+			log.WithField("token", ia.Token()).Warn("Retransmission dropped by budget")
+			return resMsg, err
+		}
+
+		log.WithField("token", ia.Token()).
+			WithField("attempt", attempt+1).
+			Debug("No response, retransmit CON request")
+		if err := ia.sendMessage(reqMsg); err != nil {
+			return nil, err
+		}
+		timeout *= 2
+	}
+}
+
+// ERR_INVALID_RESPONSE is the reason to drop a response that does not
+// match the request, e.g. because the frame was corrupted on the line
+var ERR_INVALID_RESPONSE = errors.New("coap: Response does not match the request")
+
+// readValidResponse reads the response to the CON request reqMsg.
+// Invalid responses are dropped like lost messages, so the
+// retransmission can recover from corrupted frames.
+func (ia *Interaction) readValidResponse(ctx context.Context, reqMsg *coapmsg.Message) (*coapmsg.Message, error) {
+	for {
+		resMsg, err := ia.readMessage(ctx)
+		if err != nil {
+			return resMsg, err
+		}
+		if err := ia.checkResponse(reqMsg, resMsg); err != nil {
+			msgLogEntry(resMsg).WithError(err).Warn("Dropped invalid response")
+			continue
+		}
+		return resMsg, nil
+	}
+}
+
+// checkResponse validates the message id of ACK and RST and the token of
+// responses against the request. ia.validateResponse can add checks like
+// a length or CRC of the device.
+func (ia *Interaction) checkResponse(reqMsg, resMsg *coapmsg.Message) error {
+	if (resMsg.Type == coapmsg.Acknowledgement || resMsg.Type == coapmsg.Reset) &&
+		resMsg.MessageID != reqMsg.MessageID {
+		return ERR_INVALID_RESPONSE
+	}
+	if resMsg.Code != coapmsg.Empty && !Token(resMsg.Token).Equals(reqMsg.Token) {
+		return ERR_INVALID_RESPONSE
+	}
+	if ia.validateResponse != nil {
+		return ia.validateResponse(reqMsg, resMsg)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+func TestRetransmitBudgetPacesRetransmissions(t *testing.T) {
+	budget := NewRetransmitBudget(10, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if !budget.wait(context.Background()) {
+			t.Fatal("Expected retransmission to be allowed")
+		}
+	}
+	// The first token is available immediately, the other 4 at 10/s
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Expected retransmissions to be paced but took only %s", elapsed)
+	}
+	if budget.Dropped() != 0 {
+		t.Errorf("Expected no dropped retransmissions but got %d", budget.Dropped())
+	}
+}
+
+func TestRetransmitBudgetDropsWhenSaturated(t *testing.T) {
+	budget := NewRetransmitBudget(1, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if !budget.wait(ctx) {
+		t.Fatal("Expected first retransmission to be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		if budget.wait(ctx) {
+			t.Error("Expected retransmission to be dropped")
+		}
+	}
+	if budget.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped retransmissions but got %d", budget.Dropped())
+	}
+}
+
+func TestRetransmitConRequest(t *testing.T) {
+	oldAckTimeout := AckTimeout
+	AckTimeout = 100 * time.Millisecond
+	defer func() { AckTimeout = oldAckTimeout }()
+
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	trans.MaxRetransmit = 2
+	trans.RetransmitBudget = NewRetransmitBudget(100, 1)
+
+	go func() {
+		// Ignore the first transmission
+		first, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		retransmit, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if retransmit.MessageID != first.MessageID {
+			t.Errorf("Expected retransmission with message id %d but got %d", first.MessageID, retransmit.MessageID)
+		}
+		ack := coapmsg.NewAck(retransmit.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = retransmit.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.Content.Number(), res.StatusCode)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+// runCorruptedResponse answers the first transmission with corrupt and
+// the retransmission with a valid piggybacked response
+func runCorruptedResponse(t *testing.T, trans *TransportUart, corrupt func(ack *coapmsg.Message)) {
+	oldAckTimeout := AckTimeout
+	AckTimeout = 100 * time.Millisecond
+	defer func() { AckTimeout = oldAckTimeout }()
+
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	trans.MaxRetransmit = 1
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			ack.Payload = []byte("valid")
+			if i == 0 {
+				corrupt(&ack)
+			}
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Buffer{}
+	body.ReadFrom(res.Body)
+	if body.String() != "valid" {
+		t.Errorf("Expected the retransmitted response 'valid' but got '%s'", body.String())
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestRetransmitOnCorruptedMessageId(t *testing.T) {
+	runCorruptedResponse(t, NewTransportUart(), func(ack *coapmsg.Message) {
+		// A bit flip in the message id still parses
+		ack.MessageID ^= 0x0100
+		ack.Payload = []byte("bogus")
+	})
+}
+
+func TestRetransmitOnFailedResponseValidation(t *testing.T) {
+	trans := NewTransportUart()
+	trans.ValidateResponse = func(req, res *coapmsg.Message) error {
+		if string(res.Payload) != "valid" {
+			return errors.New("payload check failed")
+		}
+		return nil
+	}
+	runCorruptedResponse(t, trans, func(ack *coapmsg.Message) {
+		// A short frame with a truncated payload
+		ack.Payload = []byte("val")
+	})
+}
+
+func TestAckTimeoutError(t *testing.T) {
+	oldAckTimeout := AckTimeout
+	AckTimeout = 100 * time.Millisecond
+	defer func() { AckTimeout = oldAckTimeout }()
+
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	trans.MaxRetransmit = 1
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = trans.RoundTrip(req)
+	if !errors.Is(err, ERR_ACK_TIMEOUT) {
+		t.Fatalf("Expected ERR_ACK_TIMEOUT but got %v", err)
+	}
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Expected a timeout error but got %v", err)
+	}
+
+	// The request and its retransmission are not answered
+	for i := 0; i < 2; i++ {
+		if _, err := testCon.ServerReceive(time.Second); err != nil {
+			t.Error(err)
+		}
+	}
+	ValidateCleanConnection(t, testCon)
+}
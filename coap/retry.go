@@ -0,0 +1,127 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// RetryPolicy decides if a failed idempotent request (GET, PUT, DELETE) is retried.
+//
+// ShouldRetry is called after every failed attempt, beginning with attempt 1.
+// A request failed when the RoundTripper returned a timeout error or the server
+// answered with 5.03 Service Unavailable, in which case resp is set.
+// Other errors, e.g. a Reset of the server, are never retried.
+// It returns if the request should be sent again and how long to wait before.
+type RetryPolicy interface {
+	ShouldRetry(attempt int, req *Request, resp *Response, err error) (bool, time.Duration)
+}
+
+// NoRetryPolicy never retries a request
+type NoRetryPolicy struct{}
+
+func (NoRetryPolicy) ShouldRetry(attempt int, req *Request, resp *Response, err error) (bool, time.Duration) {
+	return false, 0
+}
+
+// FixedRetryPolicy retries a request up to MaxRetries times
+// and waits Delay between the attempts or longer when the
+// server asks for it (see Response.RetryAfter)
+type FixedRetryPolicy struct {
+	MaxRetries int
+	Delay      time.Duration
+}
+
+func (p FixedRetryPolicy) ShouldRetry(attempt int, req *Request, resp *Response, err error) (bool, time.Duration) {
+	if attempt > p.MaxRetries {
+		return false, 0
+	}
+	return true, retryDelay(p.Delay, resp)
+}
+
+// ExponentialRetryPolicy retries a request up to MaxRetries times
+// and doubles the delay between the attempts starting with InitialDelay.
+// A longer delay requested by the server (see Response.RetryAfter) is honored.
+type ExponentialRetryPolicy struct {
+	MaxRetries   int
+	InitialDelay time.Duration
+}
+
+func (p ExponentialRetryPolicy) ShouldRetry(attempt int, req *Request, resp *Response, err error) (bool, time.Duration) {
+	if attempt > p.MaxRetries {
+		return false, 0
+	}
+	return true, retryDelay(p.InitialDelay*time.Duration(1<<uint(attempt-1)), resp)
+}
+
+// retryDelay returns the delay or the delay requested by the server if it is longer
+func retryDelay(delay time.Duration, resp *Response) time.Duration {
+	if resp != nil && resp.RetryAfter() > delay {
+		return resp.RetryAfter()
+	}
+	return delay
+}
+
+func isIdempotent(method string) bool {
+	return method == "GET" || method == "PUT" || method == "DELETE"
+}
+
+// isRetryable is true for timeouts and 5.03 Service Unavailable responses
+func isRetryable(res *Response, err error) bool {
+	if err != nil {
+		var timeout interface{ Timeout() bool }
+		return errors.As(err, &timeout) && timeout.Timeout()
+	}
+	return res != nil && res.StatusCode == coapmsg.ServiceUnavailable.Number()
+}
+
+// sendWithRetry sends the request and retries it as long as the policy allows.
+// The request body is buffered to be able to send it multiple times.
+// The delays are measured by the Clock of the transport.
+func (c *Client) sendWithRetry(req *Request, policy RetryPolicy) (*Response, error) {
+	clock := clockFor(c.transport(), req.URL.Scheme)
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.closeBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := new(Request)
+		*attemptReq = *req
+		attemptReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		res, err := c.sendInSlot(attemptReq)
+		if !isRetryable(res, err) {
+			return res, err
+		}
+
+		retry, delay := policy.ShouldRetry(attempt, req, res, err)
+		if !retry {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		log.WithError(err).
+			WithField("attempt", attempt).
+			WithField("delay", delay).
+			WithField("url", req.URL.String()).
+			Info("Retry request")
+
+		select {
+		case <-clock.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
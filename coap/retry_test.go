@@ -0,0 +1,197 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// failingTransport fails the first Failures requests with Err, ERR_ACK_TIMEOUT if nil
+type failingTransport struct {
+	Failures int
+	Err      error
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (t *failingTransport) RoundTrip(req *Request) (*Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	if t.requests <= t.Failures {
+		if t.Err != nil {
+			return nil, t.Err
+		}
+		return nil, wrapError(ERR_ACK_TIMEOUT, "Failed Interaction Roundtrip")
+	}
+	return &Response{
+		StatusCode: coapmsg.Content.Number(),
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte("ok"))),
+		Request:    req,
+	}, nil
+}
+
+func TestFixedRetryPolicySucceedsOnSecondAttempt(t *testing.T) {
+	tr := &failingTransport{Failures: 1}
+	client := &Client{Transport: tr, RetryPolicy: FixedRetryPolicy{MaxRetries: 3, Delay: 10 * time.Millisecond}}
+
+	res, err := client.Get("coap+uart://any/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.Content.Number(), res.StatusCode)
+	}
+	if tr.requests != 2 {
+		t.Errorf("Expected 2 requests but got %d", tr.requests)
+	}
+}
+
+func TestRetryIgnoresNonTimeoutErrors(t *testing.T) {
+	for _, failure := range []error{&ResetError{}, errors.New("coap: Connect failed")} {
+		tr := &failingTransport{Failures: 1, Err: failure}
+		client := &Client{Transport: tr, RetryPolicy: FixedRetryPolicy{MaxRetries: 3}}
+
+		if _, err := client.Get("coap+uart://any/foo"); err != failure {
+			t.Errorf("Expected %v but got %v", failure, err)
+		}
+		if tr.requests != 1 {
+			t.Errorf("Expected no retry after %v but got %d requests", failure, tr.requests)
+		}
+	}
+}
+
+func TestRetryDelayReleasesRequestSlot(t *testing.T) {
+	tr := &failingTransport{Failures: 1}
+	client := &Client{Transport: tr, MaxParallelRequests: 1, RetryPolicy: FixedRetryPolicy{MaxRetries: 1, Delay: time.Second}}
+
+	retried := make(chan error, 1)
+	go func() {
+		_, err := client.Get("coap+uart://any/foo")
+		retried <- err
+	}()
+	// Let the first attempt fail, the request waits for the retry now
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := client.Get("coap+uart://any/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected the slot to be free during the retry delay but waited %v", d)
+	}
+	if err := <-retried; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNoRetryByDefault(t *testing.T) {
+	tr := &failingTransport{Failures: 1}
+	client := &Client{Transport: tr}
+
+	_, err := client.Get("coap+uart://any/foo")
+	if err == nil {
+		t.Error("Expected error without retry policy")
+	}
+	if tr.requests != 1 {
+		t.Errorf("Expected 1 request but got %d", tr.requests)
+	}
+}
+
+func TestRetryPolicyIgnoresPost(t *testing.T) {
+	tr := &failingTransport{Failures: 1}
+	client := &Client{Transport: tr, RetryPolicy: FixedRetryPolicy{MaxRetries: 3}}
+
+	_, err := client.Post("coap+uart://any/foo", uint16(coapmsg.TextPlain), bytes.NewReader([]byte("body")))
+	if err == nil {
+		t.Error("Expected error since POST is not idempotent")
+	}
+	if tr.requests != 1 {
+		t.Errorf("Expected 1 request but got %d", tr.requests)
+	}
+}
+
+// unavailableTransport answers the first request with 5.03 and MaxAge
+type unavailableTransport struct {
+	MaxAge   int
+	clock    Clock
+	requests int
+}
+
+func (t *unavailableTransport) getClock() Clock {
+	return t.clock
+}
+
+func (t *unavailableTransport) RoundTrip(req *Request) (*Response, error) {
+	t.requests++
+	if t.requests == 1 {
+		opts := coapmsg.CoapOptions{}
+		opts.Set(coapmsg.MaxAge, t.MaxAge)
+		return &Response{
+			StatusCode: coapmsg.ServiceUnavailable.Number(),
+			Body:       ioutil.NopCloser(&bytes.Buffer{}),
+			Options:    opts,
+			Request:    req,
+		}, nil
+	}
+	return &Response{
+		StatusCode: coapmsg.Content.Number(),
+		Body:       ioutil.NopCloser(&bytes.Buffer{}),
+		Request:    req,
+	}, nil
+}
+
+func TestRetryHonorsServiceUnavailableMaxAge(t *testing.T) {
+	clock := newFakeClock()
+	tr := &unavailableTransport{MaxAge: 1, clock: clock}
+	client := &Client{Transport: tr, RetryPolicy: FixedRetryPolicy{MaxRetries: 1, Delay: 10 * time.Millisecond}}
+
+	type result struct {
+		res *Response
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := client.Get("coap+uart://any/foo")
+		done <- result{res, err}
+	}()
+
+	// The retry waits for Max-Age (1s) instead of Delay
+	clock.waitForTimers(t, 1)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Expected to wait Max-Age (1s) before retry")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.Content.Number(), r.res.StatusCode)
+	}
+}
+
+func TestResponseRetryAfter(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	opts.Set(coapmsg.MaxAge, 30)
+
+	res := &Response{StatusCode: coapmsg.ServiceUnavailable.Number(), Options: opts}
+	if res.RetryAfter() != 30*time.Second {
+		t.Errorf("Expected RetryAfter 30s but got %s", res.RetryAfter())
+	}
+
+	res = &Response{StatusCode: coapmsg.Content.Number(), Options: opts}
+	if res.RetryAfter() != 0 {
+		t.Errorf("Expected RetryAfter 0 for non 5.03 response but got %s", res.RetryAfter())
+	}
+}
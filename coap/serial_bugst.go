@@ -47,6 +47,7 @@ func getPortsList() ([]string, error) {
 	return serial.GetPortsList()
 }
 
+// serialOpen opens the serial port, it is the default serialOpenFunc
 func serialOpen(portName string, params UartParams) (SerialPort, error) {
 	return serial.Open(portName, params.toBugstSerialMode())
 }
@@ -9,12 +9,23 @@ import (
 
 var SlipMuxLogDiagnostic bool
 
+// SlipMuxDiagnosticHandler is the DiagnosticHandler of new SlipMuxReaders,
+// e.g. to surface the log lines of devices on serial connections
+var SlipMuxDiagnosticHandler func(message string)
+
 type SlipMuxReader struct {
 	r *slip.SlipMuxReader
+
+	// DiagnosticHandler is called with the trimmed text of every diagnostic
+	// frame before the next frame is read. nil only logs the diagnostic.
+	DiagnosticHandler func(message string)
 }
 
 func NewSlipMuxReader(reader io.Reader) *SlipMuxReader {
-	return &SlipMuxReader{r: slip.NewSlipMuxReader(reader)}
+	return &SlipMuxReader{
+		r:                 slip.NewSlipMuxReader(reader),
+		DiagnosticHandler: SlipMuxDiagnosticHandler,
+	}
 }
 
 type SlipMuxWriter struct {
@@ -30,8 +41,12 @@ func (r *SlipMuxReader) ReadPacket() ([]byte, bool, error) {
 		packet, frame, err := r.r.ReadPacket()
 
 		if frame == slip.FRAME_DIAGNOSTIC {
+			message := strings.TrimSpace(string(packet))
 			if SlipMuxLogDiagnostic {
-				log.WithField("message", strings.TrimSpace(string(packet))).Debug("SlipMux Diagnostic")
+				log.WithField("message", message).Debug("SlipMux Diagnostic")
+			}
+			if r.DiagnosticHandler != nil {
+				r.DiagnosticHandler(message)
 			}
 			continue
 		}
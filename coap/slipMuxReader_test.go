@@ -0,0 +1,62 @@
+package coap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lobaro/slip"
+)
+
+func TestSlipMuxReaderDiagnosticHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := slip.NewSlipMuxWriter(buf)
+	w.WritePacket(slip.FRAME_DIAGNOSTIC, []byte("booting\r\n"))
+	w.WritePacket(slip.FRAME_COAP, []byte{1, 2, 3})
+	w.WritePacket(slip.FRAME_DIAGNOSTIC, []byte(" sensor ready "))
+	w.WritePacket(slip.FRAME_DIAGNOSTIC, []byte("sending"))
+	w.WritePacket(slip.FRAME_COAP, []byte{4, 5})
+
+	r := NewSlipMuxReader(buf)
+	var diagnostics []string
+	r.DiagnosticHandler = func(message string) {
+		diagnostics = append(diagnostics, message)
+	}
+
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, []byte{1, 2, 3}) {
+		t.Errorf("Expected first CoAP packet but got %v", p)
+	}
+	if len(diagnostics) != 1 || diagnostics[0] != "booting" {
+		t.Errorf("Expected diagnostic before the first packet but got %q", diagnostics)
+	}
+
+	p, _, err = r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, []byte{4, 5}) {
+		t.Errorf("Expected second CoAP packet but got %v", p)
+	}
+	if len(diagnostics) != 3 || diagnostics[1] != "sensor ready" || diagnostics[2] != "sending" {
+		t.Errorf("Expected all diagnostics in order but got %q", diagnostics)
+	}
+}
+
+func TestSlipMuxReaderWithoutDiagnosticHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := slip.NewSlipMuxWriter(buf)
+	w.WritePacket(slip.FRAME_DIAGNOSTIC, []byte("ignored"))
+	w.WritePacket(slip.FRAME_COAP, []byte{1})
+
+	r := NewSlipMuxReader(buf)
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, []byte{1}) {
+		t.Errorf("Expected CoAP packet but got %v", p)
+	}
+}
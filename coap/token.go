@@ -1,6 +1,7 @@
 package coap
 
 import (
+	"errors"
 	"math/rand"
 	"sync"
 	"time"
@@ -10,7 +11,16 @@ type TokenGenerator interface {
 	NextToken() []byte
 }
 
+// DefaultTokenLength is the token length of the RandomTokenGenerator in bytes
+const DefaultTokenLength = 4
+
+var ERR_INVALID_TOKEN_LENGTH = errors.New("coap: Token length must be between 1 and 8 bytes")
+
 type RandomTokenGenerator struct {
+	// TokenLength in bytes, 0 uses DefaultTokenLength.
+	// Values above 8 (the TKL limit) are clamped.
+	TokenLength int
+
 	lastTokenSeq uint8      // Sequence counter
 	rand         *rand.Rand // Random source for token generation
 
@@ -19,8 +29,31 @@ type RandomTokenGenerator struct {
 
 func NewRandomTokenGenerator() TokenGenerator {
 	return &RandomTokenGenerator{
-		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		TokenLength: DefaultTokenLength,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NewRandomTokenGeneratorWithLength creates a generator for tokens of n bytes.
+// Shorter tokens save bandwidth, but the first byte is always a sequence counter.
+func NewRandomTokenGeneratorWithLength(n int) (TokenGenerator, error) {
+	if n < 1 || n > 8 {
+		return nil, ERR_INVALID_TOKEN_LENGTH
+	}
+	return &RandomTokenGenerator{
+		TokenLength: n,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+func (t *RandomTokenGenerator) tokenLength() int {
+	switch {
+	case t.TokenLength <= 0:
+		return DefaultTokenLength
+	case t.TokenLength > 8:
+		return 8
 	}
+	return t.TokenLength
 }
 
 func (t *RandomTokenGenerator) NextToken() []byte {
@@ -28,7 +61,7 @@ func (t *RandomTokenGenerator) NextToken() []byte {
 	// since we identify our interactions by the token
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	tok := make([]byte, 4)
+	tok := make([]byte, t.tokenLength())
 	t.rand.Read(tok)
 	t.lastTokenSeq++
 	tok[0] = t.lastTokenSeq
@@ -0,0 +1,33 @@
+package coap
+
+import "testing"
+
+func TestRandomTokenGeneratorLength(t *testing.T) {
+	for _, n := range []int{1, 2, 8} {
+		gen, err := NewRandomTokenGeneratorWithLength(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i <= 3; i++ {
+			tok := gen.NextToken()
+			if len(tok) != n {
+				t.Errorf("Expected token of %d bytes but got %v", n, tok)
+			}
+			if tok[0] != uint8(i) {
+				t.Errorf("Expected sequence byte %d but got %d", i, tok[0])
+			}
+		}
+	}
+
+	if tok := NewRandomTokenGenerator().NextToken(); len(tok) != DefaultTokenLength {
+		t.Errorf("Expected default token of %d bytes but got %v", DefaultTokenLength, tok)
+	}
+}
+
+func TestRandomTokenGeneratorRejectsInvalidLength(t *testing.T) {
+	for _, n := range []int{0, -1, 9} {
+		if _, err := NewRandomTokenGeneratorWithLength(n); err != ERR_INVALID_TOKEN_LENGTH {
+			t.Errorf("Expected %v for length %d but got %v", ERR_INVALID_TOKEN_LENGTH, n, err)
+		}
+	}
+}
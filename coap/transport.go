@@ -2,6 +2,7 @@ package coap
 
 import (
 	"errors"
+	"net/url"
 	"time"
 )
 
@@ -14,19 +15,85 @@ var AckTimeout = 10 * time.Second // Default 2 Seconds
 // on the request URL scheme
 type Transport struct {
 	TransUart RoundTripper
+	TransUdp  RoundTripper
+	TransDtls RoundTripper
 }
 
 func (t *Transport) RoundTrip(req *Request) (*Response, error) {
+	if req == nil || req.URL == nil {
+		return nil, errors.New("coap: Missing request URL")
+	}
 
-	if req.URL.Scheme == UartScheme {
-		return t.TransUart.RoundTrip(req)
+	trans, err := t.transportFor(req.URL.Scheme)
+	if err != nil {
+		return nil, err
 	}
+	return trans.RoundTrip(req)
+}
 
-	return nil, errors.New("Unsupported scheme: " + req.URL.Scheme)
+// transportFor returns the transport that handles the given URL scheme.
+// Unset transports result in a descriptive error instead of a nil pointer panic
+func (t *Transport) transportFor(scheme string) (RoundTripper, error) {
+	var trans RoundTripper
+	switch scheme {
+	case UartScheme:
+		trans = t.TransUart
+	case UdpScheme:
+		trans = t.TransUdp
+	case DtlsScheme:
+		trans = t.TransDtls
+	default:
+		return nil, errors.New("Unsupported scheme: " + scheme)
+	}
+
+	if trans == nil {
+		return nil, errors.New("coap: No transport configured for scheme " + scheme)
+	}
+	return trans, nil
+}
+
+var _ ObservationFinder = (*Transport)(nil)
+
+// ObservingTokens implements ObservationFinder with the transport of the URL scheme
+func (t *Transport) ObservingTokens(u *url.URL) []Token {
+	trans, err := t.transportFor(u.Scheme)
+	if err != nil {
+		return nil
+	}
+	finder, ok := trans.(ObservationFinder)
+	if !ok {
+		return nil
+	}
+	return finder.ObservingTokens(u)
+}
+
+// MulticastRoundTripper is optionally implemented by a RoundTripper that
+// can collect the responses of several servers to one request, see Client.GetMulticast
+type MulticastRoundTripper interface {
+	RoundTripMulticast(req *Request, window time.Duration) ([]*Response, error)
+}
+
+// multicastTransportFor returns the multicast transport used for the scheme
+func multicastTransportFor(rt RoundTripper, scheme string) (MulticastRoundTripper, error) {
+	if t, isTransport := rt.(*Transport); isTransport {
+		trans, err := t.transportFor(scheme)
+		if err != nil {
+			return nil, err
+		}
+		rt = trans
+	}
+
+	mrt, ok := rt.(MulticastRoundTripper)
+	if !ok {
+		return nil, errors.New("coap: multicast not supported by " + scheme)
+	}
+	return mrt, nil
 }
 
 var DefaultTransport RoundTripper = &Transport{
 	TransUart: NewTransportUart(),
+	TransUdp:  NewTransportUdp(),
+	TransDtls: NewTransportDtls(nil),
 }
 
 // For a new Confirmable message, the initial timeout is set
@@ -0,0 +1,150 @@
+package coap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+const DtlsScheme = "coaps"
+
+// DefaultDtlsPort is used when the URL host has no port
+const DefaultDtlsPort = "5684"
+
+// DefaultDtlsHandshakeTimeout limits the handshake when neither the
+// DtlsConfig nor the context of the request set a limit
+const DefaultDtlsHandshakeTimeout = 30 * time.Second
+
+// DtlsConfig holds the credentials of a DTLS session.
+// Set PSK and PSKIdentity for pre-shared keys or
+// Certificates and RootCAs for certificate based sessions.
+// PSK sessions use TLS_PSK_WITH_AES_128_CCM_8, the cipher suite of RFC 7252, 9.1.3.1.
+type DtlsConfig struct {
+	PSK         []byte
+	PSKIdentity []byte
+
+	Certificates       []tls.Certificate
+	RootCAs            *x509.CertPool
+	ServerName         string
+	InsecureSkipVerify bool
+
+	// HandshakeTimeout limits the handshake, DefaultDtlsHandshakeTimeout if 0.
+	// A deadline of the request context is used when it is earlier.
+	HandshakeTimeout time.Duration
+}
+
+// DtlsDialFunc opens a DTLS session over UDP to addr (host:port).
+// Each Read of the returned conn must return exactly one datagram
+// and each Write must send one, like the *dtls.Conn of DialDtls.
+type DtlsDialFunc func(ctx context.Context, addr string, config *DtlsConfig) (net.Conn, error)
+
+// DialDtls does the DTLS handshake with github.com/pion/dtls, it is the default DtlsDialFunc
+func DialDtls(ctx context.Context, addr string, config *DtlsConfig) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	ctx, cancel := context.WithTimeout(ctx, config.handshakeTimeout())
+	defer cancel()
+	return dtls.DialWithContext(ctx, "udp", raddr, config.dtlsConfig(host))
+}
+
+func (c *DtlsConfig) handshakeTimeout() time.Duration {
+	if c == nil || c.HandshakeTimeout <= 0 {
+		return DefaultDtlsHandshakeTimeout
+	}
+	return c.HandshakeTimeout
+}
+
+// dtlsConfig converts the config, host is used as ServerName when it is not set
+func (c *DtlsConfig) dtlsConfig(host string) *dtls.Config {
+	if c == nil {
+		c = &DtlsConfig{}
+	}
+	config := &dtls.Config{
+		Certificates:         c.Certificates,
+		RootCAs:              c.RootCAs,
+		ServerName:           c.ServerName,
+		InsecureSkipVerify:   c.InsecureSkipVerify,
+		ExtendedMasterSecret: dtls.RequestExtendedMasterSecret,
+	}
+	if config.ServerName == "" && net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	if c.PSK != nil {
+		psk := c.PSK
+		config.PSK = func(hint []byte) ([]byte, error) {
+			return psk, nil
+		}
+		config.PSKIdentityHint = c.PSKIdentity
+		config.CipherSuites = []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8}
+	}
+	return config
+}
+
+// TransportDtls sends requests over DTLS secured UDP.
+// The URI scheme must be coaps, valid URIs would be
+// coaps://example.com/sensors/temperature
+// coaps://192.168.0.10:5684/sensors/temperature
+//
+// The session is opened with the DtlsConfig of the DtlsConnector, a nil
+// config verifies the server certificate against the system roots.
+// Retransmission and all other settings work like for the TransportUart.
+type TransportDtls struct {
+	*TransportUart
+}
+
+func NewTransportDtls(config *DtlsConfig) *TransportDtls {
+	t := NewTransportUart()
+	t.scheme = DtlsScheme
+	t.Connecter = NewDtlsConnector(config)
+	return &TransportDtls{TransportUart: t}
+}
+
+var _ SerialConnecter = (*DtlsConnector)(nil)
+
+// DtlsConnector provides the DTLS connections of the TransportDtls
+type DtlsConnector struct {
+	udpConnections
+
+	Config *DtlsConfig
+	Dial   DtlsDialFunc // Opens the DTLS sessions, DialDtls if nil
+}
+
+func NewDtlsConnector(config *DtlsConfig) *DtlsConnector {
+	return &DtlsConnector{
+		Config: config,
+		Dial:   DialDtls,
+	}
+}
+
+func (c *DtlsConnector) findConnection(host string) Connection {
+	return c.find(hostAddr(host, DefaultDtlsPort))
+}
+
+func (c *DtlsConnector) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+// ConnectContext reuses the open connection to host
+// or does a new DTLS handshake
+func (c *DtlsConnector) ConnectContext(ctx context.Context, host string) (Connection, error) {
+	dial := c.Dial
+	if dial == nil {
+		dial = DialDtls
+	}
+	addr := hostAddr(host, DefaultDtlsPort)
+	return c.connect("udp", addr, func() (net.Conn, error) {
+		log.WithField("addr", addr).Info("Opening DTLS connection ...")
+		conn, err := dial(ctx, addr, c.Config)
+		if err != nil {
+			return nil, wrapError(err, "Failed to open DTLS connection to "+addr)
+		}
+		return conn, nil
+	})
+}
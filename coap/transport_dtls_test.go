@@ -0,0 +1,160 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/pion/dtls/v2"
+)
+
+// udpDialer dials plain UDP instead of DTLS and records the config
+type udpDialer struct {
+	addr   string
+	config *DtlsConfig
+}
+
+func (d *udpDialer) dial(ctx context.Context, addr string, config *DtlsConfig) (net.Conn, error) {
+	d.addr = addr
+	d.config = config
+	return net.Dial("udp", addr)
+}
+
+// serveDtls answers the first GET on a PSK secured DTLS listener and records the PSK identity of the client
+func serveDtls(t *testing.T, psk []byte) (addr string, identity <-chan string) {
+	identities := make(chan string, 1)
+	config := &dtls.Config{
+		PSK: func(hint []byte) ([]byte, error) {
+			identities <- string(hint)
+			return psk, nil
+		},
+		PSKIdentityHint: []byte("server"),
+		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+	}
+	listener, err := dtls.Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return // Handshake failed or listener closed
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msg, err := coapmsg.ParseMessage(buf[:n])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte(msg.Options().Get(coapmsg.URIPath).AsString())
+		if _, err := conn.Write(ack.MustMarshalBinary()); err != nil {
+			t.Error(err)
+		}
+	}()
+	return listener.Addr().String(), identities
+}
+
+func TestDtlsGetWithPSK(t *testing.T) {
+	addr, identity := serveDtls(t, []byte("secret"))
+
+	config := &DtlsConfig{PSKIdentity: []byte("client"), PSK: []byte("secret")}
+	client := NewClient()
+	client.Transport = &Transport{TransDtls: NewTransportDtls(config)}
+
+	res, err := client.Get("coaps://" + addr + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "foo" {
+		t.Errorf("Expected body foo but got %s", body)
+	}
+	if id := <-identity; id != "client" {
+		t.Errorf("Expected PSK identity client but got %s", id)
+	}
+}
+
+func TestDtlsWrongPSK(t *testing.T) {
+	addr, _ := serveDtls(t, []byte("secret"))
+
+	config := &DtlsConfig{PSKIdentity: []byte("client"), PSK: []byte("wrong"), HandshakeTimeout: 2 * time.Second}
+	client := NewClient()
+	client.Transport = &Transport{TransDtls: NewTransportDtls(config)}
+
+	if _, err := client.Get("coaps://" + addr + "/foo"); err == nil {
+		t.Error("Expected the handshake to fail with a wrong PSK")
+	}
+}
+
+func TestDtlsCustomDial(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msg, err := coapmsg.ParseMessage(buf[:n])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("bar")
+		if _, err := server.WriteTo(ack.MustMarshalBinary(), addr); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	dialer := &udpDialer{}
+	config := &DtlsConfig{PSKIdentity: []byte("client"), PSK: []byte("secret")}
+	trans := NewTransportDtls(config)
+	trans.Connecter.(*DtlsConnector).Dial = dialer.dial
+	client := NewClient()
+	client.Transport = &Transport{TransDtls: trans}
+
+	res, err := client.Get("coaps://" + server.LocalAddr().String() + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "bar" {
+		t.Errorf("Expected body bar but got %s", body)
+	}
+	if dialer.addr != server.LocalAddr().String() {
+		t.Errorf("Expected dial to %s but got %s", server.LocalAddr(), dialer.addr)
+	}
+	if dialer.config == nil || !bytes.Equal(dialer.config.PSKIdentity, []byte("client")) {
+		t.Errorf("Expected dial with the configured PSK identity but got %v", dialer.config)
+	}
+}
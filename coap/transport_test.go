@@ -0,0 +1,66 @@
+package coap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransportWithoutUartTransport(t *testing.T) {
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := (&Transport{}).RoundTrip(req)
+	if res != nil {
+		t.Error("Expected res to be nil but was", res)
+	}
+	if err == nil {
+		t.Fatal("Expected an error for missing uart transport")
+	}
+	if !strings.Contains(err.Error(), UartScheme) {
+		t.Errorf("Expected error to name the scheme %s but was: %s", UartScheme, err)
+	}
+}
+
+func TestUartCapabilities(t *testing.T) {
+	client := NewClient()
+	client.Transport = &Transport{TransUart: NewTransportUart()}
+
+	caps, ok := client.Capabilities(UartScheme)
+	if !ok {
+		t.Fatal("Expected uart transport to report capabilities")
+	}
+	if caps.Multicast {
+		t.Error("Expected uart transport to not support multicast")
+	}
+	if !caps.Observe {
+		t.Error("Expected uart transport to support observe")
+	}
+
+	if _, ok := client.Capabilities("coap"); ok {
+		t.Error("Expected no capabilities for unsupported scheme")
+	}
+}
+
+// noObserveTransport fails every request but reports that it does not support observe
+type noObserveTransport struct{}
+
+func (noObserveTransport) RoundTrip(req *Request) (*Response, error) {
+	return nil, errors.New("Unexpected round trip")
+}
+
+func (noObserveTransport) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func TestObserveWithoutCapability(t *testing.T) {
+	client := NewClient()
+	client.Transport = noObserveTransport{}
+
+	_, err := client.Observe("coap+uart://any/foo")
+	if err == nil || !strings.Contains(err.Error(), "observe not supported by coap+uart") {
+		t.Errorf("Expected observe not supported error but got: %v", err)
+	}
+}
@@ -5,13 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"net/url"
-
 	"context"
 
 	"github.com/emirpasic/gods/sets/hashset"
@@ -48,13 +49,84 @@ const UartScheme = "coap+uart"
 // the /dev/ part of the device file handle is added implicitly
 // https://tools.ietf.org/html/rfc3986#page-21 allows system specific Host lookups
 //
-// The URI host can be set to "any" to take the first open port found
+// The URI host can be set to "any" to take the first open port found,
+// see UartConnector.AnyStrategy to distribute requests over several ports
 type TransportUart struct {
 	mu        *sync.Mutex
 	lastMsgId uint16 // Sequence counter
+	scheme    string // URL scheme of the requests, UartScheme if empty
 
 	TokenGenerator TokenGenerator
 	Connecter      SerialConnecter
+
+	// AcceptNonResponseToCon accepts a NON response to a CON request
+	// instead of expecting an ACK. Some non conformant devices answer
+	// a CON request that way. Default is false (strict).
+	AcceptNonResponseToCon bool
+
+	// MaxRetransmit is the number of retransmissions of a CON request without
+	// response. Default is 0 (no retransmission), the RFC uses MAX_RETRANSMIT.
+	MaxRetransmit int
+
+	// RetransmitBudget optionally paces retransmissions of all exchanges
+	RetransmitBudget *RetransmitBudget
+
+	// ValidateResponse optionally checks the response to a CON request, e.g.
+	// against a length or CRC the device adds to the payload. Responses that
+	// fail, or that do not match the message id or token of the request, are
+	// dropped like lost messages and recovered by retransmission.
+	ValidateResponse func(req, res *coapmsg.Message) error
+
+	// ErrorResponsesAsError returns 4.xx and 5.xx responses as *ResponseError
+	// instead of a response with nil error. Default is false (like net/http).
+	ErrorResponsesAsError bool
+
+	// AckNotifyImmediately acknowledges CON notifications as soon as they are
+	// received instead of after the consumer took them. Default is true.
+	AckNotifyImmediately bool
+
+	// MaxBlockSize is the largest POST or PUT payload in bytes that is sent in
+	// a single message. Larger payloads are sent in Block1 blocks (RFC 7959)
+	// of the next smaller power of two between 16 and 1024. Default is 0 (disabled).
+	// A smaller max payload learned from the host is used instead, see MaxPayload.
+	MaxBlockSize int
+
+	// StreamBlockWiseResponses returns block-wise responses (Block2) after the
+	// first block. The Body requests each further block when the previous one
+	// is read, Close stops the transfer. Default is false, all blocks are
+	// received before RoundTrip returns.
+	StreamBlockWiseResponses bool
+
+	// Clock measures the timeouts of the interactions. nil uses the RealClock.
+	Clock Clock
+
+	// DeduplicationLifetime is how long received CON and NON messages are
+	// remembered to detect duplicates. Default is EXCHANGE_LIFETIME (247s).
+	DeduplicationLifetime time.Duration
+
+	// DeduplicationWindowSize is the maximal number of remembered messages
+	// per connection. Default is 64, 0 disables the deduplication.
+	DeduplicationWindowSize int
+
+	// NotificationConsumerTimeout is how long a notification waits for the
+	// consumer of Response.Next. Without consumer the notification is dropped
+	// and the observe stops to deliver notifications. Default is 5 seconds.
+	NotificationConsumerTimeout time.Duration
+
+	// OnNotificationDropped is optionally called with the token of an
+	// observe whose notification was dropped, see NotificationConsumerTimeout
+	OnNotificationDropped func(token []byte)
+
+	// KeepAliveInterval is the interval of CoAP pings on the connection of an
+	// observe until no interaction is left, e.g. to keep the NAT mapping of a
+	// UDP connection open for the notifications. Default is 0 (disabled),
+	// PingOpenConnectionsInterval still pings as long as the connection is open.
+	KeepAliveInterval time.Duration
+
+	droppedNotifications uint64 // accessed atomically
+
+	payloadMu  sync.Mutex     // Guards maxPayload
+	maxPayload map[string]int // Learned max payload per host, see MaxPayload
 }
 
 func NewTransportUart() *TransportUart {
@@ -62,13 +134,58 @@ func NewTransportUart() *TransportUart {
 		mu:             &sync.Mutex{},
 		TokenGenerator: NewRandomTokenGenerator(),
 		Connecter:      NewUartConnecter(),
+
+		AckNotifyImmediately:        true,
+		DeduplicationLifetime:       exchangeLifetime,
+		DeduplicationWindowSize:     64,
+		NotificationConsumerTimeout: 5 * time.Second,
 	}
 
 }
 
+// LogPayloadLimit limits the number of payload bytes written to the log.
+// Longer payloads are truncated. Set to 0 to log the full payload.
+var LogPayloadLimit = 0
+
+// RedactPayload can be set to true to omit payloads from the log
+var RedactPayload = false
+
+// logPayload returns the part of the payload that should be logged
+func logPayload(payload []byte) (logged []byte, truncated bool) {
+	limit := len(payload)
+	if RedactPayload {
+		limit = 0
+	} else if LogPayloadLimit > 0 && LogPayloadLimit < limit {
+		limit = LogPayloadLimit
+	}
+	if limit == len(payload) {
+		return payload, false
+	}
+	return payload[:limit], true
+}
+
+// Capabilities implements CapabilityReporter
+func (t *TransportUart) Capabilities() Capabilities {
+	return Capabilities{
+		Observe:   true,
+		BlockWise: true,
+		Multicast: false,
+		Reliable:  false,
+	}
+}
+
 func msgLogEntry(msg *coapmsg.Message) *logrus.Entry {
 	bin := msg.MustMarshalBinary()
 
+	payload, truncated := logPayload(msg.Payload)
+	if truncated {
+		marker := fmt.Sprintf("...(%d bytes)", len(msg.Payload))
+		logged := *msg
+		logged.Payload = append(payload[:len(payload):len(payload)], marker...)
+		bin = bin[:len(bin)-len(msg.Payload)+len(payload)]
+		msg = &logged
+	}
+
 	options := logrus.Fields{}
 	for id, o := range msg.Options() {
 		options["Opt:"+id.String()] = o.String()
@@ -90,41 +207,29 @@ func msgLogEntry(msg *coapmsg.Message) *logrus.Entry {
 }
 
 func logMsg(msg *coapmsg.Message, info string) {
-	msgLogEntry(msg).Debug("CoAP message: " + info)
+	logMsgWithFields(msg, info, nil)
 }
 
-// Ping sends a CoAP ping
-func (t *TransportUart) ping(host string) (ok bool, err error) {
-	ping := coapmsg.NewPing(t.nextMessageId())
-
-	u, err := url.Parse(host)
-	if err != nil {
-		return
-	}
+func logMsgWithFields(msg *coapmsg.Message, info string, fields logrus.Fields) {
+	msgLogEntry(msg).WithFields(fields).Debug("CoAP message: " + info)
+}
 
-	conn, err := t.Connecter.Connect(u.Host)
+// Dial opens the connection to host, or reuses an already open one,
+// and checks it with a CoAP ping. On success the connection is kept
+// open and returned, e.g. to verify a device is reachable on startup.
+func (t *TransportUart) Dial(ctx context.Context, host string) (Connection, error) {
+	conn, err := t.Connecter.ConnectContext(ctx, host)
 	if err != nil {
-		return
+		return nil, wrapError(err, "Failed to connect to "+host)
 	}
 
-	ia := conn.StartInteraction(conn, &ping)
-	defer ia.Close()
-
-	ctxWithTimeout, _ := context.WithTimeout(context.Background(), 3*time.Second)
-
-	res, err := ia.RoundTrip(ctxWithTimeout, &ping)
-
-	if res != nil && res.Type == coapmsg.Reset {
-		// We expect this error
-		return true, nil
-	} else {
-		resTypeStr := "nil"
-		if res != nil {
-			resTypeStr = res.Type.String()
+	if _, err := conn.Ping(ctx); err != nil {
+		if conn.InteractionCount() == 0 {
+			conn.Close()
 		}
-		return false, errors.New(fmt.Sprintf("Expected RST but got %s. Error: %s", resTypeStr, err))
+		return nil, wrapError(err, "Ping to "+host+" failed")
 	}
-
+	return conn, nil
 }
 
 // RoundTrip takes care about one Request / Response roundtrip
@@ -150,6 +255,10 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 		return
 	}
 
+	if t.needsBlock1(req, reqMsg) {
+		return t.uploadBlocks(req, reqMsg.Payload)
+	}
+
 	//###########################################
 	// Open / Reuse the connection
 	//###########################################
@@ -157,14 +266,24 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 	if req.URL == nil {
 		return nil, errors.New(fmt.Sprint("coap: Missing request URL"))
 	}
-	if req.URL.Scheme != UartScheme {
-		return nil, errors.New(fmt.Sprint("coap: Invalid URL scheme, expected "+UartScheme+" but got: ", req.URL.Scheme))
+	if scheme := t.urlScheme(); req.URL.Scheme != scheme {
+		return nil, errors.New(fmt.Sprint("coap: Invalid URL scheme, expected "+scheme+" but got: ", req.URL.Scheme))
 	}
 
-	conn, err := t.Connecter.Connect(req.URL.Host)
+	conn, err := t.Connecter.ConnectContext(req.Context(), req.URL.Host)
 	if err != nil {
 		return
 	}
+	if dc, ok := conn.(deduplicatingConnection); ok {
+		dc.receivedMessages().configure(t.DeduplicationLifetime, t.DeduplicationWindowSize, t.Clock)
+	}
+
+	// A serial port might be reopened right now, wait instead of failing
+	if serialCon, ok := conn.(*serialConnection); ok {
+		if err := serialCon.waitReady(req.Context()); err != nil {
+			return nil, wrapError(err, "Serial connection not ready")
+		}
+	}
 
 	//###########################################
 	// Start an interaction and send the request
@@ -187,43 +306,153 @@ func (t *TransportUart) RoundTrip(req *Request) (res *Response, err error) {
 	if ia == nil {
 		ia = conn.StartInteraction(conn, reqMsg)
 	}
+	ia.acceptNonResponseToCon = t.AcceptNonResponseToCon
+	ia.maxRetransmit = t.MaxRetransmit
+	ia.retransmitBudget = t.RetransmitBudget
+	ia.validateResponse = t.ValidateResponse
+	ia.ackNotifyImmediately = t.AckNotifyImmediately
+	ia.clock = t.Clock
+	ia.metadata = metadataFromContext(req.Context())
 
 	resMsg, err := ia.RoundTrip(req.Context(), reqMsg)
 
+	if err == ERR_INTERACTION_CLOSED {
+		return nil, err
+	}
+	if err == ERR_CONNECTION_CLOSED || err == ERR_POSTPONED_RESPONSE_TIMEOUT {
+		ia.Close()
+		return nil, err
+	}
+	if _, ok := err.(*ResetError); ok {
+		ia.Close()
+		return nil, err
+	}
 	if err != nil {
 		ia.Close()
 		return nil, wrapError(err, fmt.Sprint("Failed Interaction Roundtrip with Token ", ia.Token()))
 	}
 
+	t.learnMaxPayload(req, resMsg)
+
+	// Fetch the remaining blocks of a block-wise response
+	var blocks *blockReader
+	if !ia.IsObserving() && hasMoreBlocks(req, resMsg) {
+		// The follow-up requests use the same token
+		ia.Close()
+		if t.StreamBlockWiseResponses {
+			blocks, err = t.newBlockReader(req, resMsg)
+		} else {
+			resMsg.Payload, err = t.fetchBlocks(req, resMsg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resMsg.Options().Del(coapmsg.Block2)
+	}
+
 	//###########################################
 	// Build and return the response
 	//###########################################
 
 	res = buildResponse(req, resMsg)
+	if blocks != nil {
+		res.Body = blocks
+	}
 
 	// An observe request must set the observe option to 0
 	// the server has to response with the observe option set to != 0
 	if ia.IsObserving() {
 		// Must create chan before returning
-		res.next = make(chan *Response, 0)
-		go handleInteractionNotifyMessage(ia, req, res)
-
-		if PingOpenConnectionsInterval.Nanoseconds() > 0 {
-			go t.pingLoop(ia.conn, req.URL.Scheme+"://"+req.URL.Host)
+		res.next = make(chan *Response, notificationBufferSize(req))
+		res.observe = &observeState{}
+		go t.handleInteractionNotifyMessage(ia, req, res, ia.getClock())
+
+		if t.KeepAliveInterval > 0 {
+			go t.pingLoop(ia.conn, req.URL.Scheme+"://"+req.URL.Host, t.KeepAliveInterval, true)
+		} else if PingOpenConnectionsInterval.Nanoseconds() > 0 {
+			go t.pingLoop(ia.conn, req.URL.Scheme+"://"+req.URL.Host, PingOpenConnectionsInterval, false)
 		}
-	} else {
+	} else if !ia.Closed() {
 		ia.Close()
 	}
 
+	if t.ErrorResponsesAsError && resMsg.Code.Class() >= 4 {
+		return nil, &ResponseError{Response: res}
+	}
+
 	return res, nil
 }
 
+func (t *TransportUart) urlScheme() string {
+	if t.scheme == "" {
+		return UartScheme
+	}
+	return t.scheme
+}
+
+// getClock returns the Clock or the RealClock if unset
+func (t *TransportUart) getClock() Clock {
+	if t.Clock == nil {
+		return RealClock{}
+	}
+	return t.Clock
+}
+
+// FindInteraction returns the running interaction for the token on an
+// already open connection to host, e.g. to inspect the raw messages of an observe.
+// Returns nil if there is no such interaction.
+func (t *TransportUart) FindInteraction(host string, token Token) *Interaction {
+	finder, ok := t.Connecter.(connectionFinder)
+	if !ok {
+		return nil
+	}
+	conn := finder.findConnection(host)
+	if conn == nil {
+		return nil
+	}
+	return conn.FindInteraction(token, MessageId(0))
+}
+
+// ObservingTokens implements ObservationFinder
+func (t *TransportUart) ObservingTokens(u *url.URL) []Token {
+	finder, ok := t.Connecter.(connectionFinder)
+	if !ok {
+		return nil
+	}
+	conn := finder.findConnection(u.Host)
+	if conn == nil {
+		return nil
+	}
+	tokens := make([]Token, 0)
+	for _, ia := range conn.ObservingInteractions(strings.Trim(u.Path, "/")) {
+		tokens = append(tokens, ia.Token())
+	}
+	return tokens
+}
+
+// SerialParams returns the port and serial parameters of the open connection
+// to host, e.g. to show the port and settings in use after connecting to "any".
+// ok is false if there is no open serial connection to host.
+func (t *TransportUart) SerialParams(host string) (params SerialConnectionParams, ok bool) {
+	finder, isFinder := t.Connecter.(connectionFinder)
+	if !isFinder {
+		return
+	}
+	conn := finder.findConnection(host)
+	if conn == nil {
+		return
+	}
+	return conn.SerialParams()
+}
+
 var PingOpenConnectionsInterval = 0 * time.Second
 
 var pingConnections = hashset.New()
 var pingMu = sync.Mutex{} // Guards pingConnections
 
-func (t *TransportUart) pingLoop(conn Connection, host string) {
+// pingLoop pings conn every interval until it is closed. With untilIdle
+// it also stops when no interaction is left on conn.
+func (t *TransportUart) pingLoop(conn Connection, host string, interval time.Duration, untilIdle bool) {
 	pingMu.Lock()
 	if pingConnections.Contains(conn) {
 		pingMu.Unlock()
@@ -245,9 +474,15 @@ func (t *TransportUart) pingLoop(conn Connection, host string) {
 			log.WithField("host", host).Debug("Stop pinging, connection closed!")
 			return
 		}
-		<-time.After(PingOpenConnectionsInterval)
+		<-time.After(interval)
+		if untilIdle && conn.InteractionCount() == 0 {
+			log.WithField("host", host).Debug("Stop pinging, no interaction left")
+			return
+		}
 		log.WithField("host", host).Info("Ping")
-		ok, err := t.ping(host)
+		ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		ok, err := conn.Ping(ctxWithTimeout)
+		cancel()
 		if !ok {
 			log.WithError(err).WithField("host", host).Error("Ping failed")
 		} else {
@@ -256,9 +491,50 @@ func (t *TransportUart) pingLoop(conn Connection, host string) {
 	}
 }
 
+// ERR_OBSERVE_STALE ends an observe when no notification arrived within the
+// Max-Age of the last one, see Response.ObserveErr
+var ERR_OBSERVE_STALE = errors.New("coap: Observe is stale, no notification within Max-Age")
+
+// ObserveMaxAgeGrace is added to the Max-Age of a notification
+// before the observe is considered stale
+var ObserveMaxAgeGrace = 5 * time.Second
+
+// maxAge returns the Max-Age option, or its default of 60 seconds when not set
+func maxAge(opts coapmsg.CoapOptions) time.Duration {
+	opt := opts.Get(coapmsg.MaxAge)
+	if !opt.IsSet() {
+		defaults := coapmsg.CoapOptions{}
+		defaults.Set(coapmsg.MaxAge, coapmsg.MaxAge.DefaultValue())
+		opt = defaults.Get(coapmsg.MaxAge)
+	}
+	return time.Duration(opt.AsUInt32()) * time.Second
+}
+
+// notificationBufferSize returns the capacity of res.next, negative sizes are unbuffered
+func notificationBufferSize(req *Request) int {
+	if req.NotificationBufferSize < 0 {
+		return 0
+	}
+	return req.NotificationBufferSize
+}
+
+// DroppedNotifications returns the number of notifications dropped
+// due to slow consumers, see NotificationConsumerTimeout
+func (t *TransportUart) DroppedNotifications() uint64 {
+	return atomic.LoadUint64(&t.droppedNotifications)
+}
+
+// notificationDropped counts the dropped notification and calls OnNotificationDropped
+func (t *TransportUart) notificationDropped(token Token) {
+	atomic.AddUint64(&t.droppedNotifications, 1)
+	if t.OnNotificationDropped != nil {
+		t.OnNotificationDropped(token)
+	}
+}
+
 // Takes responsibility to close ia
 // res.next will be used to send responses to the client
-func handleInteractionNotifyMessage(ia *Interaction, initialReq *Request, initialRes *Response) {
+func (t *TransportUart) handleInteractionNotifyMessage(ia *Interaction, initialReq *Request, initialRes *Response, clock Clock) {
 	defer func() {
 		//log.Debug("Closing Next chan")
 		close(initialRes.next)
@@ -268,26 +544,43 @@ func handleInteractionNotifyMessage(ia *Interaction, initialReq *Request, initia
 	// a potential ACK on the cancel observe request can not be received anymore
 	defer time.AfterFunc(3*time.Second, func() {
 		// We would expect that everything went good and the ia is already closed
-		// but if not help a bit
-		if !ia.Closed() {
+		// but if not help a bit. A running cancel request closes it when done.
+		if !ia.Closed() && !ia.isCanceling() {
 			ia.Close()
 		}
 	})
 
-	// TODO: There is no timeout for the NotificationCh
-	// this puts all responsibility to stop the observe to the client
-	// we should consider some big default timeout (e.g. 5 minutes) to close the interaction
-	// when nothing is received
+	// A notification is fresh for its Max-Age, without a new one in
+	// time the observe is considered stale and the interaction closed
+	fresh := clock.NewTimer(maxAge(initialRes.Options) + ObserveMaxAgeGrace)
+	defer func() { fresh.Stop() }()
+
 	for {
 		// Block till receive or chan is closed, panic if chan is nil
-		resMsg, ok := <-ia.NotificationCh
+		var resMsg *coapmsg.Message
+		var ok bool
+		select {
+		case resMsg, ok = <-ia.NotificationCh:
+		case <-fresh.C():
+			log.WithField("Token", ia.Token()).Warn("No notification within Max-Age, closing stale observe.")
+			initialRes.observe.setErr(ERR_OBSERVE_STALE)
+			if !ia.Closed() {
+				ia.Close()
+			}
+			return
+		}
 		if ok {
+			fresh.Stop()
+			fresh = clock.NewTimer(maxAge(resMsg.Options()) + ObserveMaxAgeGrace)
+
 			res := buildResponse(initialReq, resMsg)
 			res.next = initialRes.next
+			res.observe = initialRes.observe
 			select {
-			case initialRes.next <- res: // MUST NOT be buffered, else we can't detect a not listening client
-			case <-time.After(5 * time.Second): // Give some time for the client to handle res.Next()
+			case initialRes.next <- res: // Blocks when the buffer is full, to detect a not listening client
+			case <-clock.After(t.NotificationConsumerTimeout): // Give some time for the client to handle res.Next()
 				log.WithField("Token", ia.Token()).Warn("No app handler for notification response registered. Stop listen for notifications.")
+				t.notificationDropped(ia.Token())
 				return
 			}
 		} else {
@@ -298,14 +591,13 @@ func handleInteractionNotifyMessage(ia *Interaction, initialReq *Request, initia
 	}
 }
 
+// buildResponse creates the response from a copy of resMsg, so consumers
+// can read the options while the transport handles the next message
 func buildResponse(req *Request, resMsg *coapmsg.Message) *Response {
-	return &Response{
-		StatusCode: resMsg.Code.Number(),
-		Status:     fmt.Sprintf("%d.%02d %s", resMsg.Code.Class(), resMsg.Code.Detail(), resMsg.Code.String()),
-		Body:       ioutil.NopCloser(bytes.NewReader(resMsg.Payload)),
-		Options:    resMsg.Options(),
-		Request:    req,
-	}
+	msg := resMsg.Clone()
+	res := NewResponse(msg.Code, msg.Payload, msg.Options())
+	res.Request = req
+	return res
 }
 
 // BuildMessage creates a coap message based on the request
@@ -330,11 +622,22 @@ func (t *TransportUart) buildRequestMessage(req *Request) (*coapmsg.Message, err
 		Token:     req.Token,
 	}
 	msg.SetOptions(req.Options)
-	if req.URL != nil {
+	if req.IfMatchAny {
+		msg.Options().SetIfMatchAny()
+	}
+	if req.ContentFormat != nil {
+		msg.Options().Set(coapmsg.ContentFormat, *req.ContentFormat)
+	}
+	// With Proxy-Uri the request URI is not sent in Uri-* options
+	if req.URL != nil && msg.Options().Get(coapmsg.ProxyURI).IsNotSet() {
 		path := req.URL.EscapedPath()
 		if len(path) > 0 {
 			msg.SetPathString(path)
 		}
+		// The host of a UART URL is a device name
+		if t.urlScheme() != UartScheme {
+			setUriHostAndPort(msg, req.URL)
+		}
 
 		msg.Options().Del(coapmsg.URIQuery)
 		for _, q := range strings.Split(req.URL.RawQuery, "&") {
@@ -366,6 +669,23 @@ func (t *TransportUart) buildRequestMessage(req *Request) (*coapmsg.Message, err
 	return msg, nil
 }
 
+// setUriHostAndPort adds the Uri-Host option for host names and Uri-Port for non default ports,
+// e.g. for a forward-proxy. IP literals and default ports are implied by the destination (RFC 7252, 6.4).
+// Options set by the request are kept.
+func setUriHostAndPort(msg *coapmsg.Message, u *url.URL) {
+	host := u.Hostname()
+	if host != "" && net.ParseIP(host) == nil && msg.Options().Get(coapmsg.URIHost).IsNotSet() {
+		msg.Options().Set(coapmsg.URIHost, host)
+	}
+	port := u.Port()
+	if port == "" || port == portMap[u.Scheme] || msg.Options().Get(coapmsg.URIPort).IsSet() {
+		return
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+		msg.Options().Set(coapmsg.URIPort, uint16(p))
+	}
+}
+
 func (t *TransportUart) nextMessageId() uint16 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
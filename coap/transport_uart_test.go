@@ -3,7 +3,13 @@ package coap
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -743,3 +749,1787 @@ func TestClientCancelObserve(t *testing.T) {
 
 	ValidateCleanConnection(t, testCon)
 }
+
+func runNonResponseToCon(t *testing.T, accept bool) (*Response, error) {
+	trans := NewTransportUart()
+	trans.AcceptNonResponseToCon = accept
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 1000
+		res.Token = msg.Token
+		res.Payload = []byte("non")
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 3*time.Second)
+	defer cancel()
+	res, err := trans.RoundTrip(req.WithContext(ctx))
+	ValidateCleanConnection(t, testCon)
+	return res, err
+}
+
+func TestNonResponseToConStrict(t *testing.T) {
+	_, err := runNonResponseToCon(t, false)
+	if !errors.Is(err, ERR_UNEXPECTED_TYPE) {
+		t.Errorf("Expected NON response to CON request to fail with ERR_UNEXPECTED_TYPE in strict mode but got %v", err)
+	}
+}
+
+func TestNonResponseToConAccepted(t *testing.T) {
+	res, err := runNonResponseToCon(t, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Buffer{}
+	body.ReadFrom(res.Body)
+	if body.String() != "non" {
+		t.Errorf("Expected body 'non' but got '%s'", body.String())
+	}
+}
+
+func TestNonRequestResponseMatchedByToken(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.NonConfirmable {
+			t.Errorf("Expected NON request but got %s", msg.Type)
+		}
+		// The server uses its own message id, only the token matches
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = msg.MessageID + 1
+		res.Token = msg.Token
+		res.Payload = []byte("non")
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Confirmable = false
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "non" {
+		t.Errorf("Expected body 'non' but got '%s'", body)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestNonRequestWithNoResponse(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	req, err := NewRequest("POST", "coap+uart://any/telemetry", strings.NewReader("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Confirmable = false
+	req.SuppressResponse(coapmsg.NoResponseAll)
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without No-Response the NON request would wait for the ACK timeout
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected to return without waiting for a response but took %s", d)
+	}
+	if res.StatusCode != coapmsg.Empty.Number() {
+		t.Errorf("Expected empty response but got %s", res.Status)
+	}
+
+	msg, err := testCon.ServerReceive(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt := msg.Options().Get(coapmsg.NoResponse); opt.AsUInt8() != 26 {
+		t.Errorf("Expected No-Response option 26 but got %v", opt)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestMsgLogEntryTruncatesPayload(t *testing.T) {
+	defer func(limit int) { LogPayloadLimit = limit }(LogPayloadLimit)
+	LogPayloadLimit = 4
+
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.Confirmable
+	msg.Code = coapmsg.Content
+	msg.Payload = bytes.Repeat([]byte("x"), 1000)
+
+	entry := msgLogEntry(&msg)
+	str := entry.Data["msg"].(string)
+	if !strings.Contains(str, "Payload:xxxx...(1000 bytes)}") {
+		t.Errorf("Expected truncated payload in log but got: %s", str)
+	}
+	bin := entry.Data["Bin"].([]byte)
+	if len(bin) != len(msg.MustMarshalBinary())-996 {
+		t.Errorf("Expected truncated binary in log but got %d bytes", len(bin))
+	}
+	if len(msg.Payload) != 1000 {
+		t.Errorf("Logging must not modify the message payload")
+	}
+}
+
+func TestMsgLogEntryRedactsPayload(t *testing.T) {
+	defer func(redact bool) { RedactPayload = redact }(RedactPayload)
+	RedactPayload = true
+
+	msg := coapmsg.NewMessage()
+	msg.Type = coapmsg.Confirmable
+	msg.Code = coapmsg.Content
+	msg.Payload = []byte("secret")
+
+	str := msgLogEntry(&msg).Data["msg"].(string)
+	if strings.Contains(str, "secret") {
+		t.Errorf("Expected redacted payload in log but got: %s", str)
+	}
+	if !strings.Contains(str, "Payload:...(6 bytes)}") {
+		t.Errorf("Expected payload size marker in log but got: %s", str)
+	}
+}
+
+func TestTransportUartDial(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	go func() {
+		ping, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rst := coapmsg.NewRst(ping.MessageID)
+		if err := testCon.ServerSend(rst); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := trans.Dial(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Closed() {
+		t.Error("Expected dialed connection to stay open")
+	}
+	if conn.InteractionCount() != 0 {
+		t.Errorf("Expected no interactions left but got %d", conn.InteractionCount())
+	}
+	conn.Close()
+}
+
+func TestTransportUartDialWithoutPingResponse(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	conn, err := trans.Dial(ctx, "any")
+	if err == nil {
+		t.Fatal("Expected Dial to fail without ping response")
+	}
+	if conn != nil {
+		t.Error("Expected no connection on failed Dial")
+	}
+	if _, err := testCon.ServerReceive(time.Second); err != nil {
+		t.Errorf("Expected unanswered ping but got: %s", err)
+	}
+	if !testCon.conn.Closed() {
+		t.Error("Expected idle connection to be closed after failed Dial")
+	}
+}
+
+func TestBuildRequestMessageProxyURI(t *testing.T) {
+	trans := NewTransportUart()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo?a=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Options.Set(coapmsg.URIPath, "bar")
+	if err := req.SetProxyURI("http://example.com/sensors"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := coapmsg.ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri := parsed.Options().Get(coapmsg.ProxyURI).AsString(); uri != "http://example.com/sensors" {
+		t.Errorf("Expected Proxy-Uri but got %q", uri)
+	}
+	for _, id := range []coapmsg.OptionId{coapmsg.URIPath, coapmsg.URIQuery} {
+		if opt := parsed.Options().Get(id); opt.IsSet() {
+			t.Errorf("Expected no %s option but got %v", id, opt)
+		}
+	}
+
+	if err := req.SetProxyURI(""); err != ERR_INVALID_OPTION_LENGTH {
+		t.Errorf("Expected ERR_INVALID_OPTION_LENGTH for empty Proxy-Uri but got %v", err)
+	}
+	if err := req.SetProxyURI(strings.Repeat("a", 1035)); err != ERR_INVALID_OPTION_LENGTH {
+		t.Errorf("Expected ERR_INVALID_OPTION_LENGTH for too long Proxy-Uri but got %v", err)
+	}
+}
+
+func TestBuildRequestMessageProxyScheme(t *testing.T) {
+	trans := NewTransportUart()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.SetProxyScheme("http"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The proxy builds the URI from the scheme and the Uri-* options
+	if scheme := msg.Options().Get(coapmsg.ProxyScheme).AsString(); scheme != "http" {
+		t.Errorf("Expected Proxy-Scheme http but got %q", scheme)
+	}
+	if path := msg.PathString(); path != "foo" {
+		t.Errorf("Expected Uri-Path foo but got %q", path)
+	}
+}
+
+func TestBuildRequestMessageIfMatchAny(t *testing.T) {
+	trans := NewTransportUart()
+
+	req, err := NewRequest("PUT", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.IfMatchAny = true
+	msg, err := trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Options().IfMatchAny() {
+		t.Error("Expected If-Match match-any in message")
+	}
+
+	req, err = NewRequest("PUT", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Options.Add(coapmsg.IfMatch, []byte{1, 2})
+	msg, err = trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Options().IfMatchAny() {
+		t.Error("Expected no If-Match match-any in message")
+	}
+	if len(msg.Options().IfMatchETags()) != 1 {
+		t.Errorf("Expected one If-Match ETag but got %v", msg.Options().IfMatchETags())
+	}
+}
+
+func TestClientCancelObserveNonConfirmable(t *testing.T) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = trans
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Wait for Cancel observe
+		msg, err = testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.NonConfirmable {
+			t.Errorf("Expected NON cancel observe but got %s", msg.Type)
+		}
+		if msg.Options().Get(coapmsg.Observe).AsUInt8() != 1 {
+			t.Error("Expected cancel observe (=1) option")
+		}
+
+		// Answer with a NON response, there is no ACK for NON requests
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 2000
+		res.Token = msg.Token
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	cancelRes, err := client.CancelObserveConfirmable(res, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelRes.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected 2.05 Content but got %s", cancelRes.Status)
+	}
+	if time.Since(start) >= ackTimeout() {
+		t.Error("NON cancel observe must not wait for an ACK")
+	}
+
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestBuildRequestMessageContentFormat(t *testing.T) {
+	trans := NewTransportUart()
+
+	req, err := NewRequest("PUT", "coap+uart://any/foo", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentFormat = coapmsg.AppJSON.Ptr()
+	msg, err := trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentFormat := msg.Options().Get(coapmsg.ContentFormat)
+	if contentFormat.IsNotSet() || contentFormat.AsUInt8() != uint8(coapmsg.AppJSON) {
+		t.Errorf("Expected Content-Format %d but got %s", coapmsg.AppJSON, contentFormat)
+	}
+
+	req, err = NewRequest("PUT", "coap+uart://any/foo", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Options().Get(coapmsg.ContentFormat).IsSet() {
+		t.Error("Expected no Content-Format when unset")
+	}
+
+	// Requests that are not built with NewRequest
+	u, err := url.Parse("coap+uart://any/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = &Request{Method: "PUT", URL: u, Body: ioutil.NopCloser(strings.NewReader("{}"))}
+	msg, err = trans.buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Options().Get(coapmsg.ContentFormat).IsSet() {
+		t.Error("Expected no Content-Format for the zero value")
+	}
+}
+
+// runSeparateErrorResponse answers a GET with an empty ACK followed by a separate 5.00 response
+func runSeparateErrorResponse(t *testing.T, asError bool) (*Response, error) {
+	trans := NewTransportUart()
+	trans.ErrorResponsesAsError = asError
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Empty
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.Confirmable
+		res.Code = coapmsg.InternalServerError
+		res.MessageID = 1000
+		res.Token = msg.Token
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.Acknowledgement {
+			t.Errorf("Expected Acknowledgement but got %s", msg.Type.String())
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 3*time.Second)
+	defer cancel()
+	res, err := trans.RoundTrip(req.WithContext(ctx))
+	<-done // Let the server receive the ACK
+	ValidateCleanConnection(t, testCon)
+	return res, err
+}
+
+func TestErrorResponseByDefault(t *testing.T) {
+	res, err := runSeparateErrorResponse(t, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.InternalServerError.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.InternalServerError.Number(), res.StatusCode)
+	}
+}
+
+func TestErrorResponseAsError(t *testing.T) {
+	res, err := runSeparateErrorResponse(t, true)
+	if res != nil {
+		t.Error("Expected no response")
+	}
+	resErr, ok := err.(*ResponseError)
+	if !ok {
+		t.Fatalf("Expected *ResponseError but got %v", err)
+	}
+	if resErr.Response.StatusCode != coapmsg.InternalServerError.Number() {
+		t.Errorf("Expected response code %d got %d", coapmsg.InternalServerError.Number(), resErr.Response.StatusCode)
+	}
+}
+
+func TestRequestRejectedWithReset(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	done := make(chan *coapmsg.Message, 1)
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			close(done)
+			return
+		}
+		if err := testCon.ServerSend(coapmsg.NewRst(msg.MessageID)); err != nil {
+			t.Error(err)
+		}
+		done <- &msg
+	}()
+
+	res, err := client.Get("coap+uart://any/foo")
+	if res != nil {
+		t.Error("Expected no response")
+	}
+	var rstErr *ResetError
+	if !errors.As(err, &rstErr) {
+		t.Fatalf("Expected *ResetError but got %v", err)
+	}
+	msg := <-done
+	if msg == nil {
+		t.FailNow()
+	}
+	if rstErr.MessageID != msg.MessageID {
+		t.Errorf("Expected MessageID %d but got %d", msg.MessageID, rstErr.MessageID)
+	}
+	if !bytes.Equal(rstErr.Token, msg.Token) {
+		t.Errorf("Expected Token %x but got %x", msg.Token, rstErr.Token)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestAckNotifyImmediatelyWithSlowConsumer(t *testing.T) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = trans
+
+	if !trans.AckNotifyImmediately {
+		t.Fatal("Expected AckNotifyImmediately to be enabled by default")
+	}
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+
+		// The consumer does not read the notifications yet, both must be ACKed anyway
+		for i := 2; i <= 3; i++ {
+			notify := coapmsg.NewMessage()
+			notify.Type = coapmsg.Confirmable
+			notify.Code = coapmsg.Content
+			notify.MessageID = uint16(1000 + i)
+			notify.Token = msg.Token
+			notify.Payload = []byte(fmt.Sprint(i))
+			notify.Options().Add(coapmsg.Observe, i)
+			if err := testCon.ServerSend(notify); err != nil {
+				t.Error(err)
+			}
+
+			res, err := testCon.ServerReceive(500 * time.Millisecond)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Type != coapmsg.Acknowledgement || res.MessageID != notify.MessageID {
+				t.Errorf("Expected prompt ACK for notification %d but got %s", i, res.String())
+			}
+		}
+
+		// Wait for Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Token = msg.Token
+		ack.Code = coapmsg.Content
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Slow consumer
+	time.Sleep(1500 * time.Millisecond)
+
+	for i := 2; i <= 3; i++ {
+		select {
+		case res = <-res.Next():
+			buf := bytes.Buffer{}
+			buf.ReadFrom(res.Body)
+			if buf.String() != fmt.Sprint(i) {
+				t.Errorf("Expected body '%d' but got %s", i, buf.String())
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("Timeout while waiting for Next")
+		}
+	}
+
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Error(err)
+	}
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+// runCancelObserveFinalResponse observes a resource and cancels it. The server
+// answers the cancellation with a final response that still carries the
+// Observe option, either piggybacked or as separate response.
+func runCancelObserveFinalResponse(t *testing.T, separate bool) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = trans
+
+	serverDone := make(chan bool)
+	go func() {
+		defer close(serverDone)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		final := coapmsg.NewAck(msg.MessageID)
+		if separate {
+			if err := testCon.ServerSend(final); err != nil {
+				t.Error(err)
+			}
+			final = coapmsg.NewMessage()
+			final.Type = coapmsg.Confirmable
+			final.MessageID = 1000
+		}
+		final.Code = coapmsg.Content
+		final.Token = msg.Token
+		final.Payload = []byte("last")
+		final.Options().Add(coapmsg.Observe, 5)
+		if err := testCon.ServerSend(final); err != nil {
+			t.Error(err)
+		}
+
+		if separate {
+			msg, err = testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if msg.Type != coapmsg.Acknowledgement || msg.MessageID != 1000 {
+				t.Errorf("Expected ACK for separate response but got %s", msg.String())
+			}
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	final, err := client.CancelObserve(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-serverDone
+
+	body := bytes.Buffer{}
+	body.ReadFrom(final.Body)
+	if body.String() != "last" {
+		t.Errorf("Expected final payload 'last' but got '%s'", body.String())
+	}
+	if final.Options.Get(coapmsg.Observe).AsUInt8() != 5 {
+		t.Errorf("Expected final Observe 5 but got %v", final.Options.Get(coapmsg.Observe))
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestCancelObserveReturnsFinalAck(t *testing.T) {
+	runCancelObserveFinalResponse(t, false)
+}
+
+func TestCancelObserveReturnsSeparateFinalResponse(t *testing.T) {
+	runCancelObserveFinalResponse(t, true)
+}
+
+func TestClientObserveOptsConfirmable(t *testing.T) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = trans
+
+	token := Token{0xCA, 0xFE}
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.Confirmable {
+			t.Errorf("Expected CON registration but got %s", msg.Type)
+		}
+		if !bytes.Equal(msg.Token, token) {
+			t.Errorf("Expected token %v but got %v", token, msg.Token)
+		}
+		if msg.Options().Get(coapmsg.Accept).AsUInt16() != uint16(coapmsg.AppJSON) {
+			t.Errorf("Expected Accept option %d but got %v", coapmsg.AppJSON, msg.Options().Get(coapmsg.Accept))
+		}
+
+		// Empty ACK for the registration, the response follows separately
+		if err := testCon.ServerSend(coapmsg.NewAck(msg.MessageID)); err != nil {
+			t.Error(err)
+		}
+
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.Confirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 1000
+		res.Token = msg.Token
+		res.Payload = []byte("1")
+		res.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+
+		ack, err := testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if ack.Type != coapmsg.Acknowledgement || ack.MessageID != 1000 {
+			t.Errorf("Expected ACK for message 1000 but got %s", ack.String())
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res = coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 2000
+		res.Token = msg.Token
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.ObserveOpts("coap+uart://any/o", ObserveOptions{
+		Confirmable: true,
+		Token:       token,
+		Accept:      coapmsg.AppJSON.Ptr(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Content.Number() {
+		t.Errorf("Expected 2.05 Content but got %s", res.Status)
+	}
+
+	if _, err := client.CancelObserveConfirmable(res, false); err != nil {
+		t.Fatal(err)
+	}
+
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestClientObserveOptsWithoutAccept(t *testing.T) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = trans
+
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if accept := msg.Options().Get(coapmsg.Accept); accept.IsSet() {
+			t.Errorf("Expected no Accept option but got %v", accept)
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 2000
+		res.Token = msg.Token
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.ObserveOpts("coap+uart://any/o", ObserveOptions{Confirmable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelObserveConfirmable(res, false); err != nil {
+		t.Fatal(err)
+	}
+
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestBlockWiseResponse(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	chunks := [][]byte{
+		bytes.Repeat([]byte("a"), 16),
+		bytes.Repeat([]byte("b"), 16),
+		[]byte("c"),
+	}
+
+	go func() {
+		for i, chunk := range chunks {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if i > 0 {
+				block, err := coapmsg.ParseBlock(msg.Options().Get(coapmsg.Block2).AsBytes())
+				if err != nil || block.Num != uint32(i) || block.SZX != 0 {
+					t.Errorf("Expected request for block %d but got %+v (%v)", i, block, err)
+				}
+			}
+			if msg.PathString() != "foo" {
+				t.Errorf("Expected path foo but got %s", msg.PathString())
+			}
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			ack.Payload = chunk
+			block := coapmsg.Block{Num: uint32(i), More: i < len(chunks)-1, SZX: 0}
+			ack.Options().Set(coapmsg.Block2, block.Bytes())
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Buffer{}
+	body.ReadFrom(res.Body)
+	expected := string(bytes.Join(chunks, nil))
+	if body.String() != expected {
+		t.Errorf("Expected body '%s' but got '%s'", expected, body.String())
+	}
+	if res.Options.Get(coapmsg.Block2).IsSet() {
+		t.Error("Expected no Block2 option on the reassembled response")
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestBlockWiseResponseSmallerBlockSize(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	// The server switches from 32 to 16 byte blocks with the second block
+	blocks := []struct {
+		requested coapmsg.Block
+		sent      coapmsg.Block
+		payload   []byte
+	}{
+		{coapmsg.Block{}, coapmsg.Block{Num: 0, More: true, SZX: 1}, bytes.Repeat([]byte("a"), 32)},
+		{coapmsg.Block{Num: 1, SZX: 1}, coapmsg.Block{Num: 2, More: true, SZX: 0}, bytes.Repeat([]byte("b"), 16)},
+		{coapmsg.Block{Num: 3, SZX: 0}, coapmsg.Block{Num: 3, More: false, SZX: 0}, []byte("c")},
+	}
+
+	go func() {
+		for i, b := range blocks {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if i > 0 {
+				block, err := coapmsg.ParseBlock(msg.Options().Get(coapmsg.Block2).AsBytes())
+				if err != nil || block.Num != b.requested.Num || block.SZX != b.requested.SZX {
+					t.Errorf("Expected request for block %+v but got %+v (%v)", b.requested, block, err)
+				}
+			}
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			ack.Payload = b.payload
+			ack.Options().Set(coapmsg.Block2, b.sent.Bytes())
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Buffer{}
+	body.ReadFrom(res.Body)
+	expected := strings.Repeat("a", 32) + strings.Repeat("b", 16) + "c"
+	if body.String() != expected {
+		t.Errorf("Expected body '%s' but got '%s'", expected, body.String())
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestBlockWiseResponseStreamClose(t *testing.T) {
+	trans := NewTransportUart()
+	trans.StreamBlockWiseResponses = true
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	lastBlockRequested := make(chan struct{})
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		for i := 0; i < 3; i++ {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// The request for the last block is never answered
+			if i == 2 {
+				close(lastBlockRequested)
+				break
+			}
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Code = coapmsg.Content
+			ack.Token = msg.Token
+			ack.Payload = bytes.Repeat([]byte{byte('a' + i)}, 16)
+			block := coapmsg.Block{Num: uint32(i), More: true, SZX: 0}
+			ack.Options().Set(coapmsg.Block2, block.Bytes())
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+
+		if msg, err := testCon.ServerReceive(200 * time.Millisecond); err == nil {
+			t.Errorf("Expected no request after Close but got %s", msg.String())
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(res.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Repeat("a", 16) + strings.Repeat("b", 16)
+	if string(buf) != expected {
+		t.Errorf("Expected body '%s' but got '%s'", expected, string(buf))
+	}
+
+	readErr := make(chan error)
+	go func() {
+		_, err := res.Body.Read(buf)
+		readErr <- err
+	}()
+	<-lastBlockRequested
+	res.Body.Close()
+
+	select {
+	case err := <-readErr:
+		if err != ERR_BODY_CLOSED {
+			t.Errorf("Expected ERR_BODY_CLOSED but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to stop the running block request")
+	}
+	<-serverDone
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestBlockWiseUpload(t *testing.T) {
+	trans := NewTransportUart()
+	trans.MaxBlockSize = 32
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4)
+
+	// The server asks for 16 byte blocks after the first 32 byte block
+	expected := []coapmsg.Block{
+		{Num: 0, More: true, SZX: 1},
+		{Num: 2, More: true, SZX: 0},
+		{Num: 3, More: false, SZX: 0},
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		var body []byte
+		for i, exp := range expected {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			block, err := coapmsg.ParseBlock(msg.Options().Get(coapmsg.Block1).AsBytes())
+			if err != nil || block != exp {
+				t.Errorf("Expected block %+v but got %+v (%v)", exp, block, err)
+			}
+			body = append(body, msg.Payload...)
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Token = msg.Token
+			ack.Code = coapmsg.Continue
+			if i == len(expected)-1 {
+				ack.Code = coapmsg.Changed
+			}
+			ack.Options().Set(coapmsg.Block1, coapmsg.Block{Num: block.Num, More: block.More, SZX: 0}.Bytes())
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+		received <- body
+	}()
+
+	req, err := NewRequest("POST", "coap+uart://any/upload", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Changed.Number() {
+		t.Errorf("Expected 2.04 Changed but got %s", res.Status)
+	}
+	if body := <-received; !bytes.Equal(body, payload) {
+		t.Errorf("Expected uploaded payload '%s' but got '%s'", payload, body)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestBlockWiseUploadWithBlockWiseResponse(t *testing.T) {
+	trans := NewTransportUart()
+	trans.MaxBlockSize = 16
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	payload := bytes.Repeat([]byte("u"), 40)
+	response := [][]byte{
+		bytes.Repeat([]byte("a"), 16),
+		bytes.Repeat([]byte("b"), 16),
+		[]byte("c"),
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		var token []byte
+		var body []byte
+		receive := func() (coapmsg.Message, bool) {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return msg, false
+			}
+			if msg.Code != coapmsg.POST {
+				t.Errorf("Expected POST but got %s", msg.Code)
+			}
+			if token == nil {
+				token = msg.Token
+			} else if !bytes.Equal(msg.Token, token) {
+				t.Errorf("Expected token %v for all blocks but got %v", token, msg.Token)
+			}
+			return msg, true
+		}
+
+		// Upload with Block1
+		for num := 0; num < 3; num++ {
+			msg, ok := receive()
+			if !ok {
+				return
+			}
+			body = append(body, msg.Payload...)
+			block1, err := coapmsg.ParseBlock(msg.Options().Get(coapmsg.Block1).AsBytes())
+			if err != nil || block1.Num != uint32(num) {
+				t.Errorf("Expected Block1 %d but got %+v (%v)", num, block1, err)
+			}
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Token = msg.Token
+			ack.Code = coapmsg.Continue
+			ack.Options().Set(coapmsg.Block1, block1.Bytes())
+			if !block1.More {
+				// The last upload block is answered with the first response block
+				ack.Code = coapmsg.Changed
+				ack.Payload = response[0]
+				ack.Options().Set(coapmsg.Block2, coapmsg.Block{Num: 0, More: true, SZX: 0}.Bytes())
+			}
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+
+		// Download with Block2
+		for num := 1; num < len(response); num++ {
+			msg, ok := receive()
+			if !ok {
+				return
+			}
+			if msg.Options().Get(coapmsg.Block1).IsSet() || len(msg.Payload) > 0 {
+				t.Errorf("Expected Block2 request without Block1 and payload but got %s", msg.String())
+			}
+			block2, err := coapmsg.ParseBlock(msg.Options().Get(coapmsg.Block2).AsBytes())
+			if err != nil || block2.Num != uint32(num) {
+				t.Errorf("Expected Block2 %d but got %+v (%v)", num, block2, err)
+			}
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Token = msg.Token
+			ack.Code = coapmsg.Changed
+			ack.Payload = response[num]
+			ack.Options().Set(coapmsg.Block2, coapmsg.Block{Num: uint32(num), More: num < len(response)-1, SZX: 0}.Bytes())
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+		received <- body
+	}()
+
+	req, err := NewRequest("POST", "coap+uart://any/exchange", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Changed.Number() {
+		t.Errorf("Expected 2.04 Changed but got %s", res.Status)
+	}
+	resBody := bytes.Buffer{}
+	resBody.ReadFrom(res.Body)
+	if expected := string(bytes.Join(response, nil)); resBody.String() != expected {
+		t.Errorf("Expected response body '%s' but got '%s'", expected, resBody.String())
+	}
+	if body := <-received; !bytes.Equal(body, payload) {
+		t.Errorf("Expected uploaded payload '%s' but got '%s'", payload, body)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestRequestEntityTooLargeTeachesMaxPayload(t *testing.T) {
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	payload := bytes.Repeat([]byte("x"), 100)
+
+	// The first request is rejected, the second is sent in 32 byte blocks
+	expected := []coapmsg.Block{
+		{Num: 0, More: true, SZX: 1},
+		{Num: 1, More: true, SZX: 1},
+		{Num: 2, More: true, SZX: 1},
+		{Num: 3, More: false, SZX: 1},
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Options().Get(coapmsg.Block1).IsSet() {
+			t.Error("Expected first request without Block1 option")
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Token = msg.Token
+		ack.Code = coapmsg.RequestEntityTooLarge
+		ack.Options().Set(coapmsg.Size1, uint32(32))
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		var body []byte
+		for i, exp := range expected {
+			msg, err := testCon.ServerReceive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			block, err := coapmsg.ParseBlock(msg.Options().Get(coapmsg.Block1).AsBytes())
+			if err != nil || block != exp {
+				t.Errorf("Expected block %+v but got %+v (%v)", exp, block, err)
+			}
+			body = append(body, msg.Payload...)
+
+			ack := coapmsg.NewAck(msg.MessageID)
+			ack.Token = msg.Token
+			ack.Code = coapmsg.Continue
+			if i == len(expected)-1 {
+				ack.Code = coapmsg.Changed
+			}
+			ack.Options().Set(coapmsg.Block1, block.Bytes())
+			if err := testCon.ServerSend(ack); err != nil {
+				t.Error(err)
+			}
+		}
+		received <- body
+	}()
+
+	req, err := NewRequest("POST", "coap+uart://any/upload", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.RequestEntityTooLarge.Number() {
+		t.Errorf("Expected 4.13 Request Entity Too Large but got %s", res.Status)
+	}
+	if size := trans.MaxPayload("any"); size != 32 {
+		t.Errorf("Expected learned max payload 32 but got %d", size)
+	}
+
+	req, err = NewRequest("POST", "coap+uart://any/upload", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != coapmsg.Changed.Number() {
+		t.Errorf("Expected 2.04 Changed but got %s", res.Status)
+	}
+	if body := <-received; !bytes.Equal(body, payload) {
+		t.Errorf("Expected uploaded payload '%s' but got '%s'", payload, body)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+// Run with -race to detect shared option maps between notifications
+func TestObserveNotificationOptionsAreNotShared(t *testing.T) {
+	client := NewClient()
+	client.Timeout = 10 * time.Second
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	client.Transport = trans
+
+	const notifications = 5
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		ack.Options().Add(coapmsg.ETag, []byte{1})
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		for i := 2; i <= notifications; i++ {
+			notify := coapmsg.NewMessage()
+			notify.Type = coapmsg.NonConfirmable
+			notify.Code = coapmsg.Content
+			notify.MessageID = uint16(1000 + i)
+			notify.Token = msg.Token
+			notify.Options().Add(coapmsg.Observe, i)
+			notify.Options().Add(coapmsg.ETag, []byte{byte(i)})
+			if err := testCon.ServerSend(notify); err != nil {
+				t.Error(err)
+			}
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(5 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res := coapmsg.NewMessage()
+		res.Type = coapmsg.NonConfirmable
+		res.Code = coapmsg.Content
+		res.MessageID = 2000
+		res.Token = msg.Token
+		if err := testCon.ServerSend(res); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := res
+	for i := 1; i <= notifications; i++ {
+		// Read the options of the current notification while the next one arrives
+		done := make(chan []byte)
+		go func(r *Response) {
+			var etag []byte
+			for j := 0; j < 100; j++ {
+				etag = r.ETag()
+				_ = r.Options.String()
+			}
+			done <- etag
+		}(current)
+
+		if i < notifications {
+			next, err := current.NextWithTimeout(3 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if etag := <-done; !bytes.Equal(etag, []byte{byte(i)}) {
+				t.Errorf("Expected ETag %d of notification %d but got %v", i, i, etag)
+			}
+			current = next
+		} else if etag := <-done; !bytes.Equal(etag, []byte{byte(i)}) {
+			t.Errorf("Expected ETag %d of notification %d but got %v", i, i, etag)
+		}
+	}
+
+	if _, err := client.CancelObserveConfirmable(res, false); err != nil {
+		t.Fatal(err)
+	}
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestObserveIsStaleAfterMaxAge(t *testing.T) {
+	clock := newFakeClock()
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+	trans.Clock = clock
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		ack.Options().Add(coapmsg.MaxAge, 10)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/o", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Options.Set(coapmsg.Observe, 0)
+	res, err := trans.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing happens within Max-Age and the grace period
+	start := clock.Now()
+	stale := 10*time.Second + ObserveMaxAgeGrace
+	for i := 0; i < 100; i++ {
+		clock.Advance(time.Second)
+		_, err = res.NextWithTimeout(10 * time.Millisecond)
+		if err != ERR_NOTIFICATION_TIMEOUT {
+			break
+		}
+	}
+	if err != ERR_OBSERVE_STALE {
+		t.Fatalf("Expected %v but got %v", ERR_OBSERVE_STALE, err)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed < stale {
+		t.Errorf("Expected observe to be stale after %s but was after %s", stale, elapsed)
+	}
+	if res.ObserveErr() != ERR_OBSERVE_STALE {
+		t.Errorf("Expected ObserveErr %v but got %v", ERR_OBSERVE_STALE, res.ObserveErr())
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestMaxAgeDefault(t *testing.T) {
+	opts := coapmsg.CoapOptions{}
+	if d := maxAge(opts); d != 60*time.Second {
+		t.Errorf("Expected default Max-Age of 60s but got %s", d)
+	}
+	opts.Set(coapmsg.MaxAge, 300)
+	if d := maxAge(opts); d != 300*time.Second {
+		t.Errorf("Expected Max-Age of 300s but got %s", d)
+	}
+}
+
+func TestObserveBufferAbsorbsBurst(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	const burst = 3
+	asyncDoneChan := make(chan bool)
+	go func() {
+		defer func() { asyncDoneChan <- true }()
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		for i := 2; i < 2+burst; i++ {
+			notify := coapmsg.NewMessage()
+			notify.Type = coapmsg.NonConfirmable
+			notify.Code = coapmsg.Content
+			notify.MessageID = uint16(1000 + i)
+			notify.Token = msg.Token
+			notify.Payload = []byte(strconv.Itoa(i))
+			notify.Options().Add(coapmsg.Observe, i)
+			if err := testCon.ServerSend(notify); err != nil {
+				t.Error(err)
+			}
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	opts := DefaultObserveOptions
+	opts.BufferSize = burst
+	res, err := client.ObserveOpts("coap+uart://any/o", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(res.Next()) != burst {
+		t.Errorf("Expected notification buffer of %d but got %d", burst, cap(res.Next()))
+	}
+
+	// The burst is buffered without a consumer
+	for start := time.Now(); len(res.Next()) < burst; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("Expected %d buffered notifications but got %d", burst, len(res.Next()))
+		}
+	}
+
+	for i := 2; i < 2+burst; i++ {
+		notification := <-res.Next()
+		body, _ := ioutil.ReadAll(notification.Body)
+		if string(body) != strconv.Itoa(i) {
+			t.Errorf("Expected notification %d but got %s", i, body)
+		}
+	}
+
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Error(err)
+	}
+	<-asyncDoneChan
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestPostponedResponseTimeout(t *testing.T) {
+	oldTimeout := POSTPONED_RESPONSE_TIMEOUT
+	POSTPONED_RESPONSE_TIMEOUT = 100 * time.Millisecond
+	defer func() { POSTPONED_RESPONSE_TIMEOUT = oldTimeout }()
+
+	trans := NewTransportUart()
+	testCon := NewTestConnector(t)
+	trans.Connecter = testCon
+
+	go func() {
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Empty ACK, the separate response never follows
+		if err := testCon.ServerSend(coapmsg.NewAck(msg.MessageID)); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	req, err := NewRequest("GET", "coap+uart://any/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := trans.RoundTrip(req)
+	if res != nil {
+		t.Error("Expected no response but got", res)
+	}
+	if err != ERR_POSTPONED_RESPONSE_TIMEOUT {
+		t.Fatalf("Expected %v but got %v", ERR_POSTPONED_RESPONSE_TIMEOUT, err)
+	}
+	if terr, ok := err.(interface{ Timeout() bool }); !ok || !terr.Timeout() {
+		t.Error("Expected a timeout error")
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestNotificationDroppedForSlowConsumer(t *testing.T) {
+	client, testCon := NewTestClient(t)
+	trans := client.Transport.(*TransportUart)
+	trans.NotificationConsumerTimeout = 50 * time.Millisecond
+	dropped := make(chan []byte, 1)
+	trans.OnNotificationDropped = func(token []byte) {
+		dropped <- token
+	}
+
+	serverDone := make(chan bool)
+	go func() {
+		defer close(serverDone)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		notify := coapmsg.NewMessage()
+		notify.Type = coapmsg.Confirmable
+		notify.Code = coapmsg.Content
+		notify.MessageID = 1000
+		notify.Token = msg.Token
+		notify.Options().Add(coapmsg.Observe, 2)
+		if err := testCon.ServerSend(notify); err != nil {
+			t.Error(err)
+		}
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Cancel observe
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Token = msg.Token
+		ack.Code = coapmsg.Content
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The consumer never reads res.Next()
+	select {
+	case token := <-dropped:
+		if !bytes.Equal(token, res.Request.Token) {
+			t.Errorf("Expected token %v but got %v", res.Request.Token, token)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected OnNotificationDropped to be called")
+	}
+	if n := trans.DroppedNotifications(); n != 1 {
+		t.Errorf("Expected 1 dropped notification but got %d", n)
+	}
+
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Error(err)
+	}
+	<-serverDone
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestCancelObserveWaitConsumesLateAck(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	serverDone := make(chan bool)
+	go func() {
+		defer close(serverDone)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Cancel observe, the ACK is sent after the interaction used to be closed
+		msg, err = testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(3500 * time.Millisecond)
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Token = msg.Token
+		ack.Code = coapmsg.Content
+		ack.Payload = []byte("canceled")
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	final, err := client.CancelObserveWait(res, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-serverDone
+
+	body, _ := ioutil.ReadAll(final.Body)
+	if string(body) != "canceled" {
+		t.Errorf("Expected the cancel ACK but got %s", body)
+	}
+	if _, ok := <-res.Next(); ok {
+		t.Error("Expected Next to be closed")
+	}
+	if n := testCon.conn.InteractionCount(); n != 0 {
+		t.Errorf("Expected the interaction to be removed but got %d interactions", n)
+	}
+	ValidateCleanConnection(t, testCon)
+}
+
+func TestCancelObserveWaitTimeout(t *testing.T) {
+	client, testCon := NewTestClient(t)
+
+	serverDone := make(chan bool)
+	go func() {
+		defer close(serverDone)
+		msg, err := testCon.ServerReceive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := testCon.ServerSend(ack); err != nil {
+			t.Error(err)
+		}
+
+		// Cancel observe is never acknowledged
+		if _, err := testCon.ServerReceive(3 * time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := client.Observe("coap+uart://any/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CancelObserveWait(res, 100*time.Millisecond); err != ERR_CANCEL_OBSERVE_TIMEOUT {
+		t.Errorf("Expected %v but got %v", ERR_CANCEL_OBSERVE_TIMEOUT, err)
+	}
+	<-serverDone
+	ValidateCleanConnection(t, testCon)
+}
@@ -0,0 +1,191 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const UdpScheme = "coap"
+
+// DefaultUdpPort is used when the URL host has no port
+const DefaultUdpPort = "5683"
+
+// DefaultUdpKeepAliveInterval is the KeepAliveInterval of the TransportUdp.
+// NATs keep UDP mappings for at least two minutes (RFC 4787, REQ-5).
+const DefaultUdpKeepAliveInterval = 60 * time.Second
+
+// TransportUdp sends requests over UDP.
+// The URI scheme must be coap, valid URIs would be
+// coap://example.com/sensors/temperature
+// coap://192.168.0.10:5683/sensors/temperature
+//
+// Each connection keeps its local port until it is closed, so notifications
+// of an observe reach the client through a NAT. The NAT mapping is kept
+// open with pings, see KeepAliveInterval.
+//
+// Retransmission and all other settings work like for the TransportUart.
+type TransportUdp struct {
+	*TransportUart
+
+	// Network is "udp4" or "udp6" to only connect over IPv4 or IPv6, e.g. to
+	// devices that only listen on one of the addresses of their host name.
+	// Default is "udp" (both). WithUdpNetwork overrides it per request.
+	Network string
+}
+
+func NewTransportUdp() *TransportUdp {
+	t := NewTransportUart()
+	t.scheme = UdpScheme
+	t.Connecter = NewUdpConnector()
+	t.KeepAliveInterval = DefaultUdpKeepAliveInterval
+	return &TransportUdp{TransportUart: t}
+}
+
+var _ MulticastRoundTripper = (*TransportUdp)(nil)
+
+type udpNetworkKey struct{}
+
+// WithUdpNetwork returns a copy of ctx that makes the TransportUdp connect
+// over network ("udp", "udp4" or "udp6") instead of TransportUdp.Network
+func WithUdpNetwork(ctx context.Context, network string) context.Context {
+	return context.WithValue(ctx, udpNetworkKey{}, network)
+}
+
+// udpNetworkFromContext returns the network set by WithUdpNetwork or "udp"
+func udpNetworkFromContext(ctx context.Context) string {
+	if network, ok := ctx.Value(udpNetworkKey{}).(string); ok && network != "" {
+		return network
+	}
+	return "udp"
+}
+
+// withNetwork sets Network as network of ctx unless the request overrides it
+func (t *TransportUdp) withNetwork(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(udpNetworkKey{}).(string); ok || t.Network == "" {
+		return ctx
+	}
+	return WithUdpNetwork(ctx, t.Network)
+}
+
+func (t *TransportUdp) RoundTrip(req *Request) (*Response, error) {
+	return t.TransportUart.RoundTrip(req.WithContext(t.withNetwork(req.Context())))
+}
+
+// Dial is like TransportUart.Dial over the Network of the transport
+func (t *TransportUdp) Dial(ctx context.Context, host string) (Connection, error) {
+	return t.TransportUart.Dial(t.withNetwork(ctx), host)
+}
+
+// Capabilities implements CapabilityReporter
+func (t *TransportUdp) Capabilities() Capabilities {
+	caps := t.TransportUart.Capabilities()
+	caps.Multicast = true
+	return caps
+}
+
+// RoundTripMulticast implements MulticastRoundTripper.
+// The request is sent as NON request and the interaction
+// collects all responses with its token until window is over.
+func (t *TransportUdp) RoundTripMulticast(req *Request, window time.Duration) ([]*Response, error) {
+	if req == nil {
+		return nil, errors.New("coap: Got nil request")
+	}
+	if req.URL == nil {
+		return nil, errors.New("coap: Missing request URL")
+	}
+	if scheme := t.urlScheme(); req.URL.Scheme != scheme {
+		return nil, errors.New(fmt.Sprint("coap: Invalid URL scheme, expected "+scheme+" but got: ", req.URL.Scheme))
+	}
+	if req.Confirmable {
+		return nil, ERR_MULTICAST_CONFIRMABLE
+	}
+	if len(req.Token) == 0 {
+		req.Token = t.TokenGenerator.NextToken()
+	}
+	req = req.WithContext(t.withNetwork(req.Context()))
+
+	reqMsg, err := t.buildRequestMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := t.Connecter.ConnectContext(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ia := conn.StartInteraction(conn, reqMsg)
+	ia.clock = t.Clock
+	ia.metadata = metadataFromContext(req.Context())
+
+	resMsgs, err := ia.RoundTripMulticast(req.Context(), reqMsg, window)
+	if !ia.Closed() {
+		ia.Close()
+	}
+	if err == ERR_INTERACTION_CLOSED || err == ERR_CONNECTION_CLOSED {
+		return nil, err
+	}
+	if err != nil {
+		return nil, wrapError(err, fmt.Sprint("Failed multicast Roundtrip with Token ", ia.Token()))
+	}
+
+	responses := make([]*Response, 0, len(resMsgs))
+	for _, resMsg := range resMsgs {
+		responses = append(responses, buildResponse(req, resMsg))
+	}
+	return responses, nil
+}
+
+var _ SerialConnecter = (*UdpConnector)(nil)
+
+// UdpConnector provides the UDP connections of the TransportUdp
+type UdpConnector struct {
+	udpConnections
+}
+
+func NewUdpConnector() *UdpConnector {
+	return &UdpConnector{}
+}
+
+func (c *UdpConnector) findConnection(host string) Connection {
+	return c.find(hostAddr(host, DefaultUdpPort))
+}
+
+func (c *UdpConnector) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+// ConnectContext reuses the open connection to host or opens a new one
+// over the network set by WithUdpNetwork, "udp" by default.
+// For multicast hosts the connection receives the responses from any address.
+func (c *UdpConnector) ConnectContext(ctx context.Context, host string) (Connection, error) {
+	network := udpNetworkFromContext(ctx)
+	addr := hostAddr(host, DefaultUdpPort)
+	return c.connect(network, addr, func() (net.Conn, error) {
+		if udpAddr, err := net.ResolveUDPAddr(network, addr); err == nil && udpAddr.IP.IsMulticast() {
+			return listenMulticast(network, udpAddr)
+		}
+
+		log.WithField("addr", addr).WithField("network", network).Info("Opening UDP connection ...")
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, wrapError(err, "Failed to open UDP connection to "+addr)
+		}
+		return conn, nil
+	})
+}
+
+// listenMulticast opens an unconnected socket that sends to group, a connected
+// one would drop the responses since they come from the unicast server addresses
+func listenMulticast(network string, group *net.UDPAddr) (net.Conn, error) {
+	log.WithField("addr", group.String()).Info("Opening UDP multicast connection ...")
+	conn, err := net.ListenPacket(network, ":0")
+	if err != nil {
+		return nil, wrapError(err, "Failed to open UDP multicast connection to "+group.String())
+	}
+	return &packetConn{PacketConn: conn, remote: group}, nil
+}
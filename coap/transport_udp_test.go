@@ -0,0 +1,513 @@
+package coap
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lobaro/coap-go/coapmsg"
+)
+
+// udpServer is a loopback UDP socket acting as CoAP server
+type udpServer struct {
+	t    *testing.T
+	conn net.PacketConn
+	peer net.Addr
+}
+
+func newUdpServer(t *testing.T) *udpServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &udpServer{t: t, conn: conn}
+}
+
+func (s *udpServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *udpServer) Receive(timeout time.Duration) (*coapmsg.Message, error) {
+	buf := make([]byte, 1500)
+	s.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, peer, err := s.conn.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	s.peer = peer
+	msg, err := coapmsg.ParseMessage(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	s.t.Logf("Server: received %v", msg)
+	return &msg, nil
+}
+
+// Send sends msg to the client of the last received message
+func (s *udpServer) Send(msg coapmsg.Message) error {
+	s.t.Logf("Server: send %v", msg)
+	_, err := s.conn.WriteTo(msg.MustMarshalBinary(), s.peer)
+	return err
+}
+
+func TestUdpGet(t *testing.T) {
+	server := newUdpServer(t)
+	defer server.conn.Close()
+
+	go func() {
+		msg, err := server.Receive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if path := msg.Options().Get(coapmsg.URIPath).AsString(); path != "foo" {
+			t.Errorf("Expected path foo but got %s", path)
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("bar")
+		if err := server.Send(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	client := NewClient()
+	client.Transport = &Transport{TransUdp: NewTransportUdp()}
+
+	res, err := client.Get("coap://" + server.Addr() + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "bar" {
+		t.Errorf("Expected body bar but got %s", body)
+	}
+}
+
+func TestUdpObserve(t *testing.T) {
+	server := newUdpServer(t)
+	defer server.conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg, err := server.Receive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := server.Send(ack); err != nil {
+			t.Error(err)
+		}
+
+		notify := coapmsg.NewMessage()
+		notify.Type = coapmsg.Confirmable
+		notify.Code = coapmsg.Content
+		notify.MessageID = 100
+		notify.Token = msg.Token
+		notify.Payload = []byte("2")
+		notify.Options().Add(coapmsg.Observe, 2)
+		if err := server.Send(notify); err != nil {
+			t.Error(err)
+		}
+
+		msg, err = server.Receive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Type != coapmsg.Acknowledgement || msg.MessageID != 100 {
+			t.Errorf("Expected ACK for notification but got %v", msg)
+		}
+	}()
+
+	client := NewClient()
+	client.Transport = &Transport{TransUdp: NewTransportUdp()}
+
+	res, err := client.Observe("coap://" + server.Addr() + "/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "1" {
+		t.Errorf("Expected body 1 but got %s", body)
+	}
+
+	res, err = res.NextWithTimeout(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	if string(body) != "2" {
+		t.Errorf("Expected notification 2 but got %s", body)
+	}
+	<-done
+}
+
+func TestUdpObserveKeepAlive(t *testing.T) {
+	server := newUdpServer(t)
+	defer server.conn.Close()
+
+	// All messages of the client must come from the same address and port
+	peers := make(map[string]bool)
+	pings := 0
+	receive := func() (*coapmsg.Message, error) {
+		for {
+			msg, err := server.Receive(3 * time.Second)
+			if err != nil {
+				return nil, err
+			}
+			peers[server.peer.String()] = true
+			if msg.Type != coapmsg.Confirmable || msg.Code != coapmsg.Empty {
+				return msg, nil
+			}
+			pings++
+			rst := coapmsg.NewRst(msg.MessageID)
+			if err := server.Send(rst); err != nil {
+				return nil, err
+			}
+			// Notify after the first keep-alive ping
+			if pings == 1 {
+				return msg, nil
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg, err := receive()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		token := msg.Token
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = token
+		ack.Payload = []byte("1")
+		ack.Options().Add(coapmsg.Observe, 1)
+		if err := server.Send(ack); err != nil {
+			t.Error(err)
+		}
+
+		if _, err := receive(); err != nil || pings != 1 {
+			t.Errorf("Expected a keep-alive ping (%v)", err)
+			return
+		}
+
+		notify := coapmsg.NewMessage()
+		notify.Type = coapmsg.Confirmable
+		notify.Code = coapmsg.Content
+		notify.MessageID = 100
+		notify.Token = token
+		notify.Payload = []byte("2")
+		notify.Options().Add(coapmsg.Observe, 2)
+		if err := server.Send(notify); err != nil {
+			t.Error(err)
+		}
+		if msg, err = receive(); err != nil || msg.Type != coapmsg.Acknowledgement || msg.MessageID != 100 {
+			t.Errorf("Expected ACK for notification but got %v (%v)", msg, err)
+			return
+		}
+
+		// The cancel request ends the observe
+		msg, err = receive()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack = coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := server.Send(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	trans := NewTransportUdp()
+	trans.KeepAliveInterval = 50 * time.Millisecond
+	client := NewClient()
+	client.Transport = &Transport{TransUdp: trans}
+
+	res, err := client.Observe("coap://" + server.Addr() + "/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := res.NextWithTimeout(3 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(next.Body)
+	if string(body) != "2" {
+		t.Errorf("Expected notification 2 but got %s", body)
+	}
+	if _, err := client.CancelObserve(res); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if len(peers) != 1 {
+		t.Errorf("Expected all messages from one local port but got %v", peers)
+	}
+}
+
+// fanOutConn sends every datagram to all servers like to a multicast group
+type fanOutConn struct {
+	net.PacketConn
+	servers []net.Addr
+}
+
+func (c *fanOutConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *fanOutConn) Write(b []byte) (int, error) {
+	for _, server := range c.servers {
+		if _, err := c.WriteTo(b, server); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *fanOutConn) RemoteAddr() net.Addr {
+	return c.servers[0]
+}
+
+// fanOutConnector connects to the servers instead of a multicast group
+type fanOutConnector struct {
+	udpConnections
+	servers []net.Addr
+}
+
+func (c *fanOutConnector) Connect(host string) (Connection, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+func (c *fanOutConnector) ConnectContext(ctx context.Context, host string) (Connection, error) {
+	return c.connect("udp", host, func() (net.Conn, error) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		return &fanOutConn{PacketConn: conn, servers: c.servers}, nil
+	})
+}
+
+func TestUdpGetMulticast(t *testing.T) {
+	servers := []*udpServer{newUdpServer(t), newUdpServer(t)}
+	addrs := make([]net.Addr, 0)
+	done := make(chan struct{})
+	for i, server := range servers {
+		defer server.conn.Close()
+		addrs = append(addrs, server.conn.LocalAddr())
+
+		go func(server *udpServer, name string) {
+			defer func() { done <- struct{}{} }()
+			msg, err := server.Receive(3 * time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if msg.Type != coapmsg.NonConfirmable {
+				t.Errorf("Expected NON request but got %v", msg.Type)
+			}
+			res := coapmsg.NewMessage()
+			res.Type = coapmsg.NonConfirmable
+			res.Code = coapmsg.Content
+			res.MessageID = 200
+			res.Token = msg.Token
+			res.Payload = []byte(name)
+			if err := server.Send(res); err != nil {
+				t.Error(err)
+			}
+		}(server, string(rune('a'+i)))
+	}
+
+	transport := NewTransportUdp()
+	transport.Connecter = &fanOutConnector{servers: addrs}
+	client := NewClient()
+	client.Transport = &Transport{TransUdp: transport}
+
+	if caps, ok := client.Capabilities(UdpScheme); !ok || !caps.Multicast {
+		t.Error("Expected udp transport to support multicast")
+	}
+
+	responses, err := client.GetMulticast("coap://224.0.1.187/.well-known/core", 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	<-done
+
+	bodies := make([]string, 0)
+	for _, res := range responses {
+		body, _ := ioutil.ReadAll(res.Body)
+		bodies = append(bodies, string(body))
+	}
+	sort.Strings(bodies)
+	if len(bodies) != 2 || bodies[0] != "a" || bodies[1] != "b" {
+		t.Errorf("Expected the responses a and b but got %v", bodies)
+	}
+}
+
+func TestMulticastNotSupported(t *testing.T) {
+	client := NewClient()
+	client.Transport = &Transport{TransUart: NewTransportUart()}
+
+	_, err := client.GetMulticast("coap+uart://any/.well-known/core", time.Second)
+	if err == nil {
+		t.Error("Expected an error for the uart transport")
+	}
+}
+
+func TestUdpUriPort(t *testing.T) {
+	server := newUdpServer(t)
+	defer server.conn.Close()
+
+	go func() {
+		msg, err := server.Receive(3 * time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, port, _ := net.SplitHostPort(server.Addr())
+		if p := msg.Options().Get(coapmsg.URIPort); p.IsNotSet() || strconv.Itoa(int(p.AsUInt16())) != port {
+			t.Errorf("Expected Uri-Port %s but got %v", port, p)
+		}
+		// The IP literal is implied by the destination address
+		if h := msg.Options().Get(coapmsg.URIHost); h.IsSet() {
+			t.Errorf("Expected no Uri-Host but got %v", h)
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := server.Send(ack); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	client := NewClient()
+	client.Transport = &Transport{TransUdp: NewTransportUdp()}
+	if _, err := client.Get("coap://" + server.Addr() + "/foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveContent answers count requests with 2.05 Content
+func (s *udpServer) serveContent(count int) {
+	for i := 0; i < count; i++ {
+		msg, err := s.Receive(3 * time.Second)
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+		ack := coapmsg.NewAck(msg.MessageID)
+		ack.Code = coapmsg.Content
+		ack.Token = msg.Token
+		if err := s.Send(ack); err != nil {
+			s.t.Error(err)
+		}
+	}
+}
+
+func TestUdpNetwork(t *testing.T) {
+	server := newUdpServer(t)
+	defer server.conn.Close()
+	go server.serveContent(2)
+
+	trans := NewTransportUdp()
+	trans.Network = "udp4"
+	client := NewClient()
+	client.Transport = &Transport{TransUdp: trans}
+
+	if _, err := client.Get("coap://" + server.Addr() + "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("coap://[::1]:5683/foo"); err == nil {
+		t.Error("Expected an IPv6 address to be rejected with udp4")
+	}
+
+	// The network of the request overrides the one of the transport
+	trans.Network = "udp6"
+	req, err := NewRequest("GET", "coap://"+server.Addr()+"/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req.WithContext(WithUdpNetwork(context.Background(), "udp4"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUdpUriHostAndPortOptions(t *testing.T) {
+	trans := NewTransportUdp()
+	tests := []struct {
+		url  string
+		host string
+		port uint16
+	}{
+		{"coap://sensor.example.com/foo", "sensor.example.com", 0},
+		{"coap://sensor.example.com:5683/foo", "sensor.example.com", 0},
+		{"coap://sensor.example.com:5000/foo", "sensor.example.com", 5000},
+		{"coap://192.168.0.10/foo", "", 0},
+		{"coap://[::1]:5000/foo", "", 5000},
+	}
+	for _, test := range tests {
+		req, err := NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := trans.buildRequestMessage(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host := msg.Options().Get(coapmsg.URIHost).AsString(); host != test.host {
+			t.Errorf("%s: Expected Uri-Host %q but got %q", test.url, test.host, host)
+		}
+		if port := msg.Options().Get(coapmsg.URIPort).AsUInt16(); port != test.port {
+			t.Errorf("%s: Expected Uri-Port %d but got %d", test.url, test.port, port)
+		}
+	}
+
+	// The host of UART URLs is a device name
+	req, err := NewRequest("GET", "coap+uart://COM3/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := NewTransportUart().buildRequestMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt := msg.Options().Get(coapmsg.URIHost); opt.IsSet() {
+		t.Errorf("Expected no Uri-Host for UART but got %v", opt)
+	}
+}
+
+func TestHostAddr(t *testing.T) {
+	tests := map[string]string{
+		"example.com":      "example.com:5683",
+		"example.com:5000": "example.com:5000",
+		"[::1]":            "[::1]:5683",
+		"[::1]:5000":       "[::1]:5000",
+	}
+	for host, expected := range tests {
+		if addr := hostAddr(host, DefaultUdpPort); addr != expected {
+			t.Errorf("Expected %s for host %s but got %s", expected, host, addr)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package coapmsg
+
+import "errors"
+
+var ErrInvalidBlock = errors.New("invalid block option")
+
+// Block is the value of the Block1 and Block2 options (RFC 7959, section 2.2)
+type Block struct {
+	Num  uint32 // Number of the block
+	More bool   // More blocks follow
+	SZX  uint8  // Size exponent, the block size is 2^(SZX+4) bytes
+}
+
+// Size returns the block size in bytes
+func (b Block) Size() int {
+	return 1 << (b.SZX + 4)
+}
+
+// Bytes returns the option value
+func (b Block) Bytes() []byte {
+	v := b.Num<<4 | uint32(b.SZX&0x7)
+	if b.More {
+		v |= 0x8
+	}
+	return encodeInt(v)
+}
+
+// ParseBlock parses the value of a Block1 or Block2 option
+func ParseBlock(value []byte) (Block, error) {
+	if len(value) > 3 {
+		return Block{}, ErrInvalidBlock
+	}
+	var v uint32
+	for _, b := range value {
+		v = v<<8 | uint32(b)
+	}
+	block := Block{
+		Num:  v >> 4,
+		More: v&0x8 != 0,
+		SZX:  uint8(v & 0x7),
+	}
+	// SZX 7 is reserved
+	if block.SZX == 7 {
+		return Block{}, ErrInvalidBlock
+	}
+	return block, nil
+}
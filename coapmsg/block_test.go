@@ -0,0 +1,58 @@
+package coapmsg
+
+import "testing"
+
+func TestBlockRoundTrip(t *testing.T) {
+	for _, b := range []Block{
+		{Num: 0, More: false, SZX: 0},
+		{Num: 0, More: true, SZX: 6},
+		{Num: 15, More: true, SZX: 2},
+		{Num: 4095, More: false, SZX: 4},
+		{Num: 1<<20 - 1, More: true, SZX: 6},
+	} {
+		parsed, err := ParseBlock(b.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != b {
+			t.Errorf("Expected %+v but got %+v", b, parsed)
+		}
+	}
+}
+
+func TestParseBlock(t *testing.T) {
+	// NUM=1, M=1, SZX=2 (64 bytes)
+	b, err := ParseBlock([]byte{0x1A})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Num != 1 || !b.More || b.Size() != 64 {
+		t.Errorf("Unexpected block %+v", b)
+	}
+
+	if _, err := ParseBlock([]byte{0x07}); err != ErrInvalidBlock {
+		t.Errorf("Expected reserved SZX 7 to fail but got %v", err)
+	}
+	if _, err := ParseBlock([]byte{1, 2, 3, 4}); err != ErrInvalidBlock {
+		t.Errorf("Expected too long value to fail but got %v", err)
+	}
+}
+
+func TestBlock1OptionInMessage(t *testing.T) {
+	msg := NewMessage()
+	msg.Code = POST
+	block := Block{Num: 300, More: true, SZX: 6}
+	msg.Options().Set(Block1, block.Bytes())
+
+	parsed, err := ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseBlock(parsed.Options().Get(Block1).AsBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != block {
+		t.Errorf("Expected %+v but got %+v", block, b)
+	}
+}
@@ -3,7 +3,9 @@ package coapmsg
 // https://github.com/dustin/go-coap
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"sort"
@@ -56,57 +58,61 @@ const (
 
 // Response Codes
 const (
-	Empty                 COAPCode = 0   // 0.00
-	Created               COAPCode = 65  // 2.01
-	Deleted               COAPCode = 66  // 2.02
-	Valid                 COAPCode = 67  // 2.03
-	Changed               COAPCode = 68  // 2.04
-	Content               COAPCode = 69  // 2.05
-	BadRequest            COAPCode = 128 // 4.00
-	Unauthorized          COAPCode = 129 // 4.01
-	BadOption             COAPCode = 130 // 4.02
-	Forbidden             COAPCode = 131 // 4.03
-	NotFound              COAPCode = 132 // 4.04
-	MethodNotAllowed      COAPCode = 133 // 4.05
-	NotAcceptable         COAPCode = 134 // 4.06
-	PreconditionFailed    COAPCode = 140 // 4.12
-	RequestEntityTooLarge COAPCode = 141 // 4.13
-	UnsupportedMediaType  COAPCode = 143 // 4.15
-	InternalServerError   COAPCode = 160 // 5.00
-	NotImplemented        COAPCode = 161 // 5.01
-	BadGateway            COAPCode = 162 // 5.02
-	ServiceUnavailable    COAPCode = 163 // 5.03
-	GatewayTimeout        COAPCode = 164 // 5.04
-	ProxyingNotSupported  COAPCode = 165 // 5.05
+	Empty                   COAPCode = 0   // 0.00
+	Created                 COAPCode = 65  // 2.01
+	Deleted                 COAPCode = 66  // 2.02
+	Valid                   COAPCode = 67  // 2.03
+	Changed                 COAPCode = 68  // 2.04
+	Content                 COAPCode = 69  // 2.05
+	Continue                COAPCode = 95  // 2.31
+	BadRequest              COAPCode = 128 // 4.00
+	Unauthorized            COAPCode = 129 // 4.01
+	BadOption               COAPCode = 130 // 4.02
+	Forbidden               COAPCode = 131 // 4.03
+	NotFound                COAPCode = 132 // 4.04
+	MethodNotAllowed        COAPCode = 133 // 4.05
+	NotAcceptable           COAPCode = 134 // 4.06
+	RequestEntityIncomplete COAPCode = 136 // 4.08
+	PreconditionFailed      COAPCode = 140 // 4.12
+	RequestEntityTooLarge   COAPCode = 141 // 4.13
+	UnsupportedMediaType    COAPCode = 143 // 4.15
+	InternalServerError     COAPCode = 160 // 5.00
+	NotImplemented          COAPCode = 161 // 5.01
+	BadGateway              COAPCode = 162 // 5.02
+	ServiceUnavailable      COAPCode = 163 // 5.03
+	GatewayTimeout          COAPCode = 164 // 5.04
+	ProxyingNotSupported    COAPCode = 165 // 5.05
 )
 
 var codeNames = [256]string{
-	GET:                   "GET",
-	POST:                  "POST",
-	PUT:                   "PUT",
-	DELETE:                "DELETE",
-	Empty:                 "Empty",
-	Created:               "Created",
-	Deleted:               "Deleted",
-	Valid:                 "Valid",
-	Changed:               "Changed",
-	Content:               "Content",
-	BadRequest:            "BadRequest",
-	Unauthorized:          "Unauthorized",
-	BadOption:             "BadOption",
-	Forbidden:             "Forbidden",
-	NotFound:              "NotFound",
-	MethodNotAllowed:      "MethodNotAllowed",
-	NotAcceptable:         "NotAcceptable",
-	PreconditionFailed:    "PreconditionFailed",
-	RequestEntityTooLarge: "RequestEntityTooLarge",
-	UnsupportedMediaType:  "UnsupportedMediaType",
-	InternalServerError:   "InternalServerError",
-	NotImplemented:        "NotImplemented",
-	BadGateway:            "BadGateway",
-	ServiceUnavailable:    "ServiceUnavailable",
-	GatewayTimeout:        "GatewayTimeout",
-	ProxyingNotSupported:  "ProxyingNotSupported",
+	GET:                     "GET",
+	POST:                    "POST",
+	PUT:                     "PUT",
+	DELETE:                  "DELETE",
+	Empty:                   "Empty",
+	Created:                 "Created",
+	Deleted:                 "Deleted",
+	Valid:                   "Valid",
+	Changed:                 "Changed",
+	Content:                 "Content",
+	Continue:                "Continue",
+	BadRequest:              "BadRequest",
+	Unauthorized:            "Unauthorized",
+	BadOption:               "BadOption",
+	Forbidden:               "Forbidden",
+	NotFound:                "NotFound",
+	MethodNotAllowed:        "MethodNotAllowed",
+	NotAcceptable:           "NotAcceptable",
+	RequestEntityIncomplete: "RequestEntityIncomplete",
+	PreconditionFailed:      "PreconditionFailed",
+	RequestEntityTooLarge:   "RequestEntityTooLarge",
+	UnsupportedMediaType:    "UnsupportedMediaType",
+	InternalServerError:     "InternalServerError",
+	NotImplemented:          "NotImplemented",
+	BadGateway:              "BadGateway",
+	ServiceUnavailable:      "ServiceUnavailable",
+	GatewayTimeout:          "GatewayTimeout",
+	ProxyingNotSupported:    "ProxyingNotSupported",
 }
 
 func init() {
@@ -212,6 +218,50 @@ func (m *Message) SetOptions(o CoapOptions) {
 	m.options = o
 }
 
+// Clone returns a deep copy of the message.
+// The copy shares no token, payload or option memory with m.
+func (m *Message) Clone() *Message {
+	c := &Message{
+		Type:      m.Type,
+		Code:      m.Code,
+		MessageID: m.MessageID,
+		Token:     append([]byte(nil), m.Token...),
+		Payload:   append([]byte(nil), m.Payload...),
+		options:   make(CoapOptions, len(m.options)),
+	}
+	for id, opt := range m.options {
+		values := make([]OptionValue, len(opt.values))
+		for i, v := range opt.values {
+			values[i] = OptionValue{b: append([]byte(nil), v.b...), isNil: v.isNil}
+		}
+		c.options[id] = Option{Id: opt.Id, values: values}
+	}
+	return c
+}
+
+// ForwardSafe returns a copy of the message for a proxy to forward.
+// Options that are unsafe to forward (see OptionId.UnSafe) are removed,
+// all other options are copied unchanged, including unknown ones.
+func (m *Message) ForwardSafe() *Message {
+	fwd := &Message{
+		Type:      m.Type,
+		Code:      m.Code,
+		MessageID: m.MessageID,
+		Token:     append([]byte(nil), m.Token...),
+		Payload:   append([]byte(nil), m.Payload...),
+		options:   CoapOptions{},
+	}
+	for id, opt := range m.Options() {
+		if id.UnSafe() {
+			continue
+		}
+		for _, v := range opt.values {
+			fwd.options.Add(id, append([]byte{}, v.AsBytes()...))
+		}
+	}
+	return fwd
+}
+
 // IsConfirmable returns true if this message is confirmable.
 func (m *Message) IsConfirmable() bool {
 	return m.Type == Confirmable
@@ -222,6 +272,12 @@ func (m *Message) IsNonConfirmable() bool {
 	return m.Type == NonConfirmable
 }
 
+// Size2 returns the total size of the resource representation announced
+// by the server for block-wise transfers, 0 if the option is not set.
+func (m *Message) Size2() uint32 {
+	return m.Options().Get(Size2).AsUInt32()
+}
+
 // Path gets the Path set on this message if any.
 func (m *Message) Path() []string {
 	var path []string
@@ -394,6 +450,30 @@ func ParseMessage(data []byte) (Message, error) {
 	return rv, rv.UnmarshalBinary(data)
 }
 
+// ParseHex parses a message from a hex string, e.g. pasted from a capture.
+// Whitespace and 0x prefixes are ignored, so "0x40 0x01 ..." equals "4001...".
+func ParseHex(s string) (Message, error) {
+	fields := strings.Fields(s)
+	for i, f := range fields {
+		fields[i] = strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X")
+	}
+	data, err := hex.DecodeString(strings.Join(fields, ""))
+	if err != nil {
+		return Message{}, err
+	}
+	return ParseMessage(data)
+}
+
+// ParseBase64 parses a message from a standard base64 string.
+// Whitespace is ignored.
+func ParseBase64(s string) (Message, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return Message{}, err
+	}
+	return ParseMessage(data)
+}
+
 // UnmarshalBinary parses the given binary slice as a Message.
 func (m *Message) UnmarshalBinary(data []byte) error {
 	if len(data) < 4 {
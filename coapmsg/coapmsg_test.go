@@ -94,14 +94,14 @@ func TestOptionConversionSideEffects(t *testing.T) {
 	msg.options[6].values[0].AsBytes()
 
 	opt3000 := msg.Options().Get(3000).AsUInt16()
-	if opt3000 != 0xde49 {
-		t.Errorf("Expected option:3000 = 0xde49 but got 0x%02x", opt3000)
+	if opt3000 != 0x49de {
+		t.Errorf("Expected option:3000 = 0x49de but got 0x%02x", opt3000)
 	}
 
 	mRef := &msg
 	opt3000 = mRef.Options().Get(3000).AsUInt16()
-	if opt3000 != 0xde49 {
-		t.Errorf("Expected option:3000 = 0xde49 but got 0x%02x", opt3000)
+	if opt3000 != 0x49de {
+		t.Errorf("Expected option:3000 = 0x49de but got 0x%02x", opt3000)
 	}
 
 	//opt3000Str := mRef.Options().Get(3000).String()
@@ -871,3 +871,151 @@ func TestEncodeMessageWithAllOptions(t *testing.T) {
 	}
 	assertEqualMessages(t, req, parsedMsg)
 }
+
+func TestMessageSize2(t *testing.T) {
+	msg := NewMessage()
+	if size := msg.Size2(); size != 0 {
+		t.Errorf("Expected Size2 0 when not set but got %d", size)
+	}
+	msg.Options().Set(Size2, uint32(70000))
+	if size := msg.Size2(); size != 70000 {
+		t.Errorf("Expected Size2 70000 but got %d", size)
+	}
+
+	parsed, err := ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := parsed.Size2(); size != 70000 {
+		t.Errorf("Expected parsed Size2 70000 but got %d", size)
+	}
+	if Size2.String() != "Size2" {
+		t.Errorf("Expected Size2 name but got %s", Size2.String())
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	inputs := []string{
+		"40013039210326776565746167ff6869",
+		"0x40 0x01 0x30 0x39 0x21 0x03\n0x26 0x77 0x65 0x65 0x74 0x61 0x67 0xFF 0x68 0x69",
+		"40 01 30 39 21 03 26 77 65 65 74 61 67 ff 68 69",
+	}
+
+	for _, input := range inputs {
+		msg, err := ParseHex(input)
+		if err != nil {
+			t.Fatalf("Error parsing hex %q: %v", input, err)
+		}
+		if msg.Type != Confirmable {
+			t.Errorf("Expected message type confirmable, got %v", msg.Type)
+		}
+		if msg.Code != GET {
+			t.Errorf("Expected message code GET, got %v", msg.Code)
+		}
+		if msg.MessageID != 12345 {
+			t.Errorf("Expected message ID 12345, got %v", msg.MessageID)
+		}
+		if msg.Options().Get(ETag).AsString() != "weetag" {
+			t.Errorf("Expected ETag weetag, got %v", msg.Options().Get(ETag))
+		}
+		if !bytes.Equal(msg.Payload, []byte("hi")) {
+			t.Errorf("Incorrect payload: %q", msg.Payload)
+		}
+	}
+
+	if _, err := ParseHex("40 0g"); err == nil {
+		t.Error("Expected error for invalid hex")
+	}
+}
+
+func TestParseBase64(t *testing.T) {
+	msg, err := ParseBase64("QAEwOSEDJndlZXRhZ/9oaQ==")
+	if err != nil {
+		t.Fatalf("Error parsing base64: %v", err)
+	}
+	if msg.MessageID != 12345 {
+		t.Errorf("Expected message ID 12345, got %v", msg.MessageID)
+	}
+	if !bytes.Equal(msg.Payload, []byte("hi")) {
+		t.Errorf("Incorrect payload: %q", msg.Payload)
+	}
+}
+
+func TestForwardSafe(t *testing.T) {
+	unknownElective := OptionId(2048)
+	unknownUnsafe := OptionId(2050)
+
+	msg := NewMessage()
+	msg.Type = Confirmable
+	msg.Code = Content
+	msg.MessageID = 12345
+	msg.Token = []byte{1, 2}
+	msg.Payload = []byte("hi")
+	msg.Options().Add(ETag, []byte("weetag"))
+	msg.Options().Add(URIPath, "foo")
+	msg.Options().Add(unknownElective, []byte{0xde, 0xad, 0x00})
+	msg.Options().Add(unknownElective, []byte{})
+	msg.Options().Add(unknownUnsafe, []byte{1})
+
+	// Parse and marshal must preserve unknown options byte for byte
+	data := msg.MustMarshalBinary()
+	parsed, err := ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.MustMarshalBinary(), data) {
+		t.Errorf("Expected marshalled message to equal parsed data\n%v\n%v", parsed.MustMarshalBinary(), data)
+	}
+
+	fwd := parsed.ForwardSafe()
+	if fwd.MessageID != msg.MessageID || !bytes.Equal(fwd.Token, msg.Token) || !bytes.Equal(fwd.Payload, msg.Payload) {
+		t.Errorf("Expected forwarded message to keep header and payload: %v", fwd)
+	}
+	if fwd.Options().Get(ETag).AsString() != "weetag" {
+		t.Errorf("Expected ETag weetag, got %v", fwd.Options().Get(ETag))
+	}
+	values := fwd.Options().Get(unknownElective).Values()
+	if len(values) != 2 || !bytes.Equal(values[0].AsBytes(), []byte{0xde, 0xad, 0x00}) || values[1].Len() != 0 {
+		t.Errorf("Expected unknown elective option to be forwarded unchanged, got %v", fwd.Options().Get(unknownElective))
+	}
+	if fwd.Options().Get(URIPath).IsSet() {
+		t.Error("Expected unsafe Uri-Path to be removed")
+	}
+	if fwd.Options().Get(unknownUnsafe).IsSet() {
+		t.Error("Expected unknown unsafe option to be removed")
+	}
+	if !parsed.Options().Get(URIPath).IsSet() {
+		t.Error("Expected original message to keep its options")
+	}
+
+	if _, err := ParseMessage(fwd.MustMarshalBinary()); err != nil {
+		t.Errorf("Expected forwarded message to be valid: %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	msg := NewMessage()
+	msg.Code = Content
+	msg.MessageID = 42
+	msg.Token = []byte{1, 2}
+	msg.Payload = []byte("hi")
+	msg.Options().Add(ETag, []byte("etag"))
+	msg.Options().Add(URIPath, "foo")
+
+	clone := msg.Clone()
+	if !bytes.Equal(clone.MustMarshalBinary(), msg.MustMarshalBinary()) {
+		t.Errorf("Expected clone to equal message\n%v\n%v", clone.MustMarshalBinary(), msg.MustMarshalBinary())
+	}
+
+	// Changes of the original must not affect the clone
+	msg.Token[0] = 9
+	msg.Payload[0] = 'X'
+	msg.Options().Get(ETag).Values()[0].b[0] = 'X'
+	msg.Options().Set(URIPath, "bar")
+	if clone.Token[0] != 1 || string(clone.Payload) != "hi" {
+		t.Errorf("Expected token and payload to be copied: %v", clone)
+	}
+	if clone.Options().Get(ETag).AsString() != "etag" || clone.Options().Get(URIPath).AsString() != "foo" {
+		t.Errorf("Expected options to be copied: %v", clone.Options())
+	}
+}
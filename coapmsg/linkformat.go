@@ -0,0 +1,136 @@
+package coapmsg
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidLinkFormat = errors.New("invalid link format")
+
+// Link is one link of the CoRE Link Format (RFC 6690), e.g. the
+// resources listed by /.well-known/core
+type Link struct {
+	URI    string            // URI reference between < and >
+	Params map[string]string // Link params, params without value map to ""
+}
+
+// ParseLinkFormat parses an application/link-format payload.
+// Quoted param values may contain commas and semicolons,
+// empty links (e.g. a trailing comma) are skipped.
+func ParseLinkFormat(data []byte) ([]Link, error) {
+	p := linkParser{s: string(data)}
+	links := make([]Link, 0)
+	for {
+		p.skipSpace()
+		if p.done() {
+			return links, nil
+		}
+		if p.peek() == ',' {
+			p.i++
+			continue
+		}
+		link, err := p.link()
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+
+		p.skipSpace()
+		if !p.done() && p.peek() != ',' {
+			return nil, ErrInvalidLinkFormat
+		}
+	}
+}
+
+type linkParser struct {
+	s string
+	i int
+}
+
+func (p *linkParser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *linkParser) peek() byte {
+	return p.s[p.i]
+}
+
+func (p *linkParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r' || p.peek() == '\n') {
+		p.i++
+	}
+}
+
+// link parses <URI> followed by any number of ;params
+func (p *linkParser) link() (Link, error) {
+	if p.peek() != '<' {
+		return Link{}, ErrInvalidLinkFormat
+	}
+	end := strings.IndexByte(p.s[p.i:], '>')
+	if end < 0 {
+		return Link{}, ErrInvalidLinkFormat
+	}
+	link := Link{
+		URI:    p.s[p.i+1 : p.i+end],
+		Params: make(map[string]string),
+	}
+	p.i += end + 1
+
+	for {
+		p.skipSpace()
+		if p.done() || p.peek() != ';' {
+			return link, nil
+		}
+		p.i++
+		p.skipSpace()
+
+		name := p.token()
+		if name == "" {
+			return Link{}, ErrInvalidLinkFormat
+		}
+		p.skipSpace()
+		value := ""
+		if !p.done() && p.peek() == '=' {
+			p.i++
+			p.skipSpace()
+			var err error
+			if value, err = p.value(); err != nil {
+				return Link{}, err
+			}
+		}
+		link.Params[name] = value
+	}
+}
+
+// token reads up to the next separator
+func (p *linkParser) token() string {
+	start := p.i
+	for !p.done() && !strings.ContainsRune(";,= \t\r\n", rune(p.peek())) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// value reads a quoted string or a token
+func (p *linkParser) value() (string, error) {
+	if p.done() || p.peek() != '"' {
+		return p.token(), nil
+	}
+	p.i++
+
+	var value []byte
+	for !p.done() {
+		c := p.peek()
+		p.i++
+		switch {
+		case c == '"':
+			return string(value), nil
+		case c == '\\' && !p.done():
+			value = append(value, p.peek())
+			p.i++
+		default:
+			value = append(value, c)
+		}
+	}
+	return "", ErrInvalidLinkFormat
+}
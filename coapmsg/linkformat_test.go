@@ -0,0 +1,63 @@
+package coapmsg
+
+import "testing"
+
+func TestParseLinkFormat(t *testing.T) {
+	// Payload of the liblobarocoap server
+	links, err := ParseLinkFormat([]byte(`<.well-known/core/>,<existing/>;title="Some existing endpoint";cf=0,`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("Expected 2 links but got %+v", links)
+	}
+	if links[0].URI != ".well-known/core/" || len(links[0].Params) != 0 {
+		t.Errorf("Unexpected link %+v", links[0])
+	}
+	if links[1].URI != "existing/" {
+		t.Errorf("Expected URI existing/ but got %s", links[1].URI)
+	}
+	if title := links[1].Params["title"]; title != "Some existing endpoint" {
+		t.Errorf("Expected title but got %q", title)
+	}
+	if cf := links[1].Params["cf"]; cf != "0" {
+		t.Errorf("Expected cf 0 but got %q", cf)
+	}
+}
+
+func TestParseLinkFormatQuotedSeparators(t *testing.T) {
+	links, err := ParseLinkFormat([]byte(`</sensors/temp>;rt="temperature-c";if="sensor";obs;title="a, b; \"c\"", </actuators/led>;ct=0`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("Expected 2 links but got %+v", links)
+	}
+	params := links[0].Params
+	if params["rt"] != "temperature-c" || params["if"] != "sensor" {
+		t.Errorf("Unexpected params %+v", params)
+	}
+	if v, ok := params["obs"]; !ok || v != "" {
+		t.Errorf("Expected valueless obs param but got %+v", params)
+	}
+	if params["title"] != `a, b; "c"` {
+		t.Errorf("Unexpected title %q", params["title"])
+	}
+	if links[1].URI != "/actuators/led" || links[1].Params["ct"] != "0" {
+		t.Errorf("Unexpected link %+v", links[1])
+	}
+}
+
+func TestParseLinkFormatInvalid(t *testing.T) {
+	for _, payload := range []string{
+		`/missing-brackets`,
+		`</unterminated`,
+		`</a>;title="unterminated`,
+		`</a> </b>`,
+		`</a>;=value`,
+	} {
+		if _, err := ParseLinkFormat([]byte(payload)); err != ErrInvalidLinkFormat {
+			t.Errorf("Expected ErrInvalidLinkFormat for %s but got %v", payload, err)
+		}
+	}
+}
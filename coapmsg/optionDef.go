@@ -58,11 +58,33 @@ var optionDefs = map[OptionId]OptionDef{
 	LocationPath:  {Format: ValueString, MinLength: 0, MaxLength: 255},
 	URIPath:       {Format: ValueString, MinLength: 0, MaxLength: 255},
 	ContentFormat: {Format: ValueUint, MinLength: 0, MaxLength: 2},
-	MaxAge:        {Format: ValueUint, MinLength: 0, MaxLength: 4},
+	MaxAge:        {Format: ValueUint, MinLength: 0, MaxLength: 4, DefaultValue: []byte{60}}, // 60 seconds
 	URIQuery:      {Format: ValueString, MinLength: 0, MaxLength: 255},
 	Accept:        {Format: ValueUint, MinLength: 0, MaxLength: 2},
 	LocationQuery: {Format: ValueString, MinLength: 0, MaxLength: 255},
+	Block2:        {Format: ValueUint, MinLength: 0, MaxLength: 3},
+	Block1:        {Format: ValueUint, MinLength: 0, MaxLength: 3},
+	Size2:         {Format: ValueUint, MinLength: 0, MaxLength: 4},
 	ProxyURI:      {Format: ValueString, MinLength: 1, MaxLength: 1034},
 	ProxyScheme:   {Format: ValueString, MinLength: 1, MaxLength: 255},
 	Size1:         {Format: ValueUint, MinLength: 0, MaxLength: 4},
+	NoResponse:    {Format: ValueUint, MinLength: 0, MaxLength: 1},
+}
+
+// DefaultValue returns the value that applies when the option is absent, nil if there is none
+func (o OptionId) DefaultValue() []byte {
+	return optionDefs[o].DefaultValue
+}
+
+// ValidLength returns true if a value of n bytes is allowed for the option.
+// Unknown options accept any length.
+func (o OptionId) ValidLength(n int) bool {
+	def, ok := optionDefs[o]
+	return !ok || (n >= def.MinLength && n <= def.MaxLength)
+}
+
+// Known returns true if the option is defined in the option registry
+func (o OptionId) Known() bool {
+	_, ok := optionDefs[o]
+	return ok
 }
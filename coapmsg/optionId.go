@@ -21,9 +21,13 @@ type OptionId uint16
    |  15 | x  | x | - | x | Uri-Query      | string | 0-255  | (none)  |
    |  17 | x  |   |   |   | Accept         | uint   | 0-2    | (none)  |
    |  20 |    |   |   | x | Location-Query | string | 0-255  | (none)  |
+   |  23 | x  | x | - | - | Block2         | uint   | 0-3    | (none)  |
+   |  27 | x  | x | - | - | Block1         | uint   | 0-3    | (none)  |
+   |  28 |    |   | x |   | Size2          | uint   | 0-4    | (none)  |
    |  35 | x  | x | - |   | Proxy-Uri      | string | 1-1034 | (none)  |
    |  39 | x  | x | - |   | Proxy-Scheme   | string | 1-255  | (none)  |
    |  60 |    |   | x |   | Size1          | uint   | 0-4    | (none)  |
+   | 258 |    | x | - |   | No-Response    | uint   | 0-1    | 0       |
    +-----+----+---+---+---+----------------+--------+--------+---------+
    C=Critical, U=Unsafe, N=NoCacheKey, R=Repeatable
 */
@@ -44,9 +48,22 @@ const (
 	URIQuery      OptionId = 15
 	Accept        OptionId = 17
 	LocationQuery OptionId = 20
+	Block2        OptionId = 23
+	Block1        OptionId = 27
+	Size2         OptionId = 28
 	ProxyURI      OptionId = 35
 	ProxyScheme   OptionId = 39
 	Size1         OptionId = 60
+	NoResponse    OptionId = 258 // RFC 7967
+)
+
+// Response classes of the No-Response option (RFC 7967, 2.1).
+// Combine them to suppress several classes.
+const (
+	NoResponse2xx uint8 = 2
+	NoResponse4xx uint8 = 8
+	NoResponse5xx uint8 = 16
+	NoResponseAll       = NoResponse2xx | NoResponse4xx | NoResponse5xx
 )
 
 func (o OptionId) Critical() bool {
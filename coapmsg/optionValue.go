@@ -62,6 +62,22 @@ func (o Option) AsUInt64() uint64 {
 	return 0
 }
 
+// In case of multiple option values it returns the first
+func (o Option) AsInt() int {
+	if len(o.values) > 0 {
+		return o.values[0].AsInt()
+	}
+	return 0
+}
+
+// In case of multiple option values it returns the first
+func (o Option) AsInt64() int64 {
+	if len(o.values) > 0 {
+		return o.values[0].AsInt64()
+	}
+	return 0
+}
+
 // In case of multiple option values it returns the first
 func (o Option) AsBytes() []byte {
 	if len(o.values) > 0 {
@@ -70,6 +86,13 @@ func (o Option) AsBytes() []byte {
 	return []byte{}
 }
 
+// Values returns all values of the option in order
+func (o Option) Values() []OptionValue {
+	values := make([]OptionValue, len(o.values))
+	copy(values, o.values)
+	return values
+}
+
 func (o Option) IsNotSet() bool {
 	return !o.IsSet()
 }
@@ -99,44 +122,54 @@ func (o Option) String() string {
 
 var NilOptionValue OptionValue = OptionValue{isNil: true}
 
+// AsUInt8 is like AsUInt64 but truncated to 8 bit.
 // For signed values just convert the result
 func (v OptionValue) AsUInt8() uint8 {
-	if len(v.b) == 0 {
-		return 0
-	}
-	return v.b[0]
+	return uint8(v.AsUInt64())
 }
 
+// AsUInt16 is like AsUInt64 but truncated to 16 bit.
 // For signed values just convert the result
 func (v OptionValue) AsUInt16() uint16 {
-	if len(v.b) == 0 {
-		return 0
-	}
-
-	buf := make([]byte, 2)
-	copy(buf, v.b)
-	return binary.LittleEndian.Uint16(buf)
+	return uint16(v.AsUInt64())
 }
 
+// AsUInt32 is like AsUInt64 but truncated to 32 bit.
 // For signed values just convert the result
 func (v OptionValue) AsUInt32() uint32 {
-	if len(v.b) == 0 {
-		return 0
-	}
-	buf := make([]byte, 4)
-	copy(buf, v.b)
-	return binary.LittleEndian.Uint32(buf)
+	return uint32(v.AsUInt64())
 }
 
-// For signed values just convert the result
+// AsUInt64 decodes the value in network byte order (RFC 7252, 3.2).
+// Values use the minimal length, e.g. a 3 byte Observe value.
+// For signed values see AsInt64
 func (v OptionValue) AsUInt64() uint64 {
+	var i uint64
+	for _, c := range v.b {
+		i = i<<8 | uint64(c)
+	}
+	return i
+}
+
+// AsInt64 decodes a signed two's complement value in network byte order.
+// The sign is taken from the first byte, so 0xFF is -1 and 0x00FF is 255.
+func (v OptionValue) AsInt64() int64 {
 	if len(v.b) == 0 {
 		return 0
 	}
+	var i int64
+	if v.b[0]&0x80 != 0 {
+		i = -1 // Sign extend from the encoded length
+	}
+	for _, c := range v.b {
+		i = i<<8 | int64(c)
+	}
+	return i
+}
 
-	buf := make([]byte, 8)
-	copy(buf, v.b)
-	return binary.LittleEndian.Uint64(buf)
+// AsInt is like AsInt64, see there
+func (v OptionValue) AsInt() int {
+	return int(v.AsInt64())
 }
 
 func (v OptionValue) AsString() string {
@@ -226,6 +259,41 @@ func (h CoapOptions) Clear() {
 	}
 }
 
+// IfMatchAny returns true if an empty If-Match option is set.
+// An empty If-Match matches any existing representation (RFC 7252, 5.10.8.1).
+func (h CoapOptions) IfMatchAny() bool {
+	for _, v := range h[IfMatch].values {
+		if v.Len() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIfMatchAny adds an empty If-Match option if not already set.
+// Existing ETags of the If-Match option are kept.
+func (h CoapOptions) SetIfMatchAny() {
+	if h.IfMatchAny() {
+		return
+	}
+	opt := h[IfMatch]
+	opt.Id = IfMatch
+	opt.values = append(opt.values, OptionValue{[]byte{}, false})
+	h[IfMatch] = opt
+}
+
+// IfMatchETags returns the ETags of the If-Match option.
+// The empty match-any value is not included.
+func (h CoapOptions) IfMatchETags() [][]byte {
+	etags := [][]byte{}
+	for _, v := range h[IfMatch].values {
+		if v.Len() > 0 {
+			etags = append(etags, v.AsBytes())
+		}
+	}
+	return etags
+}
+
 func encodeInt(v uint32) []byte {
 	switch {
 	case v == 0:
@@ -259,6 +327,8 @@ func optionValueToBytes(optVal interface{}) ([]byte, error) {
 		v = uint32(i)
 	case int:
 		v = uint32(i)
+	case uint8:
+		v = uint32(i)
 	case int16:
 		v = uint32(i)
 	case int32:
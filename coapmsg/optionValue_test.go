@@ -143,3 +143,120 @@ func _TestFindNumbers(t *testing.T) {
 		t.Log(fmt.Sprint(id, ": ", id.Critical(), "\t", id.UnSafe(), "\t", id.NoCacheKey()))
 	}
 }
+
+func TestIfMatchAnyIsPreservedByParsing(t *testing.T) {
+	msg := NewMessage()
+	msg.Type = Confirmable
+	msg.Code = PUT
+	msg.Options().SetIfMatchAny()
+
+	parsed, err := ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Options().IfMatchAny() {
+		t.Error("Expected If-Match match-any to be set")
+	}
+	if len(parsed.Options().IfMatchETags()) != 0 {
+		t.Errorf("Expected no If-Match ETags but got %v", parsed.Options().IfMatchETags())
+	}
+}
+
+func TestIfMatchETagIsNotMatchAny(t *testing.T) {
+	msg := NewMessage()
+	msg.Type = Confirmable
+	msg.Code = PUT
+	msg.Options().Add(IfMatch, []byte{0xab, 0xcd})
+
+	parsed, err := ParseMessage(msg.MustMarshalBinary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Options().IfMatchAny() {
+		t.Error("Expected If-Match match-any not to be set")
+	}
+	etags := parsed.Options().IfMatchETags()
+	if len(etags) != 1 || fmt.Sprint(etags[0]) != fmt.Sprint([]byte{0xab, 0xcd}) {
+		t.Errorf("Expected If-Match ETag [171 205] but got %v", etags)
+	}
+
+	parsed.Options().SetIfMatchAny()
+	parsed.Options().SetIfMatchAny()
+	if parsed.Options().Get(IfMatch).Len() != 2 {
+		t.Errorf("Expected ETag and a single match-any value but got %d values", parsed.Options().Get(IfMatch).Len())
+	}
+}
+
+func TestAsIntSignExtends(t *testing.T) {
+	tests := []struct {
+		b        []byte
+		expected int64
+	}{
+		{nil, 0},
+		{[]byte{0x7F}, 127},
+		{[]byte{0xFF}, -1},
+		{[]byte{0x80}, -128},
+		{[]byte{0x00, 0xFF}, 255},
+		{[]byte{0xFF, 0x7F}, -129},
+		{[]byte{0x80, 0x00, 0x00}, -8388608},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFE}, -2},
+		{[]byte{0x80, 0, 0, 0, 0, 0, 0, 0}, -9223372036854775808},
+	}
+	for _, test := range tests {
+		opts := CoapOptions{}
+		opts.Set(3000, test.b)
+		if i := opts.Get(3000).AsInt64(); i != test.expected {
+			t.Errorf("Expected AsInt64 of %#v to be %d but got %d", test.b, test.expected, i)
+		}
+	}
+}
+
+func TestAsIntRoundTrip(t *testing.T) {
+	for _, v := range []int{-1, -1000, -70000, 42} {
+		opts := CoapOptions{}
+		if err := opts.Set(3000, v); err != nil {
+			t.Fatal(err)
+		}
+		if i := opts.Get(3000).AsInt(); i != v {
+			t.Errorf("Expected %d but got %d", v, i)
+		}
+	}
+}
+
+func TestUIntRoundTrip(t *testing.T) {
+	tests := []struct {
+		id     OptionId
+		v      uint32
+		length int
+	}{
+		{Observe, 0x0102, 2},
+		{Observe, 0x010203, 3},
+		{Size1, 0x0102, 2},
+		{Size1, 0x010203, 3},
+		{Size1, 0x01020304, 4},
+	}
+	for _, test := range tests {
+		msg := NewMessage()
+		if err := msg.Options().Set(test.id, test.v); err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseMessage(msg.MustMarshalBinary())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt := parsed.Options().Get(test.id)
+		if len(opt.AsBytes()) != test.length {
+			t.Errorf("Expected %v to be encoded in %d bytes but got %#v", test.id, test.length, opt.AsBytes())
+		}
+		if opt.AsUInt32() != test.v {
+			t.Errorf("Expected %v AsUInt32 = 0x%x but got 0x%x", test.id, test.v, opt.AsUInt32())
+		}
+		if opt.AsUInt64() != uint64(test.v) {
+			t.Errorf("Expected %v AsUInt64 = 0x%x but got 0x%x", test.id, test.v, opt.AsUInt64())
+		}
+		if opt.AsUInt16() != uint16(test.v) {
+			t.Errorf("Expected %v AsUInt16 = 0x%x but got 0x%x", test.id, uint16(test.v), opt.AsUInt16())
+		}
+	}
+}
@@ -5,27 +5,33 @@ package coapmsg
 import "fmt"
 
 const (
-	_OptionId_name_0 = "IfMatch"
-	_OptionId_name_1 = "URIHostETagIfNoneMatchObserveURIPortLocationPath"
-	_OptionId_name_2 = "URIPathContentFormat"
-	_OptionId_name_3 = "MaxAgeURIQuery"
-	_OptionId_name_4 = "Accept"
-	_OptionId_name_5 = "LocationQuery"
-	_OptionId_name_6 = "ProxyURI"
-	_OptionId_name_7 = "ProxyScheme"
-	_OptionId_name_8 = "Size1"
+	_OptionId_name_0  = "IfMatch"
+	_OptionId_name_1  = "URIHostETagIfNoneMatchObserveURIPortLocationPath"
+	_OptionId_name_2  = "URIPathContentFormat"
+	_OptionId_name_3  = "MaxAgeURIQuery"
+	_OptionId_name_4  = "Accept"
+	_OptionId_name_5  = "LocationQuery"
+	_OptionId_name_6  = "Block2"
+	_OptionId_name_7  = "Block1Size2"
+	_OptionId_name_8  = "ProxyURI"
+	_OptionId_name_9  = "ProxyScheme"
+	_OptionId_name_10 = "Size1"
+	_OptionId_name_11 = "NoResponse"
 )
 
 var (
-	_OptionId_index_0 = [...]uint8{0, 7}
-	_OptionId_index_1 = [...]uint8{0, 7, 11, 22, 29, 36, 48}
-	_OptionId_index_2 = [...]uint8{0, 7, 20}
-	_OptionId_index_3 = [...]uint8{0, 6, 14}
-	_OptionId_index_4 = [...]uint8{0, 6}
-	_OptionId_index_5 = [...]uint8{0, 13}
-	_OptionId_index_6 = [...]uint8{0, 8}
-	_OptionId_index_7 = [...]uint8{0, 11}
-	_OptionId_index_8 = [...]uint8{0, 5}
+	_OptionId_index_0  = [...]uint8{0, 7}
+	_OptionId_index_1  = [...]uint8{0, 7, 11, 22, 29, 36, 48}
+	_OptionId_index_2  = [...]uint8{0, 7, 20}
+	_OptionId_index_3  = [...]uint8{0, 6, 14}
+	_OptionId_index_4  = [...]uint8{0, 6}
+	_OptionId_index_5  = [...]uint8{0, 13}
+	_OptionId_index_6  = [...]uint8{0, 6}
+	_OptionId_index_7  = [...]uint8{0, 6, 11}
+	_OptionId_index_8  = [...]uint8{0, 8}
+	_OptionId_index_9  = [...]uint8{0, 11}
+	_OptionId_index_10 = [...]uint8{0, 5}
+	_OptionId_index_11 = [...]uint8{0, 10}
 )
 
 func (i OptionId) String() string {
@@ -45,12 +51,19 @@ func (i OptionId) String() string {
 		return _OptionId_name_4
 	case i == 20:
 		return _OptionId_name_5
-	case i == 35:
+	case i == 23:
 		return _OptionId_name_6
+	case 27 <= i && i <= 28:
+		i -= 27
+		return _OptionId_name_7[_OptionId_index_7[i]:_OptionId_index_7[i+1]]
+	case i == 35:
+		return _OptionId_name_8
 	case i == 39:
-		return _OptionId_name_7
+		return _OptionId_name_9
 	case i == 60:
-		return _OptionId_name_8
+		return _OptionId_name_10
+	case i == 258:
+		return _OptionId_name_11
 	default:
 		return fmt.Sprintf("OptionId(%d)", i)
 	}
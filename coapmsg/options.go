@@ -18,6 +18,11 @@ const (
 	AppJSON       MediaType = 50 // application/json
 )
 
+// Ptr returns a pointer to a copy of t, e.g. for optional fields like Request.ContentFormat
+func (t MediaType) Ptr() *MediaType {
+	return &t
+}
+
 type optionsIds []OptionId
 
 // Len implements sort.Interface
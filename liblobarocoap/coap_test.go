@@ -1,6 +1,8 @@
 package liblobarocoap
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/lobaro/coap-go/coapmsg"
 	"testing"
 	"time"
@@ -99,6 +101,99 @@ func TestHandle_QueryWellKnown(t *testing.T) {
 	<-time.After(10 * time.Millisecond)
 }
 
+func TestHandle_QueryWellKnownJSON(t *testing.T) {
+	socket := NewSocket()
+	if resource := CreateResource("/json-endpoint", "Some JSON endpoint"); resource == nil {
+		t.Fatal("Resource is nil")
+	}
+	getMsg := coapmsg.Message{
+		Type:      coapmsg.Confirmable,
+		Code:      coapmsg.GET,
+		MessageID: 2,
+		Token:     []byte{1, 2},
+	}
+	getMsg.SetPathString("/.well-known/core")
+	getMsg.Options().Set(coapmsg.Accept, uint16(AppLinkFormatJSON))
+
+	msgBytes, err := getMsg.MarshalBinary()
+	if err != nil {
+		t.Fatal("Failed to marshal CoAP message")
+	}
+
+	HandleIncomingUartPacket(socket, 12, msgBytes)
+
+	select {
+	case ack := <-PendingResponses:
+		ackMsg, err := coapmsg.ParseMessage(ack.Data)
+		if err != nil {
+			t.Fatal("Failed to parse CoAP message", err)
+		}
+		if ackMsg.Type != coapmsg.Acknowledgement {
+			t.Error("Expected message type to be ack but was", ackMsg.Type)
+		}
+		if ackMsg.Code != coapmsg.Content {
+			t.Error("Expected message code to be Content but was", ackMsg.Code.String())
+		}
+		if cf := ackMsg.Options().Get(coapmsg.ContentFormat).AsUInt16(); cf != AppLinkFormatJSON {
+			t.Error("Expected content format", AppLinkFormatJSON, "but was", cf)
+		}
+		if ack.RemoteEp.Type != EP_UART || ack.RemoteEp.ComPort != 12 {
+			t.Error("Expected response to com port 12 but got", ack.RemoteEp)
+		}
+
+		var links []map[string]string
+		if err := json.Unmarshal(ackMsg.Payload, &links); err != nil {
+			t.Fatal("Failed to parse payload", string(ackMsg.Payload), err)
+		}
+		expected := map[string]string{"href": "/json-endpoint", "title": "Some JSON endpoint", "cf": "0"}
+		found := false
+		for _, l := range links {
+			if fmt.Sprint(l) == fmt.Sprint(expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("Expected link", expected, "in payload", string(ackMsg.Payload))
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("No response")
+	}
+}
+
+func TestHandle_QueryWellKnownNotAcceptable(t *testing.T) {
+	socket := NewSocket()
+	getMsg := coapmsg.Message{
+		Type:      coapmsg.Confirmable,
+		Code:      coapmsg.GET,
+		MessageID: 3,
+	}
+	getMsg.SetPathString("/.well-known/core")
+	getMsg.Options().Set(coapmsg.Accept, coapmsg.AppXML)
+
+	msgBytes, err := getMsg.MarshalBinary()
+	if err != nil {
+		t.Fatal("Failed to marshal CoAP message")
+	}
+
+	HandleIncomingUartPacket(socket, 12, msgBytes)
+
+	select {
+	case ack := <-PendingResponses:
+		ackMsg, err := coapmsg.ParseMessage(ack.Data)
+		if err != nil {
+			t.Fatal("Failed to parse CoAP message", err)
+		}
+		if ackMsg.Code != coapmsg.NotAcceptable {
+			t.Error("Expected message code to be NotAcceptable but was", ackMsg.Code.String())
+		}
+		if ackMsg.MessageID != uint16(3) {
+			t.Error("Expected message id to be 3 but was", ackMsg.MessageID)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("No response")
+	}
+}
+
 func TestHandle_QueryCustomNonPiggyResource(t *testing.T) {
 	socket := NewSocket()
 	resource := CreateResource("/my-resource", "My Test Resource", coapmsg.GET)
@@ -182,3 +277,201 @@ func TestHandle_QueryCustomNonPiggyResource(t *testing.T) {
 	// Just wait for remaining C log output :)
 	<-time.After(10 * time.Millisecond)
 }
+
+func TestShutdownAndReinit(t *testing.T) {
+	if resource := CreateResource("/before-shutdown", "Resource before shutdown"); resource == nil {
+		t.Fatal("Resource is nil")
+	}
+
+	Shutdown()
+
+	if coapMemory != nil {
+		t.Error("Expected stack memory to be freed")
+	}
+	if len(cStrings) != 0 {
+		t.Error("Expected all C strings to be freed but got", len(cStrings))
+	}
+	if len(resources) != 0 {
+		t.Error("Expected no resources after shutdown but got", len(resources))
+	}
+
+	// A second shutdown must not free anything twice
+	Shutdown()
+
+	Init()
+	defer func() {
+		// Leave an initialized stack for the other tests
+		Shutdown()
+		Init()
+	}()
+
+	if resource := CreateResource("/after-shutdown", "Resource after shutdown"); resource == nil {
+		t.Fatal("Resource is nil")
+	}
+
+	socket := NewSocket()
+	pingMsg := coapmsg.Message{
+		Type: coapmsg.Confirmable,
+		Code: coapmsg.COAPCode(0),
+	}
+	msgBytes, err := pingMsg.MarshalBinary()
+	if err != nil {
+		t.Fatal("Failed to marshal CoAP message")
+	}
+
+	HandleIncomingUartPacket(socket, 10, msgBytes)
+
+	select {
+	case response := <-PendingResponses:
+		pongMsg, err := coapmsg.ParseMessage(response.Data)
+		if err != nil {
+			t.Error("Failed to parse CoAP message", err)
+		}
+		if pongMsg.Type != coapmsg.Reset {
+			t.Error("Expected type coapmsg.Reset but got", pongMsg.Type)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("No response after re-init")
+	}
+}
+
+func TestConfigureMemorySize(t *testing.T) {
+	if err := Configure(MinMemorySize - 1); err == nil {
+		t.Error("Expected error for too small memory size")
+	}
+	if err := Configure(MaxMemorySize + 1); err == nil {
+		t.Error("Expected error for too large memory size")
+	}
+
+	// Other tests already created sockets and resources
+	NewSocket()
+	if err := Configure(2 * DefaultMemorySize); err == nil {
+		t.Error("Expected error when configuring a stack in use")
+	}
+
+	Shutdown()
+	defer func() {
+		Shutdown()
+		if err := Configure(DefaultMemorySize); err != nil {
+			t.Error(err)
+		}
+		Init()
+	}()
+
+	if err := Configure(MaxMemorySize); err != nil {
+		t.Fatal(err)
+	}
+	Init()
+
+	// Does not fit into DefaultMemorySize
+	for i := 0; i < 200; i++ {
+		uri := fmt.Sprintf("/resource-%d", i)
+		if resource := CreateResource(uri, "Resource "+uri); resource == nil {
+			t.Fatal("Failed to create resource", i)
+		}
+	}
+}
+
+func TestResources(t *testing.T) {
+	Shutdown()
+	Init()
+
+	CreateResource("/sensors/temp", "Temperature", coapmsg.GET)
+	CreateResource("/actuators/led", "LED", coapmsg.GET, coapmsg.PUT)
+
+	infos := Resources()
+	if len(infos) != 2 {
+		t.Fatal("Expected 2 resources but got", infos)
+	}
+
+	led := infos[0]
+	if led.URI != "/actuators/led" || led.Description != "LED" {
+		t.Error("Unexpected resource", led)
+	}
+	if len(led.AllowedMethods) != 2 || led.AllowedMethods[0] != coapmsg.GET || led.AllowedMethods[1] != coapmsg.PUT {
+		t.Error("Expected methods GET and PUT but got", led.AllowedMethods)
+	}
+
+	temp := infos[1]
+	if temp.URI != "/sensors/temp" || temp.Description != "Temperature" {
+		t.Error("Unexpected resource", temp)
+	}
+	if len(temp.AllowedMethods) != 1 || temp.AllowedMethods[0] != coapmsg.GET {
+		t.Error("Expected method GET but got", temp.AllowedMethods)
+	}
+	if temp.ContentFormat != coapmsg.TextPlain {
+		t.Error("Expected content format text/plain but got", temp.ContentFormat)
+	}
+}
+
+func TestHandle_QuerySubtreeResource(t *testing.T) {
+	socket := NewSocket()
+	resource := CreateSubtreeResource("/sensors", "Sensors", coapmsg.GET)
+	if resource == nil {
+		t.Fatal("Resource is nil")
+	}
+	resource.Handler = func(req coapmsg.Message, res *coapmsg.Message) HandlerResult {
+		res.Payload = []byte("sensor " + resource.Suffix(req))
+		res.Code = coapmsg.Content
+		return OK
+	}
+
+	getMsg := coapmsg.Message{
+		Type:      coapmsg.Confirmable,
+		Code:      coapmsg.GET,
+		MessageID: 4,
+		Token:     []byte{4},
+	}
+	getMsg.SetPathString("/sensors/42")
+
+	msgBytes, err := getMsg.MarshalBinary()
+	if err != nil {
+		t.Fatal("Failed to marshal CoAP message")
+	}
+
+	HandleIncomingUartPacket(socket, 13, msgBytes)
+
+	select {
+	case ack := <-PendingResponses:
+		ackMsg, err := coapmsg.ParseMessage(ack.Data)
+		if err != nil {
+			t.Fatal("Failed to parse CoAP message", err)
+		}
+		if ackMsg.Type != coapmsg.Acknowledgement {
+			t.Error("Expected message type to be ack but was", ackMsg.Type)
+		}
+		if ackMsg.Code != coapmsg.Content {
+			t.Error("Expected message code to be Content but was", ackMsg.Code.String())
+		}
+		expectedPayload := "sensor 42"
+		if string(ackMsg.Payload) != expectedPayload {
+			t.Error("Expected message payload to be", expectedPayload, "but was", string(ackMsg.Payload))
+		}
+		if ackMsg.MessageID != uint16(4) {
+			t.Error("Expected message id to be 4 but was", ackMsg.MessageID)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("No response")
+	}
+}
+
+func TestSubtreeExactMatchTakesPrecedence(t *testing.T) {
+	if resource := CreateSubtreeResource("/devices", "Devices", coapmsg.GET); resource == nil {
+		t.Fatal("Resource is nil")
+	}
+	exact := CreateResource("/devices/gateway", "Gateway", coapmsg.GET)
+	if exact == nil {
+		t.Fatal("Resource is nil")
+	}
+
+	req := coapmsg.Message{Code: coapmsg.GET}
+	req.SetPathString("/devices/gateway")
+	if handleSubtree(NewSocket(), CoapEndpoint{Type: EP_UART}, req.MustMarshalBinary()) {
+		t.Error("Expected the exact resource to be handled by the C stack")
+	}
+
+	req.SetPathString("/devices/gateway/status")
+	if r := findSubtree(req.Path()); r == nil || r.uri != "devices" {
+		t.Error("Expected the /devices subtree but got", r)
+	}
+}
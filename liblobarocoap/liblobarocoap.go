@@ -28,11 +28,14 @@ static inline CoAP_Socket_t* CreateSocket(SocketHandle_t handle) {
 import "C"
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/lobaro/coap-go/coapmsg"
 	"github.com/sirupsen/logrus"
 	"log"
+	"math"
 	"net"
+	"sort"
 	"time"
 	"unsafe"
 )
@@ -50,8 +53,40 @@ const (
 )
 
 type Resource struct {
-	ref     unsafe.Pointer // type *C.struct_CoAP_Res
-	Handler func(req coapmsg.Message, res *coapmsg.Message) HandlerResult
+	ref            unsafe.Pointer // type *C.struct_CoAP_Res
+	uri            string
+	description    string
+	allowedMethods []coapmsg.COAPCode
+	subtree        bool // Handles all paths below uri as well
+	Handler        func(req coapmsg.Message, res *coapmsg.Message) HandlerResult
+}
+
+// ResourceInfo describes a resource registered with CreateResource
+type ResourceInfo struct {
+	URI            string
+	Description    string
+	AllowedMethods []coapmsg.COAPCode
+	ContentFormat  coapmsg.MediaType
+	Subtree        bool // Created by CreateSubtreeResource
+}
+
+// Resources returns a snapshot of all registered resources sorted by URI
+func Resources() []ResourceInfo {
+	infos := make([]ResourceInfo, 0, len(resources))
+	for _, r := range resources {
+		// The C stack registers all resources with the default content format
+		infos = append(infos, ResourceInfo{
+			URI:            "/" + r.uri,
+			Description:    r.description,
+			AllowedMethods: append([]coapmsg.COAPCode(nil), r.allowedMethods...),
+			ContentFormat:  coapmsg.TextPlain,
+			Subtree:        r.subtree,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].URI < infos[j].URI
+	})
+	return infos
 }
 
 type Socket struct {
@@ -82,10 +117,62 @@ type CoapEndpoint struct {
 
 var PendingResponses = make(chan Packet, 50)
 
-var coapMemory = make([]byte, 4096)
+const (
+	// DefaultMemorySize is the memory of the C stack in bytes if Configure is not called
+	DefaultMemorySize = 4096
+	MinMemorySize     = 1024
+	MaxMemorySize     = math.MaxInt16 // Limited by CoAP_Config_t.MemorySize
+)
+
+var coapMemorySize = DefaultMemorySize
+
+// coapMemory is the C allocated memory used by the C stack
+var coapMemory unsafe.Pointer
+
+// cStrings are passed to the C stack and freed on Shutdown
+var cStrings []unsafe.Pointer
+
+var stopWork chan struct{}
+var workDone chan struct{}
+
+// inUse is set when sockets or resources are created on the current stack
+var inUse bool
 
 func init() {
-	log.Println("Allocated go memory at", unsafe.Pointer(&coapMemory))
+	Init()
+}
+
+// Configure sets the size of the memory used by the C stack in bytes.
+// More resources and observers need more memory.
+//
+// It must be called before any socket or resource is created,
+// else the stack must be shut down first, see Shutdown.
+func Configure(memorySize int) error {
+	if memorySize < MinMemorySize || memorySize > MaxMemorySize {
+		return fmt.Errorf("liblobarocoap: memory size must be between %d and %d bytes but is %d", MinMemorySize, MaxMemorySize, memorySize)
+	}
+	if inUse {
+		return errors.New("liblobarocoap: memory size can not be changed after sockets or resources are created")
+	}
+
+	running := stopWork != nil
+	Shutdown()
+	coapMemorySize = memorySize
+	if running {
+		Init()
+	}
+	return nil
+}
+
+// Init initializes the C stack and starts the work loop.
+// It is called on package initialization and is only needed after Shutdown.
+func Init() {
+	if stopWork != nil {
+		return
+	}
+
+	coapMemory = C.malloc(C.size_t(coapMemorySize))
+	log.Println("Allocated C memory at", coapMemory)
 
 	api := C.CoAP_API_t{}
 
@@ -93,20 +180,62 @@ func init() {
 	api.rtc1HzCnt = (*[0]byte)(unsafe.Pointer(C.go_rtc1HzCnt))
 	cfg := C.CoAP_Config_t{}
 
-	cfg.Memory = (*C.uint8_t)(unsafe.Pointer(&coapMemory))
-	cfg.MemorySize = C.int16_t(len(coapMemory))
+	cfg.Memory = (*C.uint8_t)(coapMemory)
+	cfg.MemorySize = C.int16_t(coapMemorySize)
 
 	C.CoAP_Init(api, cfg)
 
-	go func() {
-		for {
-			DoWork()
-			<-time.After(100 * time.Millisecond)
+	stopWork = make(chan struct{})
+	workDone = make(chan struct{})
+	go work(stopWork, workDone)
+}
+
+func work(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		DoWork()
+		select {
+		case <-stop:
+			return
+		case <-time.After(100 * time.Millisecond):
 		}
-	}()
+	}
+}
+
+// Shutdown stops the work loop and frees all C memory of the stack.
+// Sockets and resources created before are invalid afterwards.
+// Call Init to use the package again.
+func Shutdown() {
+	if stopWork == nil {
+		return
+	}
+	close(stopWork)
+	<-workDone
+	stopWork = nil
+	workDone = nil
+
+	for _, s := range cStrings {
+		C.free(s)
+	}
+	cStrings = nil
+	resources = make(map[string]*Resource)
+	inUse = false
+
+	C.free(coapMemory)
+	coapMemory = nil
+
+	// Drop responses that were never consumed
+	for {
+		select {
+		case <-PendingResponses:
+		default:
+			return
+		}
+	}
 }
 
 func NewSocket() Socket {
+	inUse = true
 	currentHandle++
 	cSocket := C.CreateSocket(unsafe.Pointer(currentHandle))
 
@@ -114,6 +243,11 @@ func NewSocket() Socket {
 }
 
 func HandleIncomingIPv4Packet(socket Socket, senderIp net.IP, senderPort int, data []byte) {
+	remoteEp := CoapEndpoint{Type: EP_IPV4, Ip: senderIp, Port: senderPort}
+	if handleWellKnownCore(socket, remoteEp, data) || handleSubtree(socket, remoteEp, data) {
+		return
+	}
+
 	cData := C.CBytes(data)
 	defer C.free(cData)
 
@@ -127,6 +261,11 @@ func HandleIncomingIPv4Packet(socket Socket, senderIp net.IP, senderPort int, da
 }
 
 func HandleIncomingUartPacket(socket Socket, senderPort byte, data []byte) {
+	remoteEp := CoapEndpoint{Type: EP_UART, ComPort: senderPort}
+	if handleWellKnownCore(socket, remoteEp, data) || handleSubtree(socket, remoteEp, data) {
+		return
+	}
+
 	cData := C.CBytes(data)
 	defer C.free(cData)
 
@@ -147,23 +286,32 @@ func DoWork() {
 // typedef CoAP_HandlerResult_t (*CoAP_ResourceHandler_fPtr_t)(CoAP_Message_t* pReq, CoAP_Message_t* pResp);
 // typedef CoAP_HandlerResult_t (*CoAP_ResourceNotifier_fPtr_t)(CoAP_Observer_t* pListObservers, CoAP_Message_t* pResp);
 func CreateResource(uri string, description string, allowedMethods ...coapmsg.COAPCode) *Resource {
+	inUse = true
 	opts := C.CoAP_ResOpts_t{}
 
 	for _, m := range allowedMethods {
 		opts.AllowedMethods |= 1 << m.Detail()
 	}
 	resourceHandler := (*[0]byte)(unsafe.Pointer(C.go_ResourceHandler))
-	res := C.CoAP_CreateResource(C.CString(uri), C.CString(description), opts, resourceHandler, nil)
+	cUri := C.CString(uri)
+	cDescription := C.CString(description)
+	res := C.CoAP_CreateResource(cUri, cDescription, opts, resourceHandler, nil)
 
 	if res == nil {
+		C.free(unsafe.Pointer(cUri))
+		C.free(unsafe.Pointer(cDescription))
 		return nil
 	}
+	cStrings = append(cStrings, unsafe.Pointer(cUri), unsafe.Pointer(cDescription))
 
 	msg := coapmsg.Message{}
 	msg.SetPathString(uri)
 
 	resource := &Resource{
-		ref: unsafe.Pointer(res),
+		ref:            unsafe.Pointer(res),
+		uri:            msg.PathString(),
+		description:    description,
+		allowedMethods: allowedMethods,
 	}
 
 	resources[msg.PathString()] = resource
@@ -171,6 +319,17 @@ func CreateResource(uri string, description string, allowedMethods ...coapmsg.CO
 	return resource
 }
 
+// CreateSubtreeResource creates a resource that also handles all paths below uri,
+// e.g. /sensors/42 for /sensors, see Resource.Suffix. Resources created for the
+// exact path take precedence. Requests below uri are answered piggybacked.
+func CreateSubtreeResource(uri string, description string, allowedMethods ...coapmsg.COAPCode) *Resource {
+	resource := CreateResource(uri, description, allowedMethods...)
+	if resource != nil {
+		resource.subtree = true
+	}
+	return resource
+}
+
 //export go_rtc1HzCnt
 func go_rtc1HzCnt() C.uint32_t {
 	return C.uint32_t(time.Now().Unix())
@@ -0,0 +1,34 @@
+package liblobarocoap
+
+import (
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
+)
+
+// newResponse prepares the response to a request answered
+// without the C stack, piggybacked for CON requests
+func newResponse(req coapmsg.Message) coapmsg.Message {
+	res := coapmsg.Message{
+		Type:      coapmsg.NonConfirmable,
+		MessageID: req.MessageID,
+		Token:     req.Token,
+	}
+	if req.IsConfirmable() {
+		res.Type = coapmsg.Acknowledgement
+	}
+	return res
+}
+
+// sendResponse hands res over to PendingResponses like the C stack does
+func sendResponse(socket Socket, remoteEp CoapEndpoint, res coapmsg.Message) {
+	msgBytes, err := res.MarshalBinary()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal response")
+		return
+	}
+	PendingResponses <- Packet{
+		Data:     msgBytes,
+		RemoteEp: remoteEp,
+		Socket:   socket,
+	}
+}
@@ -0,0 +1,76 @@
+package liblobarocoap
+
+import (
+	"strings"
+
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
+)
+
+// Suffix returns the path of req below the resource, e.g. "42" for a
+// request to /sensors/42 handled by the subtree resource /sensors.
+// It is empty for requests to the resource itself.
+func (r *Resource) Suffix(req coapmsg.Message) string {
+	path := req.PathString()
+	switch {
+	case path == r.uri:
+		return ""
+	case r.uri == "":
+		return path
+	}
+	return strings.TrimPrefix(path, r.uri+"/")
+}
+
+// findSubtree returns the subtree resource with the longest
+// path that is a prefix of path, excluding path itself
+func findSubtree(path []string) *Resource {
+	for i := len(path) - 1; i >= 0; i-- {
+		if r, ok := resources[strings.Join(path[:i], "/")]; ok && r.subtree {
+			return r
+		}
+	}
+	return nil
+}
+
+// handleSubtree dispatches requests below a subtree resource, the C stack
+// only finds resources by their exact URI. The response is always piggybacked,
+// a POSTPONE of the handler is treated like OK.
+// It returns false when the packet must be handled by the C stack.
+func handleSubtree(socket Socket, remoteEp CoapEndpoint, data []byte) bool {
+	req, err := coapmsg.ParseMessage(data)
+	if err != nil || req.Code == coapmsg.Empty || req.Code.Class() != 0 {
+		return false
+	}
+	if _, exact := resources[req.PathString()]; exact {
+		return false
+	}
+	resource := findSubtree(req.Path())
+	if resource == nil {
+		return false
+	}
+
+	res := newResponse(req)
+	switch {
+	case !resource.allowsMethod(req.Code):
+		res.Code = coapmsg.MethodNotAllowed
+	case resource.Handler == nil:
+		logrus.WithField("ReqPath", req.PathString()).Error("Missing Handler")
+		res.Code = coapmsg.InternalServerError
+	default:
+		if resource.Handler(req, &res) == ERROR {
+			res.Code = coapmsg.InternalServerError
+		}
+	}
+
+	sendResponse(socket, remoteEp, res)
+	return true
+}
+
+func (r *Resource) allowsMethod(code coapmsg.COAPCode) bool {
+	for _, m := range r.allowedMethods {
+		if m == code {
+			return true
+		}
+	}
+	return false
+}
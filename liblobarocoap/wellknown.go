@@ -0,0 +1,67 @@
+package liblobarocoap
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/lobaro/coap-go/coapmsg"
+	"github.com/sirupsen/logrus"
+)
+
+// AppLinkFormatJSON is the content format of application/link-format+json
+const AppLinkFormatJSON = 504
+
+const wellKnownCore = ".well-known/core"
+
+// link is one entry of the application/link-format+json serialization
+type link struct {
+	Href  string `json:"href"`
+	Title string `json:"title,omitempty"`
+	Cf    string `json:"cf,omitempty"`
+}
+
+// handleWellKnownCore answers discovery requests that Accept another format
+// than link-format text, the only format the C stack can serialize.
+// It returns false when the packet must be handled by the C stack.
+func handleWellKnownCore(socket Socket, remoteEp CoapEndpoint, data []byte) bool {
+	req, err := coapmsg.ParseMessage(data)
+	if err != nil || req.Code != coapmsg.GET || req.PathString() != wellKnownCore {
+		return false
+	}
+	accept := req.Options().Get(coapmsg.Accept)
+	if accept.IsNotSet() || accept.AsUInt16() == uint16(coapmsg.AppLinkFormat) {
+		return false
+	}
+
+	res := newResponse(req)
+	switch accept.AsUInt16() {
+	case AppLinkFormatJSON:
+		payload, err := linkFormatJSON()
+		if err != nil {
+			logrus.WithError(err).Error("Failed to serialize .well-known/core")
+			res.Code = coapmsg.InternalServerError
+			break
+		}
+		res.Code = coapmsg.Content
+		res.Options().Set(coapmsg.ContentFormat, uint16(AppLinkFormatJSON))
+		res.Payload = payload
+	default:
+		res.Code = coapmsg.NotAcceptable
+	}
+
+	sendResponse(socket, remoteEp, res)
+	return true
+}
+
+// linkFormatJSON lists the same links as the link-format text of the C stack
+func linkFormatJSON() ([]byte, error) {
+	links := []link{{Href: "/" + wellKnownCore}}
+	for _, r := range Resources() {
+		links = append(links, link{
+			Href:  r.URI,
+			Title: r.Description,
+			Cf:    strconv.Itoa(int(r.ContentFormat)),
+		})
+	}
+	return json.Marshal(links)
+}